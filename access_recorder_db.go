@@ -0,0 +1,115 @@
+package bsmt
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	_ TreeDB  = (*AccessRecorderDB)(nil)
+	_ Flusher = (*AccessRecorderDB)(nil)
+)
+
+// AccessStats counts reads made through an AccessRecorderDB.
+type AccessStats struct {
+	enabled int32
+	depthFn func(key []byte) int
+
+	lock   sync.Mutex
+	depths map[int]uint64
+	keys   map[string]uint64
+}
+
+// KeyAccess is the read count of a single db key.
+type KeyAccess struct {
+	Key   []byte
+	Reads uint64
+}
+
+// SetEnabled starts or stops recording. A stopped recorder costs one atomic
+// load per read.
+func (stats *AccessStats) SetEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&stats.enabled, v)
+}
+
+func (stats *AccessStats) record(key []byte) {
+	if atomic.LoadInt32(&stats.enabled) == 0 {
+		return
+	}
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.keys[string(key)]++
+	if stats.depthFn != nil {
+		stats.depths[stats.depthFn(key)]++
+	}
+}
+
+// DepthReads returns the number of reads per depth.
+func (stats *AccessStats) DepthReads() map[int]uint64 {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	depths := make(map[int]uint64, len(stats.depths))
+	for depth, reads := range stats.depths {
+		depths[depth] = reads
+	}
+	return depths
+}
+
+// TopKeys returns the k most read keys, most read first.
+func (stats *AccessStats) TopKeys(k int) []KeyAccess {
+	stats.lock.Lock()
+	accesses := make([]KeyAccess, 0, len(stats.keys))
+	for key, reads := range stats.keys {
+		accesses = append(accesses, KeyAccess{Key: []byte(key), Reads: reads})
+	}
+	stats.lock.Unlock()
+	sort.Slice(accesses, func(i, j int) bool {
+		return accesses[i].Reads > accesses[j].Reads
+	})
+	if len(accesses) > k {
+		accesses = accesses[:k]
+	}
+	return accesses
+}
+
+// AccessRecorderDB records the reads of the wrapped TreeDB for cache
+// tuning.
+type AccessRecorderDB struct {
+	inner TreeDB
+	stats *AccessStats
+}
+
+// NewAccessRecorderDB wraps inner and returns the stats it records into.
+// depthFn maps a db key to the tree depth of its node, and may be nil to
+// skip per-depth counts. Recording starts enabled.
+func NewAccessRecorderDB(inner TreeDB, depthFn func(key []byte) int) (*AccessRecorderDB, *AccessStats) {
+	stats := &AccessStats{
+		enabled: 1,
+		depthFn: depthFn,
+		depths:  make(map[int]uint64),
+		keys:    make(map[string]uint64),
+	}
+	return &AccessRecorderDB{inner: inner, stats: stats}, stats
+}
+
+func (db *AccessRecorderDB) Get(key []byte) ([]byte, error) {
+	db.stats.record(key)
+	return db.inner.Get(key)
+}
+
+func (db *AccessRecorderDB) Has(key []byte) (bool, error) {
+	db.stats.record(key)
+	return db.inner.Has(key)
+}
+
+func (db *AccessRecorderDB) Set(key []byte, value []byte) error { return db.inner.Set(key, value) }
+func (db *AccessRecorderDB) Delete(key []byte) error            { return db.inner.Delete(key) }
+func (db *AccessRecorderDB) NewBatch() Batcher                  { return db.inner.NewBatch() }
+func (db *AccessRecorderDB) Ping(ctx context.Context) error     { return db.inner.Ping(ctx) }
+func (db *AccessRecorderDB) Flush() error                       { return flushDB(db.inner) }
@@ -0,0 +1,40 @@
+package bsmt
+
+import "testing"
+
+func TestAccessRecorderDB(t *testing.T) {
+	backend := newFailingDB(0)
+	db, stats := NewAccessRecorderDB(backend, func(key []byte) int { return len(key) })
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.Get([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Failed reads are counted too and their error is passed on.
+	backend.failures = 1
+	if _, err := db.Get([]byte("bb")); err != errBackend {
+		t.Fatalf("got %v, want the backend error", err)
+	}
+	if _, err := db.Has([]byte("bb")); err != nil {
+		t.Fatal(err)
+	}
+
+	top := stats.TopKeys(1)
+	if len(top) != 1 || string(top[0].Key) != "a" || top[0].Reads != 3 {
+		t.Fatalf("got top keys %+v, want a read 3 times", top)
+	}
+	if depths := stats.DepthReads(); depths[1] != 3 || depths[2] != 2 {
+		t.Fatalf("got depth reads %v", depths)
+	}
+
+	stats.SetEnabled(false)
+	if _, err := db.Get([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if top := stats.TopKeys(1); top[0].Reads != 3 {
+		t.Fatal("disabled recorder still counts reads")
+	}
+}
@@ -0,0 +1,136 @@
+package bsmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	annotationsKeyPrefix string = "annotations"
+	// annotationsIndexKey lists the retained versions with annotations, so
+	// pruning and rollback touch only those.
+	annotationsIndexKey string = "annotationsIndex"
+)
+
+func annotationsKey(version Version) []byte {
+	return append([]byte(annotationsKeyPrefix), encodeVersion(uint64(version))...)
+}
+
+// writeAnnotations adds the annotations of the version being committed to
+// batch, as a sequence of key, value pairs sorted by key, and drops those
+// of versions below recentVersion or at version and above, left by a
+// commit that was rolled back.
+func (tree *BASSparseMerkleTree) writeAnnotations(batch Batcher, version, recentVersion Version, anns map[string][]byte) error {
+	kept, trimmed, err := tree.trimAnnotations(batch, recentVersion, version)
+	if err != nil {
+		return err
+	}
+	if anns == nil && !trimmed {
+		return nil
+	}
+	if anns != nil {
+		keys := make([]string, 0, len(anns))
+		for key := range anns {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var buf bytes.Buffer
+		for _, key := range keys {
+			putBytes(&buf, []byte(key))
+			putBytes(&buf, anns[key])
+		}
+		atomic.AddUint64(&tree.metrics.bytesWritten, uint64(buf.Len()))
+		if err := batch.Set(annotationsKey(version), buf.Bytes()); err != nil {
+			return err
+		}
+		kept = append(kept, version)
+	}
+	return tree.writeAnnotationsIndex(batch, kept)
+}
+
+// trimAnnotations adds the deletion of the annotations of the indexed
+// versions outside [from, to) to batch and returns the others and whether
+// any was deleted.
+func (tree *BASSparseMerkleTree) trimAnnotations(batch Batcher, from, to Version) ([]Version, bool, error) {
+	versions, err := tree.annotatedVersions()
+	if err != nil {
+		return nil, false, err
+	}
+	var kept []Version
+	for _, version := range versions {
+		if version >= from && version < to {
+			kept = append(kept, version)
+			continue
+		}
+		if err := batch.Delete(annotationsKey(version)); err != nil {
+			return nil, false, err
+		}
+	}
+	return kept, len(kept) != len(versions), nil
+}
+
+// annotatedVersions reads the index of the versions with annotations.
+func (tree *BASSparseMerkleTree) annotatedVersions() ([]Version, error) {
+	data, err := tree.dbGet([]byte(annotationsIndexKey))
+	if err == ErrDatabaseNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		version, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrInvalidNodeEncoding
+		}
+		versions = append(versions, Version(version))
+	}
+	return versions, nil
+}
+
+func (tree *BASSparseMerkleTree) writeAnnotationsIndex(batch Batcher, versions []Version) error {
+	if len(versions) == 0 {
+		return batch.Delete([]byte(annotationsIndexKey))
+	}
+	var buf bytes.Buffer
+	for _, version := range versions {
+		putUvarint(&buf, uint64(version))
+	}
+	return batch.Set([]byte(annotationsIndexKey), buf.Bytes())
+}
+
+// Annotations returns the annotations stored for version by
+// CommitWithAnnotations.
+func (tree *BASSparseMerkleTree) Annotations(version Version) (map[string][]byte, error) {
+	tree.lock.RLock()
+	recent := tree.recentVersion
+	tree.lock.RUnlock()
+	if uint64(version) < recent {
+		return nil, ErrVersionTooOld
+	}
+	if tree.db == nil {
+		return nil, ErrDatabaseRequired
+	}
+	record, err := tree.db.Get(annotationsKey(version))
+	if err != nil {
+		return nil, err
+	}
+	anns := make(map[string][]byte)
+	r := bytes.NewReader(record)
+	for r.Len() > 0 {
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		anns[string(key)] = value
+	}
+	return anns, nil
+}
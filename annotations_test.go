@@ -0,0 +1,62 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAnnotationsFollowVersions(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	commit := func(i int, anns map[string][]byte, recent *Version) {
+		t.Helper()
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.CommitWithAnnotations(anns, recent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit(1, map[string][]byte{"block": []byte("1")}, nil)
+	commit(2, map[string][]byte{"block": []byte("2")}, nil)
+	if err := tree.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Annotations(2); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v, want the annotations of a rolled back version gone", err)
+	}
+	commit(3, nil, nil)
+	if _, err := tree.Annotations(2); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v, want no annotations for a version committed without", err)
+	}
+	anns, err := tree.Annotations(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(anns["block"], []byte("1")) {
+		t.Fatal("annotations of version 1 read back differently")
+	}
+
+	recent := Version(3)
+	commit(4, map[string][]byte{"block": []byte("3")}, &recent)
+	if _, err := db.Get(annotationsKey(1)); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v, want the annotations of a pruned version deleted", err)
+	}
+	versions, err := tree.annotatedVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0] != 3 {
+		t.Fatalf("index lists versions %v, want [3]", versions)
+	}
+}
+
+func TestAnnotationsWithoutDB(t *testing.T) {
+	tree := newTestTree(t)
+	if _, err := tree.CommitWithAnnotations(map[string][]byte{"block": nil}, nil); err != ErrDatabaseRequired {
+		t.Fatalf("got %v, want ErrDatabaseRequired", err)
+	}
+	if _, err := tree.Annotations(0); err != ErrDatabaseRequired {
+		t.Fatalf("got %v, want ErrDatabaseRequired", err)
+	}
+}
@@ -0,0 +1,119 @@
+package bsmt
+
+import "bytes"
+
+// BatchProof holds the proofs of many keys against the same root with every
+// distinct sibling hash stored once in Hashes and referenced by index from
+// each key.
+type BatchProof struct {
+	Version Version
+	Root    []byte
+	Hashes  [][]byte
+	PerKey  []BatchProofEntry
+}
+
+type BatchProofEntry struct {
+	Key         []byte
+	Leaf        []byte
+	Indices     []int
+	ProofHelper []int
+}
+
+// NewBatchProof deduplicates the sibling hashes of proofs into a BatchProof.
+// All proofs must share the version and root of the first one.
+func NewBatchProof(proofs []Proof) (*BatchProof, error) {
+	bp := &BatchProof{PerKey: make([]BatchProofEntry, len(proofs))}
+	if len(proofs) > 0 {
+		bp.Version, bp.Root = proofs[0].Version, proofs[0].Root
+	}
+	indexOf := make(map[string]int)
+	for i := range proofs {
+		if proofs[i].Version != bp.Version || !bytes.Equal(proofs[i].Root, bp.Root) {
+			return nil, ErrInvalidBatchProof
+		}
+		siblings := proofs[i].MerkleProof
+		entry := BatchProofEntry{
+			Key:         proofs[i].Key,
+			Leaf:        proofs[i].Leaf,
+			Indices:     make([]int, len(siblings)),
+			ProofHelper: proofs[i].ProofHelper,
+		}
+		for j, sibling := range siblings {
+			index, ok := indexOf[string(sibling)]
+			if !ok {
+				index = len(bp.Hashes)
+				indexOf[string(sibling)] = index
+				bp.Hashes = append(bp.Hashes, sibling)
+			}
+			entry.Indices[j] = index
+		}
+		bp.PerKey[i] = entry
+	}
+	return bp, nil
+}
+
+// Proofs expands the batch back into per-key proofs.
+func (bp *BatchProof) Proofs() ([]Proof, error) {
+	proofs := make([]Proof, len(bp.PerKey))
+	for i, entry := range bp.PerKey {
+		siblings := make([][]byte, len(entry.Indices))
+		for j, index := range entry.Indices {
+			if index < 0 || index >= len(bp.Hashes) {
+				return nil, ErrInvalidBatchProof
+			}
+			siblings[j] = bp.Hashes[index]
+		}
+		proofs[i] = Proof{
+			Key:         entry.Key,
+			Version:     bp.Version,
+			Root:        bp.Root,
+			Leaf:        entry.Leaf,
+			MerkleProof: siblings,
+			ProofHelper: entry.ProofHelper,
+		}
+	}
+	return proofs, nil
+}
+
+// VerifyBatchProof verifies every key of the batch against the current root
+// and returns the index of the first failing key, or -1.
+func (tree *BASSparseMerkleTree) VerifyBatchProof(bp *BatchProof) (bool, int) {
+	proofs, err := bp.Proofs()
+	if err != nil {
+		return false, 0
+	}
+	keys := make([][]byte, len(proofs))
+	for i := range proofs {
+		keys[i] = proofs[i].Key
+	}
+	return tree.VerifyProofs(keys, proofs)
+}
+
+// VerifyMultiProofStandalone verifies bp for keys, in the order of its
+// entries, against root without a tree instance: a light client needs only
+// the depth, hasher and empty subtree hashes (see NilHashes) of the tree
+// that produced it. keys are taken as paths, so trees using
+// WithPathEncoding must pass encoded keys. It returns the index of the
+// first failing key, or -1.
+func VerifyMultiProofStandalone(maxDepth uint8, hasher *Hasher, nilHashes [][]byte, root []byte, keys [][]byte, bp *BatchProof) (bool, int) {
+	if bp == nil || len(keys) != len(bp.PerKey) {
+		return false, 0
+	}
+	proofs, err := bp.Proofs()
+	if err != nil {
+		return false, 0
+	}
+	verifier := &BASSparseMerkleTree{
+		maxDepth:  maxDepth,
+		hasher:    hasher,
+		nilHashes: nilHashes,
+		metrics:   &metrics{},
+	}
+	for i := range proofs {
+		if !bytes.Equal(proofs[i].Key, keys[i]) || !bytes.Equal(proofs[i].Root, root) ||
+			!verifier.VerifyProof(proofs[i]) {
+			return false, i
+		}
+	}
+	return true, -1
+}
@@ -0,0 +1,118 @@
+package bsmt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyMultiProofStandalone(t *testing.T) {
+	tree := newTestTree(t)
+	for i := 0; i < 256; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Every other key is absent, so the batch mixes inclusion and absence.
+	var keys [][]byte
+	var proofs []Proof
+	for i := 0; i < 64; i += 2 {
+		key := testKey(i * 5)
+		proof, err := tree.GetProof(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		proofs = append(proofs, proof)
+	}
+	bp, err := NewBatchProof(proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The light client only holds the public parameters and the root.
+	maxDepth, hasher := uint8(64), NewHasher(sha256.New())
+	nilHashes := append([][]byte{}, tree.NilHashes()...)
+	root := append([]byte{}, tree.Root()...)
+	if ok, i := VerifyMultiProofStandalone(maxDepth, hasher, nilHashes, root, keys, bp); !ok {
+		t.Fatalf("standalone verification fails at key %d", i)
+	}
+
+	if ok, _ := VerifyMultiProofStandalone(maxDepth, hasher, nilHashes, tree.nilHashes[0], keys, bp); ok {
+		t.Fatal("multiproof verifies against another root")
+	}
+	swapped := append([][]byte{}, keys...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	if ok, i := VerifyMultiProofStandalone(maxDepth, hasher, nilHashes, root, swapped, bp); ok || i != 0 {
+		t.Fatalf("got %v at %d for keys out of order, want a failure at 0", ok, i)
+	}
+	if ok, _ := VerifyMultiProofStandalone(maxDepth, hasher, nilHashes, root, keys[1:], bp); ok {
+		t.Fatal("multiproof verifies for fewer keys than it holds")
+	}
+	bp.PerKey[3].Leaf = testValue(1000)
+	if ok, i := VerifyMultiProofStandalone(maxDepth, hasher, nilHashes, root, keys, bp); ok || i != 3 {
+		t.Fatalf("got %v at %d for a tampered leaf, want a failure at 3", ok, i)
+	}
+}
+
+func TestBatchProof(t *testing.T) {
+	tree := newTestTree(t)
+	roots := commitVersions(t, tree, 128)
+	var proofs []Proof
+	siblings := 0
+	for i := 0; i < 32; i++ {
+		proof, err := tree.GetProof(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, proof)
+		siblings += len(proof.MerkleProof)
+	}
+	bp, err := NewBatchProof(proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Proofs of nearby keys share their upper siblings and all empty ones.
+	if len(bp.Hashes) >= siblings/4 {
+		t.Fatalf("batch holds %d hashes for %d siblings", len(bp.Hashes), siblings)
+	}
+	expanded, err := bp.Proofs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expanded, proofs) {
+		t.Fatal("expanded batch differs from the proofs it was built from")
+	}
+	if ok, i := tree.VerifyBatchProof(bp); !ok || i != -1 {
+		t.Fatalf("got %v at %d, want the batch to verify", ok, i)
+	}
+
+	version := Version(2)
+	old, err := tree.GetProof(testKey(0), &version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old.Root, roots[2]) {
+		t.Fatal("proof at version 2 is not against its root")
+	}
+	if _, err := NewBatchProof(append(proofs[:1:1], old)); err != ErrInvalidBatchProof {
+		t.Fatalf("got %v for proofs of two roots, want ErrInvalidBatchProof", err)
+	}
+
+	bp.PerKey[5].Leaf = testValue(1000)
+	if ok, i := tree.VerifyBatchProof(bp); ok || i != 5 {
+		t.Fatalf("got %v at %d for a tampered leaf, want a failure at 5", ok, i)
+	}
+	bp.PerKey[5].Leaf = proofs[5].Leaf
+	bp.PerKey[2].Indices[0] = len(bp.Hashes)
+	if _, err := bp.Proofs(); err != ErrInvalidBatchProof {
+		t.Fatalf("got %v for an index past the hashes, want ErrInvalidBatchProof", err)
+	}
+	if ok, _ := tree.VerifyBatchProof(bp); ok {
+		t.Fatal("a batch with an index past the hashes verifies")
+	}
+}
@@ -0,0 +1,76 @@
+package bsmt
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	bloomFilterBits   = 1 << 23
+	bloomFilterHashes = 4
+)
+
+// bloomFilter is a fixed-size Bloom filter of set keys. It never forgets a
+// key, so after deletes or rollbacks it is a superset of the set keys and a
+// positive answer always falls through to the real read.
+type bloomFilter struct {
+	lock sync.RWMutex
+	bits []byte
+}
+
+func newBloomFilter() *bloomFilter {
+	return &bloomFilter{bits: make([]byte, bloomFilterBits/8)}
+}
+
+func (f *bloomFilter) locations(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return sum, sum>>33 | 1
+}
+
+func (f *bloomFilter) add(key []byte) {
+	h1, h2 := f.locations(key)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i := uint64(0); i < bloomFilterHashes; i++ {
+		bit := (h1 + i*h2) % bloomFilterBits
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// mayContain reports false only if key was never added.
+func (f *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := f.locations(key)
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	for i := uint64(0); i < bloomFilterHashes; i++ {
+		bit := (h1 + i*h2) % bloomFilterBits
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// loadBloomFilter restores the persisted filter, keeping an empty one when
+// none has been stored yet.
+func (tree *BASSparseMerkleTree) loadBloomFilter() error {
+	data, err := tree.db.Get([]byte(keyBloomFilterKey))
+	if err == ErrDatabaseNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == len(tree.keyFilter.bits) {
+		copy(tree.keyFilter.bits, data)
+	}
+	return nil
+}
+
+func (tree *BASSparseMerkleTree) saveBloomFilter() error {
+	tree.keyFilter.lock.RLock()
+	defer tree.keyFilter.lock.RUnlock()
+	return tree.db.Set([]byte(keyBloomFilterKey), tree.keyFilter.bits)
+}
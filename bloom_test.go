@@ -0,0 +1,60 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyBloomFilter(t *testing.T) {
+	const keys = 64
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), WithKeyBloomFilter())
+	commitVersions(t, tree, keys)
+	if err := tree.Delete(testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Set(testKey(keys), testValue(keys)); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := tree.Get(testKey(keys), nil); err != nil || !bytes.Equal(val, testValue(keys)) {
+		t.Fatalf("got %x, %v for a staged key", val, err)
+	}
+
+	unknownReads := func(tree *BASSparseMerkleTree) uint64 {
+		tree.ResetStats()
+		for i := 1000; i < 1100; i++ {
+			val, err := tree.Get(testKey(i), nil)
+			if err != nil || val != nil {
+				t.Fatalf("got %x, %v for a key never set", val, err)
+			}
+		}
+		return tree.Stats().DBReads
+	}
+	if reads := unknownReads(newTestTree(t, WithCustomDB(db))); reads == 0 {
+		t.Fatal("reads of unknown keys do not reach the db without the filter")
+	}
+	reopened := newTestTree(t, WithCustomDB(db), WithKeyBloomFilter())
+	if reads := unknownReads(reopened); reads != 0 {
+		t.Fatalf("reads of unknown keys took %d db reads with the persisted filter", reads)
+	}
+	for i := 0; i < keys; i++ {
+		val, err := reopened.Get(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := testValue(i * 3)
+		if i == 1 {
+			want = nil
+		}
+		if !bytes.Equal(val, want) {
+			t.Fatalf("key %d reads %x through the filter", i, val)
+		}
+	}
+
+	if _, err := NewBASSparseMerkleTree(WithKeyBloomFilter()); err != ErrDatabaseRequired {
+		t.Fatalf("got %v without a db, want ErrDatabaseRequired", err)
+	}
+}
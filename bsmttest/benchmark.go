@@ -0,0 +1,103 @@
+// Package bsmttest holds helpers for testing and benchmarking bsmt trees
+// from other packages, kept apart so the tree itself does not import
+// testing.
+package bsmttest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	bsmt "bas-smt"
+)
+
+// BenchmarkTree benchmarks Set, Commit, GetProof and VerifyProof of a tree
+// with hasher, db and maxDepth holding nKeys keys, one sub-benchmark per
+// operation with allocations reported, so hashers and backends can be
+// compared on equal terms from a downstream Benchmark function. A nil
+// hasher benchmarks the default SHA-256 one; a nil db benchmarks the tree
+// alone.
+func BenchmarkTree(b *testing.B, hasher *bsmt.Hasher, db bsmt.TreeDB, maxDepth uint8, nKeys int) {
+	if nKeys < 1 {
+		b.Fatal("BenchmarkTree needs at least one key")
+	}
+	if hasher == nil {
+		hasher = bsmt.NewHasher(sha256.New())
+	}
+	newTree := func() bsmt.SparseMerkleTree {
+		opts := []bsmt.Option{bsmt.WithHasher(hasher), bsmt.WithMaxDepth(maxDepth)}
+		if db != nil {
+			opts = append(opts, bsmt.WithCustomDB(db))
+		}
+		tree, err := bsmt.NewBASSparseMerkleTree(opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return tree
+	}
+	keys := make([][]byte, nKeys)
+	for i := range keys {
+		seed := make([]byte, 8)
+		binary.BigEndian.PutUint64(seed, uint64(i))
+		keys[i] = hasher.Hash(seed)[:(int(maxDepth)+7)/8]
+	}
+	val := hasher.Hash([]byte("bsmt:benchmark"))
+
+	b.Run("Set", func(b *testing.B) {
+		tree := newTree()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := tree.Set(keys[i%nKeys], val); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// Every Commit iteration sets new values for all keys, so each commit
+	// rewrites every node on their paths.
+	b.Run("Commit", func(b *testing.B) {
+		tree := newTree()
+		round := make([]byte, 8)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			binary.BigEndian.PutUint64(round, uint64(i))
+			for _, key := range keys {
+				if err := tree.Set(key, hasher.Hash(key, round)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StartTimer()
+			if _, err := tree.Commit(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	tree := newTree()
+	for _, key := range keys {
+		if err := tree.Set(key, val); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	proofs := make([]bsmt.Proof, nKeys)
+	b.Run("GetProof", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			proof, err := tree.GetProof(keys[i%nKeys], nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			proofs[i%nKeys] = proof
+		}
+	})
+	b.Run("VerifyProof", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tree.VerifyProof(proofs[i%nKeys])
+		}
+	})
+}
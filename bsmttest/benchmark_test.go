@@ -0,0 +1,15 @@
+package bsmttest
+
+import (
+	"testing"
+
+	bsmt "bas-smt"
+)
+
+func BenchmarkSHA256(b *testing.B) {
+	BenchmarkTree(b, nil, nil, 64, 1000)
+}
+
+func BenchmarkSHA256FastMemoryDB(b *testing.B) {
+	BenchmarkTree(b, nil, bsmt.NewFastMemoryDB(0), 64, 1000)
+}
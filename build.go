@@ -0,0 +1,70 @@
+package bsmt
+
+const importProgressKey string = "importProgress"
+
+// BuildFrom sets kvs in order and commits them. Every checkpointEvery keys
+// the staged keys are committed as a version of their own and only then is
+// the number of keys imported so far recorded, so an interrupted import can
+// be continued with ResumeBuild instead of starting over. Checkpoints need
+// a db; with checkpointEvery 0 all keys are committed as one version.
+func (tree *BASSparseMerkleTree) BuildFrom(kvs []KV, checkpointEvery int) (Version, error) {
+	if checkpointEvery > 0 && tree.db == nil {
+		return 0, ErrDatabaseRequired
+	}
+	return tree.build(kvs, 0, checkpointEvery)
+}
+
+// ResumeBuild continues an interrupted BuildFrom. kvs must be the same input
+// in the same order; the keys recorded as imported by the last checkpoint
+// are skipped.
+func (tree *BASSparseMerkleTree) ResumeBuild(kvs []KV, checkpointEvery int) (Version, error) {
+	if tree.db == nil {
+		return 0, ErrDatabaseRequired
+	}
+	data, err := tree.dbGet([]byte(importProgressKey))
+	if err != nil && err != ErrDatabaseNotFound {
+		return 0, err
+	}
+	var done uint64
+	if err == nil {
+		progress, err := decodeVersion(data)
+		if err != nil {
+			return 0, err
+		}
+		done = uint64(progress)
+	}
+	if done > uint64(len(kvs)) {
+		return 0, ErrInvalidImportProgress
+	}
+	return tree.build(kvs, int(done), checkpointEvery)
+}
+
+func (tree *BASSparseMerkleTree) build(kvs []KV, start, checkpointEvery int) (Version, error) {
+	for i := start; i < len(kvs); i++ {
+		if err := tree.Set(kvs[i].Key, kvs[i].Val); err != nil {
+			return 0, err
+		}
+		if checkpointEvery > 0 && (i+1)%checkpointEvery == 0 {
+			if _, err := tree.Commit(); err != nil {
+				return 0, err
+			}
+			if err := tree.db.Set([]byte(importProgressKey), encodeVersion(uint64(i+1))); err != nil {
+				return 0, err
+			}
+		}
+	}
+	// The last checkpoint already committed every key if it ended the input.
+	version := tree.LatestVersion()
+	if checkpointEvery == 0 || len(kvs) == 0 || len(kvs)%checkpointEvery != 0 {
+		var err error
+		if version, err = tree.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	if checkpointEvery > 0 {
+		if err := tree.db.Delete([]byte(importProgressKey)); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
@@ -0,0 +1,86 @@
+package bsmt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// crashingDB fails every batch write after the first writes ones, as if the
+// process died in the middle of a commit.
+type crashingDB struct {
+	*FastMemoryDB
+	writes int
+}
+
+func (db *crashingDB) NewBatch() Batcher {
+	return &crashingBatch{Batcher: db.FastMemoryDB.NewBatch(), db: db}
+}
+
+type crashingBatch struct {
+	Batcher
+	db *crashingDB
+}
+
+func (b *crashingBatch) Write() error {
+	if b.db.writes == 0 {
+		return errCrash
+	}
+	b.db.writes--
+	return b.Batcher.Write()
+}
+
+func testKVs(n int) []KV {
+	kvs := make([]KV, n)
+	for i := range kvs {
+		kvs[i] = KV{Key: testKey(i), Val: testValue(i)}
+	}
+	return kvs
+}
+
+func TestResumeBuildAfterCrash(t *testing.T) {
+	kvs := testKVs(100)
+	want := newTestTree(t)
+	if _, err := want.BuildFrom(kvs, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	db := &crashingDB{FastMemoryDB: NewFastMemoryDB(0), writes: 3}
+	crashed := newTestTree(t, WithCustomDB(db))
+	if _, err := crashed.BuildFrom(kvs, 16); err != errCrash {
+		t.Fatalf("got %v, want the simulated crash", err)
+	}
+
+	// Restart on what reached the db: three checkpoints of 16 keys.
+	resumed := newTestTree(t, WithCustomDB(db.FastMemoryDB))
+	if resumed.LatestVersion() != 3 {
+		t.Fatalf("restarted at version %d, want 3", resumed.LatestVersion())
+	}
+	for i := 0; i < 48; i++ {
+		val, err := resumed.Get(kvs[i].Key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, kvs[i].Val) {
+			t.Fatalf("checkpointed key %d was lost", i)
+		}
+	}
+	if _, err := resumed.ResumeBuild(kvs, 16); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resumed.CommittedRoot(), want.CommittedRoot()) {
+		t.Fatal("resumed build differs from an uninterrupted one")
+	}
+	if _, err := db.FastMemoryDB.Get([]byte(importProgressKey)); err != ErrDatabaseNotFound {
+		t.Fatal("import progress is left behind after the build completed")
+	}
+}
+
+func TestBuildCheckpointsNeedDB(t *testing.T) {
+	tree := newTestTree(t)
+	if _, err := tree.BuildFrom(testKVs(4), 2); err != ErrDatabaseRequired {
+		t.Fatalf("got %v, want ErrDatabaseRequired", err)
+	}
+}
@@ -0,0 +1,12 @@
+package bsmt
+
+import "time"
+
+// Clock is the source of time for time-dependent features.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,91 @@
+package bsmt
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	_ TreeDB  = (*CoalescingDB)(nil)
+	_ Flusher = (*CoalescingDB)(nil)
+)
+
+// MultiGetter is implemented by backends that read many keys in one round
+// trip, such as RemoteDB and FastMemoryDB. errs[i] is the error of keys[i],
+// ErrDatabaseNotFound if missing.
+type MultiGetter interface {
+	MultiGet(keys [][]byte) (values [][]byte, errs []error)
+}
+
+// CoalescingDB collects the Gets issued within a window and reads them from
+// the wrapped TreeDB in one MultiGet, or one Get per distinct key if it is
+// not a MultiGetter. Every Get waits up to window for the batch to close:
+// a longer window saves more round trips under concurrent proof serving
+// against network storage, at the cost of that much added latency for
+// every read, so it only pays off when round trips dominate.
+type CoalescingDB struct {
+	inner  TreeDB
+	window time.Duration
+
+	lock    sync.Mutex
+	pending *readBatch
+}
+
+// readBatch is the set of distinct keys read together.
+type readBatch struct {
+	keys   [][]byte
+	index  map[string]int
+	values [][]byte
+	errs   []error
+	done   chan struct{}
+}
+
+func NewCoalescingDB(inner TreeDB, window time.Duration) *CoalescingDB {
+	return &CoalescingDB{inner: inner, window: window}
+}
+
+func (db *CoalescingDB) Get(key []byte) ([]byte, error) {
+	db.lock.Lock()
+	batch := db.pending
+	if batch == nil {
+		batch = &readBatch{index: make(map[string]int), done: make(chan struct{})}
+		db.pending = batch
+		time.AfterFunc(db.window, func() { db.flush(batch) })
+	}
+	i, ok := batch.index[string(key)]
+	if !ok {
+		i = len(batch.keys)
+		batch.index[string(key)] = i
+		batch.keys = append(batch.keys, key)
+	}
+	db.lock.Unlock()
+	<-batch.done
+	return batch.values[i], batch.errs[i]
+}
+
+// flush closes batch to new keys, reads it and releases its waiters.
+func (db *CoalescingDB) flush(batch *readBatch) {
+	db.lock.Lock()
+	if db.pending == batch {
+		db.pending = nil
+	}
+	db.lock.Unlock()
+	if multi, ok := db.inner.(MultiGetter); ok {
+		batch.values, batch.errs = multi.MultiGet(batch.keys)
+	} else {
+		batch.values = make([][]byte, len(batch.keys))
+		batch.errs = make([]error, len(batch.keys))
+		for i, key := range batch.keys {
+			batch.values[i], batch.errs[i] = db.inner.Get(key)
+		}
+	}
+	close(batch.done)
+}
+
+func (db *CoalescingDB) Has(key []byte) (bool, error)       { return db.inner.Has(key) }
+func (db *CoalescingDB) Set(key []byte, value []byte) error { return db.inner.Set(key, value) }
+func (db *CoalescingDB) Delete(key []byte) error            { return db.inner.Delete(key) }
+func (db *CoalescingDB) NewBatch() Batcher                  { return db.inner.NewBatch() }
+func (db *CoalescingDB) Ping(ctx context.Context) error     { return db.inner.Ping(ctx) }
+func (db *CoalescingDB) Flush() error                       { return flushDB(db.inner) }
@@ -0,0 +1,74 @@
+package bsmt
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescingDBRoundTrips(t *testing.T) {
+	const readers = 32
+	backend := NewFastMemoryDB(0)
+	var gets, multiGets int32
+	handler := NewRemoteDBHandler(backend)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/get":
+			atomic.AddInt32(&gets, 1)
+		case "/multiget":
+			atomic.AddInt32(&multiGets, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	remote := NewRemoteDB(server.URL, "ns", nil)
+	for i := 0; i < readers; i += 2 {
+		if err := remote.Set([]byte(fmt.Sprint(i)), []byte(fmt.Sprint("value", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := NewCoalescingDB(remote, 50*time.Millisecond)
+	start := make(chan struct{})
+	errs := make(chan error, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			val, err := db.Get([]byte(fmt.Sprint(i)))
+			switch {
+			case i%2 == 1 && err != ErrDatabaseNotFound:
+				errs <- fmt.Errorf("key %d: got %v, want ErrDatabaseNotFound", i, err)
+			case i%2 == 0 && (err != nil || !bytes.Equal(val, []byte(fmt.Sprint("value", i)))):
+				errs <- fmt.Errorf("key %d: got %q, %v", i, val, err)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if gets != 0 || multiGets == 0 || multiGets >= readers {
+		t.Fatalf("backend saw %d gets and %d multigets for %d concurrent reads", gets, multiGets, readers)
+	}
+}
+
+func TestFastMemoryDBMultiGet(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	values, errs := db.MultiGet([][]byte{[]byte("a"), []byte("b")})
+	if errs[0] != nil || !bytes.Equal(values[0], []byte("1")) || errs[1] != ErrDatabaseNotFound {
+		t.Fatalf("got %q, %v", values, errs)
+	}
+}
@@ -0,0 +1,44 @@
+package bsmt
+
+// ChangedRoot is an internal node whose hash changed in a commit. Path holds
+// the Depth branch bits from the root, most significant bit first.
+type ChangedRoot struct {
+	Depth uint8
+	Path  []byte
+	Root  []byte
+}
+
+// CommitHook is called after every successful commit or rollback with the
+// internal nodes it changed. It runs under the tree lock and must not call back
+// into the tree.
+type CommitHook func(version Version, changes []ChangedRoot)
+
+// changedRoots collects the dirty nodes of the working tree, i.e. the nodes
+// rewritten by the pending commit or the rollback in progress.
+func (tree *BASSparseMerkleTree) changedRoots() []ChangedRoot {
+	if tree.root == nil {
+		return nil
+	}
+	var changes []ChangedRoot
+	var walk func(node TreeNode, path []byte, depth uint8)
+	walk = func(node TreeNode, path []byte, depth uint8) {
+		full, ok := node.(*FullTreeNode)
+		if !ok || !full.Dirty {
+			return
+		}
+		changes = append(changes, ChangedRoot{
+			Depth: depth,
+			Path:  append([]byte{}, path...),
+			Root:  full.LatestHash,
+		})
+		walk(full.LeftChild, path, depth+1)
+		right := append([]byte{}, path...)
+		if int(depth)/8 >= len(right) {
+			right = append(right, 0)
+		}
+		right[depth/8] |= 0x80 >> (depth % 8)
+		walk(full.RightChild, right, depth+1)
+	}
+	walk(tree.root, make([]byte, 0, 8), 0)
+	return changes
+}
@@ -0,0 +1,69 @@
+package bsmt
+
+import "bytes"
+
+// Compact rewrites the stored nodes without the history older than the
+// recent version, folding in the deltas of WithDeltaNodeWrites, and deletes
+// nodes left with no version at all. It needs an Iteratee db and must run
+// while no commit or rollback is in progress. A frozen tree returns
+// ErrTreeFrozen.
+func (tree *BASSparseMerkleTree) Compact() error {
+	iteratee, ok := tree.db.(Iteratee)
+	if !ok {
+		return ErrIteratorNotSupported
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.frozen {
+		return ErrTreeFrozen
+	}
+	batch := tree.db.NewBatch()
+	prefix := []byte(storageNodeKeyPrefix)
+	var keys [][]byte
+	var nodes []*StorageFullTreeNode
+	err := iteratee.Iterate(func(key, value []byte) error {
+		if !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		node, err := tree.decodeStoredNode(key, value)
+		if err != nil {
+			return err
+		}
+		folded := false
+		if tree.maxNodeDeltas > 0 {
+			deltas, err := tree.readDeltas(key)
+			if err != nil {
+				return err
+			}
+			if deltas != nil {
+				applyDeltas(node, deltas)
+				if err := batch.Delete(deltaNodeKey(key)); err != nil {
+					return err
+				}
+				folded = true
+			}
+		}
+		if len(node.Versions) == 0 {
+			return batch.Delete(key)
+		}
+		if !node.prune(Version(tree.recentVersion)) && !folded {
+			return nil
+		}
+		keys = append(keys, append([]byte{}, key...))
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	encoded, err := tree.encodeStoredNodes(nodes)
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := batch.Set(keys[i], encoded[i]); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
@@ -0,0 +1,83 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+// nodeBytes returns the total size of the stored blocks in db.
+func nodeBytes(t *testing.T, db *FastMemoryDB) int {
+	t.Helper()
+	n := 0
+	if err := db.Iterate(func(key, value []byte) error {
+		if bytes.HasPrefix(key, []byte(storageNodeKeyPrefix)) {
+			n += len(value)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCompact(t *testing.T) {
+	const keys, commits = 64, 6
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), WithVersionRetention(2))
+	// Every key changes in the first two versions, then only key 0, so
+	// the blocks of the others keep history older than the recent version.
+	for v := 1; v <= commits; v++ {
+		for i := 0; i < keys; i++ {
+			if v > 2 && i > 0 {
+				break
+			}
+			if err := tree.Set(testKey(i), testValue(i*v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recent := Version(commits - 2)
+	want := make(map[Version][]Proof)
+	for v := recent; v <= commits; v++ {
+		for i := 0; i < keys; i++ {
+			proof, err := tree.GetProof(testKey(i), &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want[v] = append(want[v], proof)
+		}
+	}
+	before := nodeBytes(t, db)
+
+	if err := tree.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if after := nodeBytes(t, db); after >= before {
+		t.Fatalf("stored blocks take %d bytes after Compact, %d before", after, before)
+	}
+	reopened := newTestTree(t, WithCustomDB(db), WithVersionRetention(2))
+	for _, tree := range []*BASSparseMerkleTree{tree, reopened} {
+		for v := recent; v <= commits; v++ {
+			for i := 0; i < keys; i++ {
+				proof, err := tree.GetProof(testKey(i), &v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(proof.Root, want[v][i].Root) || !bytes.Equal(proof.Leaf, want[v][i].Leaf) {
+					t.Fatalf("version %d: proof of key %d changed by Compact", v, i)
+				}
+			}
+		}
+		version := recent - 1
+		if _, err := tree.Get(testKey(1), &version); err != ErrVersionTooOld {
+			t.Fatalf("got %v reading a pruned version, want ErrVersionTooOld", err)
+		}
+	}
+
+	if err := newTestTree(t, WithCustomDB(NewRetryDB(NewFastMemoryDB(0), RetryPolicy{}))).Compact(); err != ErrIteratorNotSupported {
+		t.Fatalf("got %v, want ErrIteratorNotSupported", err)
+	}
+}
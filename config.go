@@ -0,0 +1,85 @@
+package bsmt
+
+import "bytes"
+
+// configEntry is one record of the stored config.
+type configEntry struct {
+	key   string
+	value []byte
+}
+
+// configEntries returns the records of the stored config: the structural
+// parameters a stored tree has to be reopened with. The path encoding, the
+// node encoding, the leaf binding, the node checksums, whether history is
+// kept and whether nodes are written as deltas are in their own records,
+// empty by default.
+func (tree *BASSparseMerkleTree) configEntries() []configEntry {
+	var buf bytes.Buffer
+	buf.WriteByte(tree.maxDepth)
+	putBytes(&buf, []byte(tree.hasher.ID()))
+	putBytes(&buf, tree.nilHashes[tree.maxDepth])
+	putBytes(&buf, tree.nilLadderSeed)
+	return []configEntry{
+		{key: maxDepthKeyPrefix, value: buf.Bytes()},
+		{key: pathEncodingKey, value: []byte(tree.pathEncodingID)},
+		{key: sparseNodeEncodingKey, value: configFlag(tree.sparseNodes)},
+		{key: keyBoundLeavesKey, value: configFlag(tree.keyBoundLeaves)},
+		{key: storageChecksumKey, value: configFlag(tree.storageChecksum)},
+		{key: latestOnlyKey, value: configFlag(tree.latestOnly)},
+		{key: deltaNodeWritesKey, value: configFlag(tree.maxNodeDeltas > 0)},
+	}
+}
+
+// configFlag encodes an option that is either set or not.
+func configFlag(set bool) []byte {
+	if !set {
+		return nil
+	}
+	return []byte{1}
+}
+
+// encodeConfig encodes entries as the input of the config MAC.
+func encodeConfig(entries []configEntry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		putBytes(&buf, []byte(entry.key))
+		putBytes(&buf, entry.value)
+	}
+	return buf.Bytes()
+}
+
+// loadConfig verifies the MAC of the config stored in the db under
+// WithStoreIntegrityKey, then checks it against the options of the tree,
+// failing with ErrConfigMismatch if they differ. The MAC is checked over the
+// stored records first, so a tampered config is reported as such whatever
+// the tree is opened with. A db without a config gets the tree's.
+func (tree *BASSparseMerkleTree) loadConfig() error {
+	entries := tree.configEntries()
+	if _, err := tree.dbGet([]byte(entries[0].key)); err == ErrDatabaseNotFound {
+		for _, entry := range entries {
+			if err := tree.db.Set([]byte(entry.key), entry.value); err != nil {
+				return err
+			}
+		}
+		return tree.writeConfigIntegrity(encodeConfig(entries))
+	} else if err != nil {
+		return err
+	}
+	stored := make([]configEntry, len(entries))
+	for i, entry := range entries {
+		value, err := tree.dbGet([]byte(entry.key))
+		if err != nil && err != ErrDatabaseNotFound {
+			return err
+		}
+		stored[i] = configEntry{key: entry.key, value: value}
+	}
+	if err := tree.checkConfigIntegrity(encodeConfig(stored)); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if !bytes.Equal(stored[i].value, entry.value) {
+			return ErrConfigMismatch
+		}
+	}
+	return nil
+}
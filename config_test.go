@@ -0,0 +1,146 @@
+package bsmt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestReopenWithDifferentConfig(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	commitVersions(t, tree, 4)
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithMaxDepth(32)); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithNilLadderSeed([]byte("seed"))); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch for another nil ladder seed", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithPathEncoding("bitreverse", BitReversePath)); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch for another path encoding", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithKeyBoundLeaves()); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch with key-bound leaves", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithEmptyLeafEncoding(func() []byte {
+		return make([]byte, 32)
+	})); err != nil {
+		t.Fatalf("an option equal to the default is rejected: %v", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReopenWithHasherOutputLen(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	commitVersions(t, newTestTree(t, WithCustomDB(db)), 4)
+	short := WithHasher(NewHasherWithOutputLen(sha256.New(), 16))
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), short); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch for a 16-byte hasher", err)
+	}
+
+	db = NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), short)
+	for i := 0; i < 4; i++ {
+		if err := tree.Set(testKey(i), testValue(i)[:16]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tree.GetProof(testKey(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Root) != 16 {
+		t.Fatalf("got a %d-byte root from a 16-byte hasher", len(proof.Root))
+	}
+	if err := tree.CheckProof(proof); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db)); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch for a 32-byte hasher", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), short); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReopenWithFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opt  Option
+	}{
+		{"key-bound leaves", WithKeyBoundLeaves()},
+		{"storage checksum", WithStorageChecksum()},
+		{"latest only", WithLatestOnly()},
+		{"delta node writes", WithDeltaNodeWrites(4)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewFastMemoryDB(0)
+			commitVersions(t, newTestTree(t, WithCustomDB(db), tc.opt), 4)
+			if _, err := NewBASSparseMerkleTree(WithCustomDB(db)); err != ErrConfigMismatch {
+				t.Fatalf("got %v, want ErrConfigMismatch without the option", err)
+			}
+			if _, err := NewBASSparseMerkleTree(WithCustomDB(db), tc.opt); err != nil {
+				t.Fatal(err)
+			}
+			plain := NewFastMemoryDB(0)
+			commitVersions(t, newTestTree(t, WithCustomDB(plain)), 4)
+			if _, err := NewBASSparseMerkleTree(WithCustomDB(plain), tc.opt); err != ErrConfigMismatch {
+				t.Fatalf("got %v, want ErrConfigMismatch with the option", err)
+			}
+		})
+	}
+}
+
+func TestConfigIntegrity(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	key := []byte("integrity key")
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithStoreIntegrityKey(key)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithStoreIntegrityKey(key)); err != nil {
+		t.Fatalf("reopening with the same key: %v", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithStoreIntegrityKey([]byte("other"))); err != ErrIntegrityCheckFailed {
+		t.Fatalf("got %v, want ErrIntegrityCheckFailed for another key", err)
+	}
+
+	// Rewrite the config record as if for another depth, as an attacker
+	// controlling the storage could. The tampering is reported whether the
+	// tree is opened with that depth or with the original options.
+	tampered := newTestTree(t, WithMaxDepth(32))
+	entry := tampered.configEntries()[0]
+	if err := db.Set([]byte(entry.key), entry.value); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithStoreIntegrityKey(key), WithMaxDepth(32)); err != ErrIntegrityCheckFailed {
+		t.Fatalf("got %v, want ErrIntegrityCheckFailed for a tampered config", err)
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db), WithStoreIntegrityKey(key)); err != ErrIntegrityCheckFailed {
+		t.Fatalf("got %v, want ErrIntegrityCheckFailed for a tampered config opened with the defaults", err)
+	}
+}
+
+func TestReopenWithPathEncoding(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), WithPathEncoding("bitreverse", BitReversePath))
+	commitVersions(t, tree, 4)
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db)); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch without the path encoding", err)
+	}
+	reopened, err := NewBASSparseMerkleTree(WithCustomDB(db), WithPathEncoding("bitreverse", BitReversePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := reopened.Get(testKey(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val, testValue(3)) {
+		t.Fatal("reopened tree reads another value")
+	}
+}
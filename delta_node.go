@@ -0,0 +1,197 @@
+package bsmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+// deltaNodeKeyPrefix prefixes the db keys of the delta records written by
+// WithDeltaNodeWrites. It must not start with storageNodeKeyPrefix, which
+// Compact scans for blocks.
+const deltaNodeKeyPrefix string = "deltaNode"
+
+// A delta record holds the history a block gained since it was last written
+// in full, as one segment per commit: uvarint(version), uvarint(count) and
+// count entries, each the index of a node in the block (0 for the block
+// root, i+1 for Children[i]) and its hash at version. With
+// WithStorageChecksum the CRC32C of the record follows.
+
+// deltaNodeKey returns the db key of the delta record of the block stored
+// under blockKey.
+func deltaNodeKey(blockKey []byte) []byte {
+	return append([]byte(deltaNodeKeyPrefix), blockKey[len(storageNodeKeyPrefix):]...)
+}
+
+// blockNodes returns the resident nodes of the block rooted at node: the
+// root, then the nodes of Children in the same order. Missing nodes are nil.
+func blockNodes(node *FullTreeNode) [31]*FullTreeNode {
+	var nodes [31]*FullTreeNode
+	nodes[0] = node
+	for i := 1; i < len(nodes); i++ {
+		parent := nodes[(i-1)/2]
+		if parent == nil {
+			continue
+		}
+		child := parent.LeftChild
+		if i%2 == 0 {
+			child = parent.RightChild
+		}
+		nodes[i] = fullNode(child)
+	}
+	return nodes
+}
+
+// deltaSegment encodes the nodes of the block rooted at node that were
+// committed at version.
+func deltaSegment(node *FullTreeNode, version Version) []byte {
+	var entries bytes.Buffer
+	count := 0
+	for i, n := range blockNodes(node) {
+		if n == nil || len(n.Versions) == 0 || n.Versions[len(n.Versions)-1].Ver != version {
+			continue
+		}
+		putUvarint(&entries, uint64(i))
+		putBytes(&entries, n.Versions[len(n.Versions)-1].Hash)
+		count++
+	}
+	var buf bytes.Buffer
+	putUvarint(&buf, uint64(version))
+	putUvarint(&buf, uint64(count))
+	buf.Write(entries.Bytes())
+	return buf.Bytes()
+}
+
+// nodeDelta is one decoded segment of a delta record.
+type nodeDelta struct {
+	version Version
+	indexes []int
+	hashes  [][]byte
+}
+
+// decodeDeltas decodes the delta record stored for the block under
+// blockKey.
+func (tree *BASSparseMerkleTree) decodeDeltas(blockKey, data []byte) ([]nodeDelta, error) {
+	data, err := tree.checkDeltaSum(blockKey, data)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(data)
+	var deltas []nodeDelta
+	for r.Len() > 0 {
+		version, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrInvalidNodeEncoding
+		}
+		count, err := binary.ReadUvarint(r)
+		if err != nil || count > 31 {
+			return nil, ErrInvalidNodeEncoding
+		}
+		delta := nodeDelta{version: Version(version)}
+		for i := uint64(0); i < count; i++ {
+			index, err := binary.ReadUvarint(r)
+			if err != nil || index > 30 {
+				return nil, ErrInvalidNodeEncoding
+			}
+			hash, err := readBytes(r)
+			if err != nil {
+				return nil, ErrInvalidNodeEncoding
+			}
+			delta.indexes = append(delta.indexes, int(index))
+			delta.hashes = append(delta.hashes, hash)
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas, nil
+}
+
+// checkDeltaSum strips and verifies the checksum of a delta record when
+// WithStorageChecksum is enabled.
+func (tree *BASSparseMerkleTree) checkDeltaSum(blockKey, data []byte) ([]byte, error) {
+	if !tree.storageChecksum {
+		return data, nil
+	}
+	n := len(data) - crc32.Size
+	if n < 0 || binary.BigEndian.Uint32(data[n:]) != crc32.Checksum(data[:n], castagnoli) {
+		return nil, corruptNode(blockKey)
+	}
+	return data[:n], nil
+}
+
+// applyDeltas appends the history recorded in deltas to block. Entries not
+// newer than the history they extend are skipped: a block read while a
+// commit folds its deltas may already hold them.
+func applyDeltas(block *StorageFullTreeNode, deltas []nodeDelta) {
+	for _, delta := range deltas {
+		for i, index := range delta.indexes {
+			hash, versions := &block.LatestHash, &block.Versions
+			if index > 0 {
+				hash, versions = &block.Children[index-1].LatestHash, &block.Children[index-1].Versions
+			}
+			if n := len(*versions); n > 0 && (*versions)[n-1].Ver >= delta.version {
+				continue
+			}
+			*versions = append(*versions, &VersionInfo{Ver: delta.version, Hash: delta.hashes[i]})
+			*hash = delta.hashes[i]
+		}
+	}
+}
+
+// readDeltas reads the delta record of the block under blockKey, nil if it
+// has none.
+func (tree *BASSparseMerkleTree) readDeltas(blockKey []byte) ([]nodeDelta, error) {
+	data, err := tree.dbGet(deltaNodeKey(blockKey))
+	if err == ErrDatabaseNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tree.decodeDeltas(blockKey, data)
+}
+
+// writeDeltas adds to batch the delta records of the blocks of nodes,
+// stored under keys, that existed before version and have fewer than the
+// WithDeltaNodeWrites limit of deltas. It returns the blocks left to be
+// written in full, and deletes the delta records they fold in.
+func (tree *BASSparseMerkleTree) writeDeltas(batch Batcher, keys [][]byte, nodes []*FullTreeNode, version Version) ([][]byte, []*FullTreeNode, error) {
+	var fullKeys [][]byte
+	var fullNodes []*FullTreeNode
+	for i, node := range nodes {
+		deltaKey := deltaNodeKey(keys[i])
+		record, err := tree.dbGet(deltaKey)
+		if err != nil && err != ErrDatabaseNotFound {
+			return nil, nil, err
+		}
+		var deltas []nodeDelta
+		if record != nil {
+			if deltas, err = tree.decodeDeltas(keys[i], record); err != nil {
+				return nil, nil, err
+			}
+			record, _ = tree.checkDeltaSum(keys[i], record)
+		}
+		// A block first written at version has no full record to extend.
+		if len(node.Versions) < 2 || len(deltas) >= tree.maxNodeDeltas {
+			if record != nil {
+				if err := batch.Delete(deltaKey); err != nil {
+					return nil, nil, err
+				}
+			}
+			fullKeys = append(fullKeys, keys[i])
+			fullNodes = append(fullNodes, node)
+			continue
+		}
+		record = append(append([]byte{}, record...), deltaSegment(node, version)...)
+		if tree.storageChecksum {
+			sum := make([]byte, crc32.Size)
+			binary.BigEndian.PutUint32(sum, crc32.Checksum(record, castagnoli))
+			record = append(record, sum...)
+		}
+		if err := batch.Set(deltaKey, record); err != nil {
+			return nil, nil, err
+		}
+		atomic.AddUint64(&tree.metrics.bytesWritten, uint64(len(record)))
+	}
+	return fullKeys, fullNodes, nil
+}
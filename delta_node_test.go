@@ -0,0 +1,160 @@
+package bsmt
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// deltaRecords returns the number of delta records in db.
+func deltaRecords(t *testing.T, db *FastMemoryDB) int {
+	t.Helper()
+	n := 0
+	if err := db.Iterate(func(key, value []byte) error {
+		if bytes.HasPrefix(key, []byte(deltaNodeKeyPrefix)) {
+			n++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeltaNodeWritesMatchFullWrites(t *testing.T) {
+	const keys, commits = 64, 12
+	for _, opts := range [][]Option{nil, {WithStorageChecksum()}, {WithSparseNodeEncoding()}} {
+		fullDB, deltaDB := NewFastMemoryDB(0), NewFastMemoryDB(0)
+		full := newTestTree(t, append(opts, WithCustomDB(fullDB))...)
+		delta := newTestTree(t, append(opts, WithCustomDB(deltaDB), WithDeltaNodeWrites(4))...)
+		set := func(c int) {
+			for i := 0; i < keys; i += 1 + c%3 {
+				for _, tree := range []*BASSparseMerkleTree{full, delta} {
+					if err := tree.Set(testKey(i), testValue(i*c)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		}
+		commit := func() {
+			for _, tree := range []*BASSparseMerkleTree{full, delta} {
+				if _, err := tree.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(full.CommittedRoot(), delta.CommittedRoot()) {
+				t.Fatalf("version %d: delta writes commit another root", delta.LatestVersion())
+			}
+		}
+		for c := 1; c <= commits; c++ {
+			set(c)
+			commit()
+		}
+		if deltaRecords(t, deltaDB) == 0 {
+			t.Fatal("no delta record written")
+		}
+
+		// Reads from the db merge the deltas back into the blocks.
+		reopened := newTestTree(t, append(opts, WithCustomDB(deltaDB), WithDeltaNodeWrites(4))...)
+		if !bytes.Equal(reopened.Root(), full.Root()) {
+			t.Fatal("reopened delta tree has another root")
+		}
+		for v := Version(1); v <= commits; v++ {
+			for i := 0; i < keys; i += 7 {
+				want, err := full.GetProof(testKey(i), &v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := reopened.GetProof(testKey(i), &v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Root, want.Root) || !bytes.Equal(got.Leaf, want.Leaf) {
+					t.Fatalf("version %d: proof of key %d differs with delta writes", v, i)
+				}
+			}
+		}
+
+		// Rollback rewrites blocks in full and drops their deltas.
+		for _, tree := range []*BASSparseMerkleTree{full, reopened} {
+			if err := tree.Rollback(commits - 5); err != nil {
+				t.Fatal(err)
+			}
+		}
+		delta = reopened
+		set(100)
+		commit()
+		again := newTestTree(t, append(opts, WithCustomDB(deltaDB), WithDeltaNodeWrites(4))...)
+		if !bytes.Equal(again.Root(), full.Root()) {
+			t.Fatal("delta tree reopened after a rollback has another root")
+		}
+
+		if err := delta.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		if n := deltaRecords(t, deltaDB); n != 0 {
+			t.Fatalf("%d delta records left after Compact", n)
+		}
+		compacted := newTestTree(t, append(opts, WithCustomDB(deltaDB), WithDeltaNodeWrites(4))...)
+		for i := 0; i < keys; i++ {
+			want, _ := full.Get(testKey(i), nil)
+			got, err := compacted.Get(testKey(i), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("key %d reads differently after Compact", i)
+			}
+		}
+	}
+}
+
+// commitBytesWritten returns the bytes written by a commit of one Set on a
+// tree of keys committed keys.
+func commitBytesWritten(t testing.TB, keys int, opts ...Option) uint64 {
+	tree := newTestTree(t, append(opts, WithCustomDB(NewFastMemoryDB(0)))...)
+	for i := 0; i < keys; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tree.ResetStats()
+	if err := tree.Set(testKey(0), testValue(1000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return tree.Stats().BytesWritten
+}
+
+func TestDeltaNodeWritesCutBytesWritten(t *testing.T) {
+	full := commitBytesWritten(t, 1024)
+	delta := commitBytesWritten(t, 1024, WithDeltaNodeWrites(8))
+	if delta*4 > full {
+		t.Fatalf("a single Set wrote %d bytes with deltas, %d without", delta, full)
+	}
+}
+
+func TestDeltaNodeWritesWithLatestOnly(t *testing.T) {
+	if _, err := NewBASSparseMerkleTree(WithDeltaNodeWrites(4), WithLatestOnly()); err != ErrConflictingOptions {
+		t.Fatalf("got %v, want ErrConflictingOptions", err)
+	}
+}
+
+// BenchmarkCommitBytesWritten reports the bytes written by a commit of one
+// Set on a tree of 4096 keys, with and without delta writes.
+func BenchmarkCommitBytesWritten(b *testing.B) {
+	for _, deltas := range []int{0, 16} {
+		b.Run(fmt.Sprintf("deltas=%d", deltas), func(b *testing.B) {
+			var written uint64
+			for i := 0; i < b.N; i++ {
+				written += commitBytesWritten(b, 4096, WithDeltaNodeWrites(deltas))
+			}
+			b.ReportMetric(float64(written)/float64(b.N), "bytes/commit")
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package bsmt
+
+import "context"
+
+var (
+	_ TreeDB  = (*DualWriteDB)(nil)
+	_ Flusher = (*DualWriteDB)(nil)
+)
+
+// DualWriteDB mirrors the writes to a primary onto a secondary, for moving
+// a tree to a new backend without downtime: reads are served by the primary,
+// and once the secondary has been backfilled the two can be swapped.
+//
+// The primary is authoritative. A write is applied to the secondary only
+// once the primary has accepted it, and its result is that of the primary.
+// A failed secondary write is passed to onError and otherwise ignored, so
+// the secondary must be reconciled by the backfill afterwards.
+type DualWriteDB struct {
+	primary   TreeDB
+	secondary TreeDB
+	onError   func(err error)
+}
+
+// NewDualWriteDB mirrors primary onto secondary. onError may be nil.
+func NewDualWriteDB(primary, secondary TreeDB, onError func(err error)) *DualWriteDB {
+	return &DualWriteDB{primary: primary, secondary: secondary, onError: onError}
+}
+
+func (db *DualWriteDB) secondaryFailed(err error) {
+	if err != nil && db.onError != nil {
+		db.onError(err)
+	}
+}
+
+func (db *DualWriteDB) Get(key []byte) ([]byte, error) { return db.primary.Get(key) }
+func (db *DualWriteDB) Has(key []byte) (bool, error)   { return db.primary.Has(key) }
+func (db *DualWriteDB) Ping(ctx context.Context) error { return db.primary.Ping(ctx) }
+
+func (db *DualWriteDB) Set(key []byte, value []byte) error {
+	if err := db.primary.Set(key, value); err != nil {
+		return err
+	}
+	db.secondaryFailed(db.secondary.Set(key, value))
+	return nil
+}
+
+func (db *DualWriteDB) Delete(key []byte) error {
+	if err := db.primary.Delete(key); err != nil {
+		return err
+	}
+	db.secondaryFailed(db.secondary.Delete(key))
+	return nil
+}
+
+// Flush flushes the primary and then the secondary, whose failure goes to
+// onError like that of its writes.
+func (db *DualWriteDB) Flush() error {
+	if err := flushDB(db.primary); err != nil {
+		return err
+	}
+	db.secondaryFailed(flushDB(db.secondary))
+	return nil
+}
+
+func (db *DualWriteDB) NewBatch() Batcher {
+	return &dualWriteBatch{
+		primary:   db.primary.NewBatch(),
+		secondary: db.secondary.NewBatch(),
+		db:        db,
+	}
+}
+
+// dualWriteBatch buffers the same changes for both stores and writes the
+// secondary batch once the primary one succeeded.
+type dualWriteBatch struct {
+	primary   Batcher
+	secondary Batcher
+	db        *DualWriteDB
+	// failed is set once the secondary batch rejected a change, which is
+	// then not written.
+	failed bool
+}
+
+func (b *dualWriteBatch) Set(key []byte, value []byte) error {
+	if err := b.primary.Set(key, value); err != nil {
+		return err
+	}
+	if err := b.secondary.Set(key, value); err != nil && !b.failed {
+		b.failed = true
+		b.db.secondaryFailed(err)
+	}
+	return nil
+}
+
+func (b *dualWriteBatch) Delete(key []byte) error {
+	if err := b.primary.Delete(key); err != nil {
+		return err
+	}
+	if err := b.secondary.Delete(key); err != nil && !b.failed {
+		b.failed = true
+		b.db.secondaryFailed(err)
+	}
+	return nil
+}
+
+func (b *dualWriteBatch) Write() error {
+	if err := b.primary.Write(); err != nil {
+		return err
+	}
+	if !b.failed {
+		b.db.secondaryFailed(b.secondary.Write())
+	}
+	return nil
+}
+
+func (b *dualWriteBatch) Reset() {
+	b.primary.Reset()
+	b.secondary.Reset()
+	b.failed = false
+}
@@ -0,0 +1,63 @@
+package bsmt
+
+import "testing"
+
+func TestDualWriteDBFailingSecondary(t *testing.T) {
+	primary, secondary := NewFastMemoryDB(0), newFailingDB(0)
+	var errs []error
+	db := NewDualWriteDB(primary, secondary, func(err error) { errs = append(errs, err) })
+
+	secondary.failures = 1
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("got %v, want a secondary failure ignored", err)
+	}
+	if _, err := primary.Get([]byte("a")); err != nil {
+		t.Fatal("write did not reach the primary")
+	}
+	if len(errs) != 1 || errs[0] != errBackend {
+		t.Fatalf("got %v, want the secondary error reported", errs)
+	}
+
+	secondary.failures = 1
+	batch := db.NewBatch()
+	if err := batch.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("got %v, want a secondary failure ignored", err)
+	}
+	if _, err := primary.Get([]byte("b")); err != nil {
+		t.Fatal("batch did not reach the primary")
+	}
+	if len(errs) != 2 {
+		t.Fatalf("got %d reported errors, want 2", len(errs))
+	}
+
+	if err := db.Set([]byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secondary.FastMemoryDB.Get([]byte("c")); err != nil {
+		t.Fatal("write is not mirrored once the secondary recovers")
+	}
+}
+
+func TestDualWriteDBFailingPrimary(t *testing.T) {
+	primary, secondary := newFailingDB(0), NewFastMemoryDB(0)
+	db := NewDualWriteDB(primary, secondary, nil)
+	primary.failures = 2
+	if err := db.Set([]byte("a"), []byte("1")); err != errBackend {
+		t.Fatalf("got %v, want the primary error", err)
+	}
+	batch := db.NewBatch()
+	if err := batch.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != errBackend {
+		t.Fatalf("got %v, want the primary error", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := secondary.Get([]byte(key)); err != ErrDatabaseNotFound {
+			t.Fatalf("%s: write rejected by the primary reached the secondary", key)
+		}
+	}
+}
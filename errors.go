@@ -0,0 +1,46 @@
+package bsmt
+
+import "errors"
+
+var (
+	ErrDatabaseNotFound      = errors.New("key not found in database")
+	ErrInvalidHexString      = errors.New("invalid hex string, 0x prefix required")
+	ErrIntegrityCheckFailed  = errors.New("stored config integrity check failed")
+	ErrVersionTooOld         = errors.New("the version is lower than the recent version")
+	ErrInvalidWitness        = errors.New("invalid witness encoding")
+	ErrIteratorNotSupported  = errors.New("database does not support iteration")
+	ErrInvalidExport         = errors.New("invalid export stream")
+	ErrInvalidBatchProof     = errors.New("batch proof references a missing hash")
+	ErrNonCanonicalProof     = errors.New("proof is not canonical")
+	ErrProofSelfCheckFailed  = errors.New("generated proof does not verify against the tree root")
+	ErrInvalidPayload        = errors.New("invalid stored payload record")
+	ErrInvalidVersionRecord  = errors.New("invalid stored version record")
+	ErrKeyCollision          = errors.New("another key is already stored in this slot")
+	ErrVersionOverflow       = errors.New("the next version would overflow")
+	ErrInvalidDepth          = errors.New("maxDepth must be a non-zero multiple of 4")
+	ErrDepthTooLarge         = errors.New("maxDepth exceeds the hasher output width")
+	ErrDatabaseRequired      = errors.New("the option requires a database")
+	ErrUnexpectedVersion     = errors.New("the commit would not create the expected version")
+	ErrInvalidRecentVersion  = errors.New("the recent version is newer than the committed version")
+	ErrInvalidImportProgress = errors.New("import progress exceeds the input size")
+	ErrInvalidNodeEncoding   = errors.New("invalid stored node encoding")
+	ErrShadowHasherNotSet    = errors.New("no shadow hasher configured")
+	ErrInvalidKey            = errors.New("key is shorter than maxDepth")
+	ErrVersionTooHigh        = errors.New("the version is higher than the latest version")
+	ErrMalformedProof        = errors.New("proof sibling has the wrong length")
+	ErrProofMismatch         = errors.New("proof does not match its root")
+	ErrTreeFrozen            = errors.New("tree is frozen")
+	ErrTreeExists            = errors.New("db already holds a tree")
+	ErrTreeNotFound          = errors.New("db holds no tree")
+	ErrHistoryDisabled       = errors.New("version history is not kept with WithLatestOnly")
+	ErrSelfTestFailed        = errors.New("self-test failed")
+	ErrRootMismatch          = errors.New("root does not match the expected root")
+	ErrRootNotFound          = errors.New("no root is stored for the version")
+	ErrConfigMismatch        = errors.New("stored config does not match the tree options")
+	ErrConflictingOptions    = errors.New("options cannot be combined")
+	ErrEmptyLeafValue        = errors.New("value equals the empty leaf encoding")
+	ErrInvalidLeafLength     = errors.New("leaf length differs from the hasher output size")
+	ErrSnapshotRolledBack    = errors.New("the snapshot version was rolled back")
+	ErrInvalidShard          = errors.New("shard function returned an index outside the shards")
+	ErrInvalidFlushedState   = errors.New("invalid flushed state record")
+)
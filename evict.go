@@ -0,0 +1,95 @@
+package bsmt
+
+import "sync/atomic"
+
+// EvictionCallback is told the depth and path of each node the tree unloads
+// from memory, so caches derived from resident nodes can evict in lockstep.
+// Only the first depth bits of path are meaningful.
+type EvictionCallback func(depth uint8, path []byte)
+
+// evictedNode is a node unloaded by evictTemporary.
+type evictedNode struct {
+	depth uint8
+	path  []byte
+}
+
+// evictTemporary unloads the resident nodes that were only loaded from the
+// db to serve reads. Nodes on the path of a staged change are kept. The
+// eviction callback runs after the tree lock is released, so it may call
+// back into the tree.
+func (tree *BASSparseMerkleTree) evictTemporary() {
+	for _, node := range tree.collectTemporary() {
+		tree.evictionCallback(node.depth, node.path)
+	}
+}
+
+// collectTemporary unloads the loaded children of every block root whose
+// block is only resident to serve reads, so the block is read again when
+// next needed, and returns the unloaded nodes.
+func (tree *BASSparseMerkleTree) collectTemporary() []evictedNode {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root == nil {
+		return nil
+	}
+	var evicted []evictedNode
+	var evict func(node *FullTreeNode, path []byte)
+	evict = func(node *FullTreeNode, path []byte) {
+		if node == nil || node.Depth >= tree.maxDepth {
+			return
+		}
+		if tree.evictable(node) {
+			evicted = append(evicted, tree.unloadBlock(node, path)...)
+			return
+		}
+		evict(fullNode(node.LeftChild), path)
+		path[node.Depth/8] |= 0x80 >> (node.Depth % 8)
+		evict(fullNode(node.RightChild), path)
+		path[node.Depth/8] &^= 0x80 >> (node.Depth % 8)
+	}
+	evict(tree.rootNode(), make([]byte, (int(tree.maxDepth)+7)/8))
+	return evicted
+}
+
+// evictable reports whether node is a committed block root below the
+// always resident levels whose children were all loaded from the db and
+// carry no staged change. Setting a leaf clears Temporary on its path, so
+// nothing staged is below such children either.
+func (tree *BASSparseMerkleTree) evictable(node *FullTreeNode) bool {
+	if node.Depth%4 != 0 || node.Depth < prefixLockDepth || len(node.Versions) == 0 {
+		return false
+	}
+	left, right := fullNode(node.LeftChild), fullNode(node.RightChild)
+	if left == nil && right == nil {
+		return false
+	}
+	for _, child := range []*FullTreeNode{left, right} {
+		if child != nil && (!child.Temporary || child.Dirty) {
+			return false
+		}
+	}
+	return true
+}
+
+// unloadBlock releases the children of the evictable block root node on
+// path and returns them for the eviction callback. The caller holds the
+// tree lock exclusively.
+func (tree *BASSparseMerkleTree) unloadBlock(node *FullTreeNode, path []byte) []evictedNode {
+	var evicted []evictedNode
+	for i, child := range []TreeNode{node.LeftChild, node.RightChild} {
+		if fullNode(child) == nil {
+			continue
+		}
+		atomic.AddUint64(&tree.metrics.nodesReleased, 1)
+		tree.releaseNodes(child)
+		if tree.evictionCallback != nil {
+			childPath := append([]byte{}, path...)
+			if i == 1 {
+				childPath[node.Depth/8] |= 0x80 >> (node.Depth % 8)
+			}
+			evicted = append(evicted, evictedNode{depth: node.Depth + 1, path: childPath})
+		}
+	}
+	node.LeftChild, node.RightChild = nil, nil
+	return evicted
+}
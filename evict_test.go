@@ -0,0 +1,77 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEvictAfterProof(t *testing.T) {
+	for _, depth := range []uint8{16, 64} {
+		db := NewFastMemoryDB(0)
+		tree := newTestTree(t, WithCustomDB(db), WithMaxDepth(depth))
+		commitVersions(t, tree, 32)
+		root := tree.CommittedRoot()
+
+		reopened := newTestTree(t, WithCustomDB(db), WithMaxDepth(depth), WithEvictAfterProof())
+		resident := countResident(reopened.root)
+		for i := 0; i < 32; i++ {
+			key := testKey(i)[:depth/8]
+			proof, err := reopened.GetProof(key, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(proof.Root, root) || !reopened.VerifyProof(proof) {
+				t.Fatalf("depth %d, key %d: proof does not verify after eviction", depth, i)
+			}
+			if n := countResident(reopened.root); n != resident {
+				t.Fatalf("depth %d, key %d: %d nodes resident after the proof, want %d", depth, i, n, resident)
+			}
+		}
+		if reopened.Size() != resident {
+			t.Fatalf("depth %d: Size is %d, want %d", depth, reopened.Size(), resident)
+		}
+
+		// A staged change keeps its path resident.
+		if err := reopened.Set(testKey(0)[:depth/8], testValue(99)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reopened.GetProof(testKey(1)[:depth/8], nil); err != nil {
+			t.Fatal(err)
+		}
+		val, err := reopened.Get(testKey(0)[:depth/8], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, testValue(99)) {
+			t.Fatalf("depth %d: eviction drops a staged value", depth)
+		}
+	}
+}
+
+func TestEvictionCallback(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	commitVersions(t, newTestTree(t, WithCustomDB(db)), 32)
+
+	var reopened *BASSparseMerkleTree
+	key := testKey(5)
+	var evicted []uint8
+	reopened = newTestTree(t, WithCustomDB(db), WithEvictAfterProof(), WithEvictionCallback(func(depth uint8, path []byte) {
+		evicted = append(evicted, depth)
+		if depth <= prefixLockDepth || depth%4 != 1 {
+			t.Errorf("node at depth %d reported, want the child of a block root", depth)
+		}
+		if !bytes.Equal(storageNodeKey(depth-1, path), storageNodeKey(depth-1, key)) {
+			t.Errorf("path %x reported, not on the path of the proven key", path)
+		}
+		// The callback runs without the tree lock.
+		if _, err := reopened.Get(key, nil); err != nil {
+			t.Error(err)
+		}
+	}))
+	if _, err := reopened.GetProof(key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) == 0 {
+		t.Fatal("eviction callback never called")
+	}
+}
@@ -0,0 +1,100 @@
+package bsmt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// exportMagic starts every stream written by ExportStreaming.
+const exportMagic = "bsmt-export-v1"
+
+// importBatchSize bounds the number of records buffered by ImportStreaming
+// before they are written to the db.
+const importBatchSize = 1024
+
+// Iteratee is implemented by a TreeDB that can walk all of its records.
+type Iteratee interface {
+	Iterate(fn func(key, value []byte) error) error
+}
+
+// ExportStreaming writes every record of the db to w as it is visited, so
+// memory use does not depend on the tree size. Each record is framed as
+// uvarint(len(key)) key uvarint(len(value)) value.
+func (tree *BASSparseMerkleTree) ExportStreaming(w io.Writer) error {
+	iteratee, ok := tree.db.(Iteratee)
+	if !ok {
+		return ErrIteratorNotSupported
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(exportMagic); err != nil {
+		return err
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	writeFrame := func(b []byte) error {
+		if _, err := bw.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(b)))]); err != nil {
+			return err
+		}
+		_, err := bw.Write(b)
+		return err
+	}
+	err := iteratee.Iterate(func(key, value []byte) error {
+		if err := writeFrame(key); err != nil {
+			return err
+		}
+		return writeFrame(value)
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportStreaming reads a stream written by ExportStreaming into the db.
+// The caller reopens the tree afterwards to load the imported state.
+func (tree *BASSparseMerkleTree) ImportStreaming(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != exportMagic {
+		return ErrInvalidExport
+	}
+	readFrame := func() ([]byte, error) {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, ErrInvalidExport
+		}
+		return b, nil
+	}
+
+	batch := tree.db.NewBatch()
+	pending := 0
+	for {
+		key, err := readFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ErrInvalidExport
+		}
+		value, err := readFrame()
+		if err != nil {
+			return ErrInvalidExport
+		}
+		if err := batch.Set(key, value); err != nil {
+			return err
+		}
+		pending++
+		if pending == importBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+			pending = 0
+		}
+	}
+	return batch.Write()
+}
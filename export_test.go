@@ -0,0 +1,83 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExportImportStreaming(t *testing.T) {
+	const keys, residentCap = 1000, 64
+	db := NewFastMemoryDB(0)
+	writer := newTestTree(t, WithCustomDB(db))
+	for v := 1; v <= 2; v++ {
+		for i := 0; i < keys; i++ {
+			if err := writer.Set(testKey(i), testValue(i*v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The stored tree holds far more nodes than may stay resident in the
+	// tree that exports it.
+	if writer.Size() <= residentCap {
+		t.Fatalf("the tree has %d nodes, want more than %d", writer.Size(), residentCap)
+	}
+	tree := newTestTree(t, WithCustomDB(db), WithResidentCap(residentCap))
+	for i := 0; i < keys; i += 10 {
+		if _, err := tree.Get(testKey(i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.Size() > residentCap {
+		t.Fatalf("%d nodes resident after reads, want at most %d", tree.Size(), residentCap)
+	}
+
+	var buf bytes.Buffer
+	if err := tree.ExportStreaming(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size() > residentCap {
+		t.Fatalf("export loaded %d nodes, want at most %d resident", tree.Size(), residentCap)
+	}
+	stream := buf.Bytes()
+
+	dst := NewFastMemoryDB(0)
+	if err := newTestTree(t, WithCustomDB(dst)).ImportStreaming(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestTree(t, WithCustomDB(dst))
+	if imported.LatestVersion() != tree.LatestVersion() || !bytes.Equal(imported.CommittedRoot(), tree.CommittedRoot()) {
+		t.Fatal("imported tree differs from the exported one")
+	}
+	for i := 0; i < keys; i += 37 {
+		for v := Version(1); v <= 2; v++ {
+			version := v
+			val, err := imported.Get(testKey(i), &version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(val, testValue(i*int(v))) {
+				t.Fatalf("key %d at version %d reads back differently", i, v)
+			}
+		}
+		proof, err := imported.GetProof(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.CheckProof(proof); err != nil {
+			t.Fatalf("proof of key %d from the imported tree: %v", i, err)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"bad magic": append([]byte("bsmt-export-v0"), stream[len(exportMagic):]...),
+		"truncated": stream[:len(stream)-1],
+	} {
+		err := newTestTree(t, WithCustomDB(NewFastMemoryDB(0))).ImportStreaming(bytes.NewReader(data))
+		if err != ErrInvalidExport {
+			t.Fatalf("%s: got %v, want ErrInvalidExport", name, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package bsmt
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	_ TreeDB      = (*FastMemoryDB)(nil)
+	_ MultiGetter = (*FastMemoryDB)(nil)
+)
+
+// FastMemoryDB is the in-memory TreeDB, tuned for benchmarking the tree
+// itself. Lookups index the map with string(key), which does not allocate,
+// and the map is preallocated to the expected number of records. Values are
+// copied in and out, so callers may reuse or modify their buffers.
+type FastMemoryDB struct {
+	lock sync.RWMutex
+	db   map[string][]byte
+}
+
+func NewFastMemoryDB(capacity int) *FastMemoryDB {
+	return &FastMemoryDB{db: make(map[string][]byte, capacity)}
+}
+
+func (db *FastMemoryDB) Get(key []byte) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	value, ok := db.db[string(key)]
+	if !ok {
+		return nil, ErrDatabaseNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+// MultiGet reads keys under a single acquisition of the lock.
+func (db *FastMemoryDB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	values, errs := make([][]byte, len(keys)), make([]error, len(keys))
+	for i, key := range keys {
+		value, ok := db.db[string(key)]
+		if !ok {
+			errs[i] = ErrDatabaseNotFound
+			continue
+		}
+		values[i] = append([]byte{}, value...)
+	}
+	return values, errs
+}
+
+func (db *FastMemoryDB) Has(key []byte) (bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	_, ok := db.db[string(key)]
+	return ok, nil
+}
+
+func (db *FastMemoryDB) Set(key []byte, value []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.db[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (db *FastMemoryDB) Delete(key []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	delete(db.db, string(key))
+	return nil
+}
+
+func (db *FastMemoryDB) Ping(ctx context.Context) error { return nil }
+
+func (db *FastMemoryDB) Iterate(fn func(key, value []byte) error) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	for key, value := range db.db {
+		if err := fn([]byte(key), append([]byte{}, value...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *FastMemoryDB) NewBatch() Batcher {
+	return &fastMemoryBatch{db: db}
+}
+
+type fastMemoryBatchOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+type fastMemoryBatch struct {
+	db  *FastMemoryDB
+	ops []fastMemoryBatchOp
+}
+
+func (b *fastMemoryBatch) Set(key []byte, value []byte) error {
+	b.ops = append(b.ops, fastMemoryBatchOp{key: string(key), value: append([]byte{}, value...)})
+	return nil
+}
+
+func (b *fastMemoryBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, fastMemoryBatchOp{key: string(key), delete: true})
+	return nil
+}
+
+func (b *fastMemoryBatch) Write() error {
+	b.db.lock.Lock()
+	defer b.db.lock.Unlock()
+	for _, op := range b.ops {
+		if op.delete {
+			delete(b.db.db, op.key)
+		} else {
+			b.db.db[op.key] = op.value
+		}
+	}
+	return nil
+}
+
+func (b *fastMemoryBatch) Reset() {
+	b.ops = b.ops[:0]
+}
@@ -0,0 +1,35 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFastMemoryDBDoesNotAliasValues(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	value := []byte("value")
+	if err := db.Set([]byte("set"), value); err != nil {
+		t.Fatal(err)
+	}
+	batch := db.NewBatch()
+	if err := batch.Set([]byte("batch"), value); err != nil {
+		t.Fatal(err)
+	}
+	value[0] = 'X'
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"set", "batch"} {
+		got, err := db.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, []byte("value")) {
+			t.Fatalf("%s: stored value changed with the caller's buffer", key)
+		}
+		got[0] = 'X'
+		if got, _ := db.Get([]byte(key)); !bytes.Equal(got, []byte("value")) {
+			t.Fatalf("%s: stored value changed with a returned buffer", key)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package bsmt
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// flushedStateKey holds the staged state written by Flush.
+const flushedStateKey string = "flushedState"
+
+// Flusher is implemented by a TreeDB that buffers writes and makes them
+// durable only on Flush. A TreeDB that is not a Flusher is taken to make
+// every write durable before it returns.
+type Flusher interface {
+	Flush() error
+}
+
+// flushDB flushes db if it buffers writes.
+func flushDB(db TreeDB) error {
+	if flusher, ok := db.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// Flush makes the staged changes durable without committing them: the
+// staged leaves, tombstones, key hashes and payloads are written to the db
+// as one record tagged with the pending version, and the db is flushed. The
+// latest version is not touched.
+//
+// A tree opened on the db, or reloaded by RecoverIncompleteCommit, stages
+// the flushed changes again if they belong to the version following the
+// latest one; the next Commit then commits them like any other staged
+// change. The record is removed by that Commit, by Reset and by Rollback,
+// and a record of another version, left by a crash between a commit and
+// its removal, is deleted on open. Changes staged after the last Flush are
+// not in the record and are lost by a crash. Flush needs a db.
+func (tree *BASSparseMerkleTree) Flush() error {
+	if tree.db == nil {
+		return ErrDatabaseRequired
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.frozen {
+		return ErrTreeFrozen
+	}
+	record, err := tree.encodeFlushedState()
+	if err != nil {
+		return err
+	}
+	if err := tree.db.Set([]byte(flushedStateKey), record); err != nil {
+		return err
+	}
+	return flushDB(tree.db)
+}
+
+// encodeFlushedState encodes the staged state as uvarint(version), then the
+// staged keys, each as key, leaf and a tombstone byte, the key hashes, each
+// as slot and hash, and the payloads, each as key and payload, every section
+// prefixed with its uvarint count. The caller holds the tree lock
+// exclusively.
+func (tree *BASSparseMerkleTree) encodeFlushedState() ([]byte, error) {
+	var buf bytes.Buffer
+	putUvarint(&buf, tree.version+1)
+	keys := tree.PendingKeys()
+	putUvarint(&buf, uint64(len(keys)))
+	for _, key := range keys {
+		leaf, err := tree.walk(tree.path(key), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		putBytes(&buf, key)
+		putBytes(&buf, leaf)
+		if tree.hasPendingTombstone(key) {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+	tree.journalLock.Lock()
+	putUvarint(&buf, uint64(len(tree.keyHashes)))
+	for slot, keyHash := range tree.keyHashes {
+		putBytes(&buf, []byte(slot))
+		putBytes(&buf, keyHash)
+	}
+	tree.journalLock.Unlock()
+	tree.payloadLock.Lock()
+	putUvarint(&buf, uint64(len(tree.pendingPayloads)))
+	for key, payload := range tree.pendingPayloads {
+		putBytes(&buf, []byte(key))
+		putBytes(&buf, payload)
+	}
+	tree.payloadLock.Unlock()
+	return buf.Bytes(), nil
+}
+
+// loadFlushedState stages the changes written by Flush if they belong to
+// the pending version and deletes a stale record. The caller holds the tree
+// lock exclusively or is the constructor.
+func (tree *BASSparseMerkleTree) loadFlushedState() error {
+	data, err := tree.dbGet([]byte(flushedStateKey))
+	if err == ErrDatabaseNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(data)
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return ErrInvalidFlushedState
+	}
+	if version != tree.version+1 {
+		return tree.db.Delete([]byte(flushedStateKey))
+	}
+	readPairs := func(fn func(a, b []byte) error) error {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return ErrInvalidFlushedState
+		}
+		for i := uint64(0); i < n; i++ {
+			a, err := readFlushedBytes(r)
+			if err != nil {
+				return err
+			}
+			b, err := readFlushedBytes(r)
+			if err != nil {
+				return err
+			}
+			if err := fn(a, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = readPairs(func(key, leaf []byte) error {
+		deleted, err := r.ReadByte()
+		if err != nil {
+			return ErrInvalidFlushedState
+		}
+		return tree.setLocked(key, leaf, false, deleted == 1)
+	})
+	if err != nil {
+		return err
+	}
+	err = readPairs(func(slot, keyHash []byte) error {
+		tree.stageKeyHash(slot, keyHash)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return readPairs(func(key, payload []byte) error {
+		tree.payloadLock.Lock()
+		defer tree.payloadLock.Unlock()
+		if tree.pendingPayloads == nil {
+			tree.pendingPayloads = make(map[string][]byte)
+		}
+		tree.pendingPayloads[string(key)] = payload
+		return nil
+	})
+}
+
+func readFlushedBytes(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return nil, ErrInvalidFlushedState
+	}
+	b := make([]byte, size)
+	_, err = r.Read(b)
+	return b, err
+}
+
+// dropFlushedState deletes the record written by Flush once the changes it
+// holds are discarded.
+func (tree *BASSparseMerkleTree) dropFlushedState() error {
+	if tree.db == nil {
+		return nil
+	}
+	return tree.db.Delete([]byte(flushedStateKey))
+}
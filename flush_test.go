@@ -0,0 +1,281 @@
+package bsmt
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bufferedDB keeps writes in memory until Flush applies them to disk, so a
+// crash loses every write since the last Flush.
+type bufferedDB struct {
+	disk *FastMemoryDB
+
+	lock    sync.Mutex
+	pending map[string][]byte // a nil value is a pending delete
+}
+
+func newBufferedDB(disk *FastMemoryDB) *bufferedDB {
+	return &bufferedDB{disk: disk, pending: make(map[string][]byte)}
+}
+
+func (db *bufferedDB) Get(key []byte) ([]byte, error) {
+	db.lock.Lock()
+	value, ok := db.pending[string(key)]
+	db.lock.Unlock()
+	if !ok {
+		return db.disk.Get(key)
+	}
+	if value == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (db *bufferedDB) Has(key []byte) (bool, error) {
+	_, err := db.Get(key)
+	if err == ErrDatabaseNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (db *bufferedDB) Set(key []byte, value []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.pending[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (db *bufferedDB) Delete(key []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.pending[string(key)] = nil
+	return nil
+}
+
+func (db *bufferedDB) Ping(ctx context.Context) error { return nil }
+
+func (db *bufferedDB) NewBatch() Batcher {
+	return &bufferedBatch{db: db, ops: make(map[string][]byte)}
+}
+
+func (db *bufferedDB) Flush() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for key, value := range db.pending {
+		var err error
+		if value == nil {
+			err = db.disk.Delete([]byte(key))
+		} else {
+			err = db.disk.Set([]byte(key), value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	db.pending = make(map[string][]byte)
+	return nil
+}
+
+func (db *bufferedDB) buffered() int {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return len(db.pending)
+}
+
+type bufferedBatch struct {
+	db  *bufferedDB
+	ops map[string][]byte
+}
+
+func (b *bufferedBatch) Set(key []byte, value []byte) error {
+	b.ops[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *bufferedBatch) Delete(key []byte) error {
+	b.ops[string(key)] = nil
+	return nil
+}
+
+func (b *bufferedBatch) Write() error {
+	b.db.lock.Lock()
+	defer b.db.lock.Unlock()
+	for key, value := range b.ops {
+		b.db.pending[key] = value
+	}
+	return nil
+}
+
+func (b *bufferedBatch) Reset() {
+	b.ops = make(map[string][]byte)
+}
+
+func TestFlushSurvivesCrash(t *testing.T) {
+	disk := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(newBufferedDB(disk)))
+	for i := 0; i < 16; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Set(testKey(1), testValue(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetKey([]byte("hashed key"), testValue(101)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.SetPayload(testKey(3), []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	flushedRoot, flushedKeys := tree.Root(), tree.PendingKeys()
+	// Staged after the Flush, so lost by the crash.
+	if err := tree.Set(testKey(4), testValue(102)); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestTree(t, WithCustomDB(newBufferedDB(disk)))
+	if reopened.LatestVersion() != 1 {
+		t.Fatalf("reopened at version %d, want 1", reopened.LatestVersion())
+	}
+	if !bytes.Equal(reopened.Root(), flushedRoot) {
+		t.Fatal("reopened tree does not stage the flushed changes")
+	}
+	if keys := reopened.PendingKeys(); len(keys) != len(flushedKeys) {
+		t.Fatalf("got %d pending keys, want the %d flushed ones", len(keys), len(flushedKeys))
+	}
+	if _, state, err := reopened.GetState(testKey(2), nil); err != nil || state != KeyDeleted {
+		t.Fatalf("got %v, %v for the flushed Delete, want KeyDeleted", state, err)
+	}
+	if val, err := reopened.GetKey([]byte("hashed key"), nil); err != nil || !bytes.Equal(val, testValue(101)) {
+		t.Fatalf("got %x, %v for the flushed SetKey", val, err)
+	}
+	if payload, err := reopened.GetPayload(testKey(3)); err != nil || !bytes.Equal(payload, []byte("payload")) {
+		t.Fatalf("got %q, %v for the flushed payload", payload, err)
+	}
+
+	if _, err := reopened.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	committedRoot := reopened.CommittedRoot()
+	if err := reopened.db.(Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	again := newTestTree(t, WithCustomDB(newBufferedDB(disk)))
+	if again.LatestVersion() != 2 || !bytes.Equal(again.Root(), committedRoot) || again.PendingCount() != 0 {
+		t.Fatal("the commit of the flushed changes did not finalize them")
+	}
+}
+
+func TestFlushedStateDropped(t *testing.T) {
+	flushed := func(t *testing.T, db TreeDB) *BASSparseMerkleTree {
+		tree := newTestTree(t, WithCustomDB(db))
+		if err := tree.Set(testKey(1), testValue(1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+
+	db := NewFastMemoryDB(0)
+	if err := flushed(t, db).Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if newTestTree(t, WithCustomDB(db)).PendingCount() != 0 {
+		t.Fatal("changes flushed before Reset are staged again")
+	}
+
+	db = NewFastMemoryDB(0)
+	tree := flushed(t, db)
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Rollback(0); err != nil {
+		t.Fatal(err)
+	}
+	if newTestTree(t, WithCustomDB(db)).PendingCount() != 0 {
+		t.Fatal("changes flushed before a commit rolled back are staged again")
+	}
+
+	// A record whose version was committed meanwhile is stale.
+	db = NewFastMemoryDB(0)
+	tree = flushed(t, db)
+	record, err := db.Get([]byte(flushedStateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set([]byte(flushedStateKey), record); err != nil {
+		t.Fatal(err)
+	}
+	if newTestTree(t, WithCustomDB(db)).PendingCount() != 0 {
+		t.Fatal("a stale flushed record is staged")
+	}
+	if _, err := db.Get([]byte(flushedStateKey)); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v, want the stale record deleted", err)
+	}
+}
+
+func TestFlushNeedsWritableTreeWithDB(t *testing.T) {
+	if err := newTestTree(t).Flush(); err != ErrDatabaseRequired {
+		t.Fatalf("got %v, want ErrDatabaseRequired", err)
+	}
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	if err := tree.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != ErrTreeFrozen {
+		t.Fatalf("got %v, want ErrTreeFrozen", err)
+	}
+}
+
+func TestWrappersForwardFlush(t *testing.T) {
+	wrappers := map[string]func(a, b TreeDB) TreeDB{
+		"sharded": func(a, b TreeDB) TreeDB {
+			return NewShardedDB([]TreeDB{a, b}, func(key []byte) int { return int(key[0]) % 2 })
+		},
+		"retry": func(a, b TreeDB) TreeDB { return NewRetryDB(a, RetryPolicy{}) },
+		"primary replica": func(a, b TreeDB) TreeDB {
+			return NewPrimaryReplicaDB(a, b, true)
+		},
+		"dual write": func(a, b TreeDB) TreeDB { return NewDualWriteDB(a, b, nil) },
+		"access recorder": func(a, b TreeDB) TreeDB {
+			db, _ := NewAccessRecorderDB(a, nil)
+			return db
+		},
+		"coalescing": func(a, b TreeDB) TreeDB { return NewCoalescingDB(a, time.Millisecond) },
+	}
+	for name, wrap := range wrappers {
+		inner := []*bufferedDB{newBufferedDB(NewFastMemoryDB(0)), newBufferedDB(NewFastMemoryDB(0))}
+		db := wrap(inner[0], inner[1])
+		for i := 0; i < 8; i++ {
+			if err := db.Set(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.(Flusher).Flush(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for i, inner := range inner {
+			if inner.buffered() != 0 {
+				t.Fatalf("%s: writes to inner db %d not flushed", name, i)
+			}
+		}
+	}
+}
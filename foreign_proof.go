@@ -0,0 +1,83 @@
+package bsmt
+
+import "bytes"
+
+// ForeignProofOptions describe how a compatible SMT implementation lays out
+// its proofs. The zero value is this package's own layout: siblings from
+// the leaf up, one per level, an empty sibling standing for the empty
+// subtree, and paths read from the most significant bit of each key byte.
+type ForeignProofOptions struct {
+	// RootFirst lists siblings from the root down.
+	RootFirst bool
+	// NilBitmap omits empty siblings: bit i, counted from the least
+	// significant bit of byte 0 and in the order of the siblings, marks
+	// level i as an empty subtree with no entry in siblings.
+	NilBitmap []byte
+	// LSBFirst reads the path from the least significant bit of each key
+	// byte.
+	LSBFirst bool
+}
+
+// VerifyForeignProof verifies a proof of key with leaf against root, as
+// produced by another SMT implementation using the same hasher, depth and
+// nil hashes but the layout described by opts. Siblings equal to a nil
+// hash are accepted whether they are given explicitly or left empty. The
+// key is taken as a path, without WithPathEncoding.
+func (tree *BASSparseMerkleTree) VerifyForeignProof(key, leaf []byte, siblings [][]byte, root []byte, opts ForeignProofOptions) bool {
+	depth := int(tree.maxDepth)
+	if opts.NilBitmap != nil {
+		if len(opts.NilBitmap)*8 < depth {
+			return false
+		}
+		expanded := make([][]byte, depth)
+		next := 0
+		for i := range expanded {
+			if opts.NilBitmap[i/8]&(1<<uint(i%8)) != 0 {
+				continue
+			}
+			if next == len(siblings) {
+				return false
+			}
+			expanded[i] = siblings[next]
+			next++
+		}
+		if next != len(siblings) {
+			return false
+		}
+		siblings = expanded
+	}
+	if len(siblings) != depth {
+		return false
+	}
+	proof := Proof{Key: key, Leaf: leaf, Root: root, MerkleProof: make([][]byte, depth)}
+	for i, sibling := range siblings {
+		level := i
+		if opts.RootFirst {
+			level = depth - 1 - i
+		}
+		// VerifyProof takes empty subtrees only as empty siblings.
+		if !bytes.Equal(sibling, tree.nilHashes[depth-level]) {
+			proof.MerkleProof[level] = sibling
+		}
+	}
+	if opts.LSBFirst {
+		proof.Key = make([]byte, len(key))
+		for i, b := range key {
+			for bit := uint(0); bit < 8; bit++ {
+				proof.Key[i] |= (b >> bit & 1) << (7 - bit)
+			}
+		}
+	}
+	helpers, ok := proofHelpers(proof.Key, depth)
+	if !ok {
+		return false
+	}
+	proof.ProofHelper = helpers
+	verifier := &BASSparseMerkleTree{
+		maxDepth:  tree.maxDepth,
+		hasher:    tree.hasher,
+		nilHashes: tree.nilHashes,
+		metrics:   &metrics{},
+	}
+	return verifier.VerifyProof(proof)
+}
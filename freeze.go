@@ -0,0 +1,38 @@
+package bsmt
+
+// Freeze makes the tree read-only: Set, Commit, Rollback, Reset, Flush and
+// Compact return ErrTreeFrozen from then on, while Get and proofs keep
+// working. With a db the flag is persisted, so the tree stays frozen when
+// reopened. A tree cannot be unfrozen.
+func (tree *BASSparseMerkleTree) Freeze() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.db != nil {
+		if err := tree.db.Set([]byte(frozenKey), []byte{1}); err != nil {
+			return err
+		}
+	}
+	tree.frozen = true
+	return nil
+}
+
+// Frozen reports whether Freeze has been called on the tree or on the tree
+// it was reopened from.
+func (tree *BASSparseMerkleTree) Frozen() bool {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.frozen
+}
+
+// loadFrozen restores the flag written by Freeze.
+func (tree *BASSparseMerkleTree) loadFrozen() error {
+	data, err := tree.db.Get([]byte(frozenKey))
+	if err == ErrDatabaseNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tree.frozen = len(data) == 1 && data[0] == 1
+	return nil
+}
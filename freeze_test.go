@@ -0,0 +1,55 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	roots := commitVersions(t, tree, 16)
+	if err := tree.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := newTestTree(t, WithCustomDB(db))
+	for name, tree := range map[string]*BASSparseMerkleTree{"frozen": tree, "reopened": reopened} {
+		if !tree.Frozen() {
+			t.Fatalf("%s: tree is not frozen", name)
+		}
+		for op, err := range map[string]error{
+			"Set":        tree.Set(testKey(1), testValue(100)),
+			"Delete":     tree.Delete(testKey(1)),
+			"SetKey":     tree.SetKey([]byte("key"), testValue(100)),
+			"SetPayload": tree.SetPayload(testKey(1), []byte("payload")),
+			"Rollback":   tree.Rollback(1),
+			"Reset":      tree.Reset(),
+			"Flush":      tree.Flush(),
+			"Compact":    tree.Compact(),
+		} {
+			if err != ErrTreeFrozen {
+				t.Fatalf("%s: %s returned %v, want ErrTreeFrozen", name, op, err)
+			}
+		}
+		if _, err := tree.Commit(); err != ErrTreeFrozen {
+			t.Fatalf("%s: Commit returned %v, want ErrTreeFrozen", name, err)
+		}
+		if _, err := tree.ReplaceAll(testKVs(4)); err != ErrTreeFrozen {
+			t.Fatalf("%s: ReplaceAll returned %v, want ErrTreeFrozen", name, err)
+		}
+		if tree.LatestVersion() != 3 || !bytes.Equal(tree.Root(), roots[3]) {
+			t.Fatalf("%s: a rejected write changed the tree", name)
+		}
+		val, err := tree.Get(testKey(2), nil)
+		if err != nil || !bytes.Equal(val, testValue(6)) {
+			t.Fatalf("%s: got %x, %v reading a frozen tree", name, val, err)
+		}
+		proof, err := tree.GetProof(testKey(2), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.CheckProof(proof); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bsmt
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// GCStrategy selects which resident blocks GC releases first.
+type GCStrategy int
+
+const (
+	// GCByVersion releases the blocks last changed in the oldest version
+	// first.
+	GCByVersion GCStrategy = iota
+	// GCByAccessRecency releases the least recently accessed nodes first,
+	// keeping hot subtrees resident under skewed read patterns.
+	GCByAccessRecency
+)
+
+// touch records an access to node for GCByAccessRecency. Reads touch
+// nodes under the shared tree lock, so the clock is atomic.
+func (tree *BASSparseMerkleTree) touch(node *FullTreeNode) {
+	if tree.gcStrategy != GCByAccessRecency {
+		return
+	}
+	atomic.StoreUint64(&node.LastAccess, atomic.AddUint64(&tree.accessClock, 1))
+}
+
+// gcCandidate is a block that GC may unload.
+type gcCandidate struct {
+	node *FullTreeNode
+	path []byte
+}
+
+// GC unloads blocks that are only resident to serve reads until at most
+// limit nodes are resident, or none is left to unload. Blocks are released
+// in the order of the WithGCStrategy strategy, innermost blocks first, and
+// reported to the eviction callback. Nodes with staged changes and the
+// always resident levels are kept.
+func (tree *BASSparseMerkleTree) GC(limit uint64) {
+	for _, node := range tree.collectGC(limit) {
+		tree.evictionCallback(node.depth, node.path)
+	}
+}
+
+// enforceResidentCap runs GC down to the WithResidentCap limit once more
+// nodes are resident.
+func (tree *BASSparseMerkleTree) enforceResidentCap() {
+	if tree.residentCap > 0 && tree.Size() > tree.residentCap {
+		tree.GC(tree.residentCap)
+	}
+}
+
+func (tree *BASSparseMerkleTree) collectGC(limit uint64) []evictedNode {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	var evicted []evictedNode
+	for tree.Size() > limit {
+		candidates := tree.gcCandidates()
+		if len(candidates) == 0 {
+			break
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return tree.releaseBefore(candidates[i].node, candidates[j].node)
+		})
+		for _, c := range candidates {
+			if tree.Size() <= limit {
+				break
+			}
+			evicted = append(evicted, tree.unloadBlock(c.node, c.path)...)
+		}
+	}
+	return evicted
+}
+
+// gcCandidates returns the evictable blocks with no evictable block below,
+// in path order. Unloading one never unlinks another.
+func (tree *BASSparseMerkleTree) gcCandidates() []gcCandidate {
+	var candidates []gcCandidate
+	var find func(node *FullTreeNode, path []byte) bool
+	find = func(node *FullTreeNode, path []byte) bool {
+		if node == nil || node.Depth >= tree.maxDepth {
+			return false
+		}
+		found := find(fullNode(node.LeftChild), path)
+		path[node.Depth/8] |= 0x80 >> (node.Depth % 8)
+		found = find(fullNode(node.RightChild), path) || found
+		path[node.Depth/8] &^= 0x80 >> (node.Depth % 8)
+		if !found && tree.evictable(node) {
+			candidates = append(candidates, gcCandidate{node: node, path: append([]byte{}, path...)})
+			return true
+		}
+		return found
+	}
+	find(tree.rootNode(), make([]byte, (int(tree.maxDepth)+7)/8))
+	return candidates
+}
+
+// releaseBefore orders blocks for GC: by the last access of their root
+// under GCByAccessRecency, by the latest version that changed it otherwise.
+func (tree *BASSparseMerkleTree) releaseBefore(a, b *FullTreeNode) bool {
+	if tree.gcStrategy == GCByAccessRecency {
+		return atomic.LoadUint64(&a.LastAccess) < atomic.LoadUint64(&b.LastAccess)
+	}
+	return a.Versions[len(a.Versions)-1].Ver < b.Versions[len(b.Versions)-1].Ver
+}
@@ -0,0 +1,147 @@
+package bsmt
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// evictedKeys runs GC down to one node less than resident and returns the
+// indexes of the keys whose path lost nodes.
+func evictedKeys(t *testing.T, tree *BASSparseMerkleTree, keys [][]byte) []int {
+	t.Helper()
+	var evicted []int
+	tree.evictionCallback = func(depth uint8, path []byte) {
+		for i, key := range keys {
+			if bytes.Equal(storageNodeKey(depth-1, path), storageNodeKey(depth-1, key)) {
+				evicted = append(evicted, i)
+			}
+		}
+	}
+	tree.GC(tree.Size() - 1)
+	return evicted
+}
+
+func TestGCOrder(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	keys := [][]byte{testKey(0), testKey(1), testKey(2)}
+	// Each key is last changed in its own version.
+	for i, key := range keys {
+		if err := tree.Set(key, testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(tree *BASSparseMerkleTree, order ...int) {
+		for _, i := range order {
+			if _, err := tree.Get(keys[i], nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	byVersion := newTestTree(t, WithCustomDB(db))
+	read(byVersion, 2, 0, 1)
+	if evicted := evictedKeys(t, byVersion, keys); len(evicted) != 1 || evicted[0] != 0 {
+		t.Fatalf("GCByVersion released the blocks of keys %v, want those of the oldest key 0", evicted)
+	}
+
+	byAccess := newTestTree(t, WithCustomDB(db), WithGCStrategy(GCByAccessRecency))
+	read(byAccess, 2, 0, 1, 2, 0)
+	if evicted := evictedKeys(t, byAccess, keys); len(evicted) != 1 || evicted[0] != 1 {
+		t.Fatalf("GCByAccessRecency released the blocks of keys %v, want those of the least recent key 1", evicted)
+	}
+
+	base := countResident(newTestTree(t, WithCustomDB(db)).root)
+	byAccess.GC(0)
+	if n := countResident(byAccess.root); n != base || byAccess.Size() != base {
+		t.Fatalf("%d nodes resident after a full GC, want the %d always resident", n, base)
+	}
+	for i, key := range keys {
+		val, err := byAccess.Get(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, testValue(i)) {
+			t.Fatalf("key %d reads another value after GC", i)
+		}
+	}
+}
+
+func TestResidentCap(t *testing.T) {
+	const keys, residentCap = 512, 64
+	db := NewFastMemoryDB(0)
+	writer := newTestTree(t, WithCustomDB(db))
+	for i := 0; i < keys; i++ {
+		if err := writer.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := newTestTree(t, WithCustomDB(db), WithResidentCap(residentCap))
+	for i := 0; i < keys; i++ {
+		val, err := tree.Get(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, testValue(i)) {
+			t.Fatalf("key %d reads differently under the cap", i)
+		}
+		if _, err := tree.GetProof(testKey(keys-1-i), nil); err != nil {
+			t.Fatal(err)
+		}
+		if tree.Size() > residentCap {
+			t.Fatalf("%d nodes resident after read %d, want at most %d", tree.Size(), i, residentCap)
+		}
+	}
+	if !bytes.Equal(tree.Root(), writer.Root()) {
+		t.Fatal("the capped tree has another root")
+	}
+}
+
+// BenchmarkGCStrategyZipf counts the db reads of Gets under a resident cap
+// when a few keys are read most of the time, following a Zipf distribution
+// whose hot keys were last changed in every version alike.
+func BenchmarkGCStrategyZipf(b *testing.B) {
+	const keys, versions = 2048, 16
+	db := NewFastMemoryDB(0)
+	writer := newTestTree(b, WithCustomDB(db))
+	for v := 0; v < versions; v++ {
+		for i := v; i < keys; i += versions {
+			if err := writer.Set(testKey(i), testValue(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := writer.Commit(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		name     string
+		strategy GCStrategy
+	}{
+		{"version", GCByVersion},
+		{"access recency", GCByAccessRecency},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			tree := newTestTree(b, WithCustomDB(db), WithGCStrategy(tc.strategy), WithResidentCap(8192))
+			zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, keys-1)
+			tree.ResetStats()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Scatter the ranks so hot keys are not those of one version.
+				key := testKey(int(zipf.Uint64()*797) % keys)
+				if _, err := tree.Get(key, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(tree.Stats().DBReads)/float64(b.N), "reads/get")
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package bsmt
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// hashCache is a bounded LRU of internal node hashes keyed by left||right.
+type hashCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type hashCacheEntry struct {
+	key  string
+	hash []byte
+}
+
+func newHashCache(size int) *hashCache {
+	return &hashCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+func (cache *hashCache) get(key string) ([]byte, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(elem)
+	return elem.Value.(*hashCacheEntry).hash, true
+}
+
+func (cache *hashCache) add(key string, hash []byte) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if elem, ok := cache.entries[key]; ok {
+		cache.order.MoveToFront(elem)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&hashCacheEntry{key: key, hash: hash})
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*hashCacheEntry).key)
+	}
+}
+
+// hashChildren returns the hash of an internal node from its children,
+// consulting the hash cache when WithHashCache is enabled.
+func (tree *BASSparseMerkleTree) hashChildren(left, right []byte) []byte {
+	if tree.hashCache == nil {
+		return tree.hasher.Hash(left, right)
+	}
+	key := string(left) + string(right)
+	if hash, ok := tree.hashCache.get(key); ok {
+		atomic.AddUint64(&tree.metrics.cacheHits, 1)
+		return hash
+	}
+	atomic.AddUint64(&tree.metrics.cacheMisses, 1)
+	hash := tree.hasher.Hash(left, right)
+	tree.hashCache.add(key, hash)
+	return hash
+}
@@ -0,0 +1,135 @@
+package bsmt
+
+import "bytes"
+
+const keyHashPrefix string = "keyHash"
+
+// slotOf maps an arbitrary key to its slot: the top maxDepth bits of the
+// key's hash, with the remaining bits of the last byte cleared.
+func (tree *BASSparseMerkleTree) slotOf(keyHash []byte) []byte {
+	depth := int(tree.maxDepth)
+	if depth == 0 || depth > len(keyHash)*8 {
+		depth = len(keyHash) * 8
+	}
+	slot := append([]byte{}, keyHash[:(depth+7)/8]...)
+	if rem := depth % 8; rem != 0 {
+		slot[len(slot)-1] &= 0xff << uint(8-rem)
+	}
+	return slot
+}
+
+// SetKey sets val under the slot derived from the hash of key. The full key
+// hash is committed with the slot so that a different key mapping to the
+// same slot is rejected with ErrKeyCollision rather than overwriting it.
+// A slot whose leaf is empty again, e.g. after a rollback, is free. The slot
+// is checked and set under the exclusive tree lock, so of two keys racing
+// for a free slot only one gets it.
+func (tree *BASSparseMerkleTree) SetKey(key, val []byte) error {
+	keyHash := tree.hasher.Hash(key)
+	slot := tree.slotOf(keyHash)
+	leaf, err := tree.checkedLeaf(slot, val)
+	if err != nil {
+		return err
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	err = tree.checkKeyHash(slot, keyHash, func() ([]byte, error) {
+		return tree.getLatest(slot)
+	})
+	if err != nil && err != ErrDatabaseNotFound {
+		return err
+	}
+	if err := tree.setLocked(slot, leaf, tree.wal != nil, false); err != nil {
+		return err
+	}
+	tree.stageKeyHash(slot, keyHash)
+	return nil
+}
+
+// stageKeyHash records keyHash as the key hash of slot for the next commit.
+func (tree *BASSparseMerkleTree) stageKeyHash(slot, keyHash []byte) {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	if tree.keyHashes == nil {
+		tree.keyHashes = make(map[string][]byte)
+	}
+	tree.keyHashes[string(slot)] = keyHash
+}
+
+// GetKey reads the value set with SetKey for key at version.
+func (tree *BASSparseMerkleTree) GetKey(key []byte, version *Version) ([]byte, error) {
+	keyHash := tree.hasher.Hash(key)
+	slot := tree.slotOf(keyHash)
+	err := tree.checkKeyHash(slot, keyHash, func() ([]byte, error) {
+		return tree.Get(slot, version)
+	})
+	if err != nil {
+		if err == ErrDatabaseNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return tree.Get(slot, version)
+}
+
+// checkKeyHash fails with ErrKeyCollision if slot holds a value, as read by
+// read, that was set for another key hash, and with ErrDatabaseNotFound if
+// it is not owned by any key.
+func (tree *BASSparseMerkleTree) checkKeyHash(slot, keyHash []byte, read func() ([]byte, error)) error {
+	stored, err := tree.storedKeyHash(slot)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(stored, keyHash) {
+		return nil
+	}
+	val, err := read()
+	if err != nil {
+		return err
+	}
+	if len(val) == 0 {
+		return ErrDatabaseNotFound
+	}
+	return ErrKeyCollision
+}
+
+// storedKeyHash returns the key hash staged or committed for slot.
+func (tree *BASSparseMerkleTree) storedKeyHash(slot []byte) ([]byte, error) {
+	tree.journalLock.Lock()
+	keyHash, ok := tree.keyHashes[string(slot)]
+	if !ok && tree.db == nil {
+		keyHash, ok = tree.committedKeyHashes[string(slot)]
+	}
+	tree.journalLock.Unlock()
+	if ok {
+		return keyHash, nil
+	}
+	if tree.db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	return tree.dbGet(append([]byte(keyHashPrefix), slot...))
+}
+
+// writeKeyHashes adds the staged key hashes to the commit batch.
+func (tree *BASSparseMerkleTree) writeKeyHashes(batch Batcher) error {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	for slot, keyHash := range tree.keyHashes {
+		if err := batch.Set(append([]byte(keyHashPrefix), slot...), keyHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepKeyHashes commits the staged key hashes of a tree without a db.
+func (tree *BASSparseMerkleTree) keepKeyHashes() {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	if tree.committedKeyHashes == nil {
+		tree.committedKeyHashes = make(map[string][]byte)
+	}
+	for slot, keyHash := range tree.keyHashes {
+		tree.committedKeyHashes[slot] = keyHash
+	}
+}
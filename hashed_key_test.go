@@ -0,0 +1,124 @@
+package bsmt
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collidingKeys returns two keys whose hashes share a slot of tree.
+func collidingKeys(t *testing.T, tree *BASSparseMerkleTree) ([]byte, []byte) {
+	t.Helper()
+	seen := make(map[string][]byte)
+	for i := 0; i < 1<<16; i++ {
+		key := testKey(i)
+		slot := string(tree.slotOf(tree.hasher.Hash(key)))
+		if other, ok := seen[slot]; ok {
+			return other, key
+		}
+		seen[slot] = key
+	}
+	t.Fatal("no colliding keys")
+	return nil, nil
+}
+
+func TestSetKeyCommitsKeyHash(t *testing.T) {
+	for _, db := range []TreeDB{nil, NewFastMemoryDB(0)} {
+		opts := []Option{WithMaxDepth(8)}
+		if db != nil {
+			opts = append(opts, WithCustomDB(db))
+		}
+		tree := newTestTree(t, opts...)
+		a, b := collidingKeys(t, tree)
+
+		if err := tree.SetKey(a, testValue(1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.SetKey(b, testValue(2)); err != ErrKeyCollision {
+			t.Fatalf("got %v, want ErrKeyCollision for a staged slot", err)
+		}
+		tree.Reset()
+		if val, err := tree.GetKey(a, nil); err != nil || val != nil {
+			t.Fatalf("got %x, %v after Reset, want no value", val, err)
+		}
+		if err := tree.SetKey(b, testValue(2)); err != nil {
+			t.Fatalf("slot reset by Reset is still taken: %v", err)
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.SetKey(a, testValue(1)); err != ErrKeyCollision {
+			t.Fatalf("got %v, want ErrKeyCollision for a committed slot", err)
+		}
+		if err := tree.Rollback(0); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.SetKey(a, testValue(1)); err != nil {
+			t.Fatalf("slot rolled back is still taken: %v", err)
+		}
+		val, err := tree.GetKey(a, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, testValue(1)) {
+			t.Fatal("GetKey reads another value")
+		}
+	}
+}
+
+// slowReadDB delays every read, widening the window between the slot check
+// of SetKey and its write.
+type slowReadDB struct {
+	*FastMemoryDB
+}
+
+func (db *slowReadDB) Get(key []byte) ([]byte, error) {
+	time.Sleep(time.Millisecond)
+	return db.FastMemoryDB.Get(key)
+}
+
+func TestConcurrentSetKeyOnFreeSlot(t *testing.T) {
+	for round := 0; round < 8; round++ {
+		tree := newTestTree(t, WithMaxDepth(8), WithCustomDB(&slowReadDB{NewFastMemoryDB(0)}))
+		a, b := collidingKeys(t, tree)
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for i, key := range [][]byte{a, b} {
+			wg.Add(1)
+			go func(i int, key []byte) {
+				defer wg.Done()
+				errs[i] = tree.SetKey(key, testValue(i))
+			}(i, key)
+		}
+		wg.Wait()
+		if (errs[0] == nil) == (errs[1] == nil) {
+			t.Fatalf("got %v and %v, want exactly one key to take the slot", errs[0], errs[1])
+		}
+		for _, err := range errs {
+			if err != nil && err != ErrKeyCollision {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// emptyReadDB returns an empty value without an error for a missing key.
+type emptyReadDB struct {
+	*FastMemoryDB
+}
+
+func (db *emptyReadDB) Get(key []byte) ([]byte, error) {
+	val, err := db.FastMemoryDB.Get(key)
+	if err == ErrDatabaseNotFound {
+		return nil, nil
+	}
+	return val, err
+}
+
+func TestSetKeyOnEmptyReads(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(&emptyReadDB{NewFastMemoryDB(0)}))
+	if err := tree.SetKey([]byte("key"), testValue(1)); err != nil {
+		t.Fatalf("an empty read is taken for a collision: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package bsmt
+
+import (
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// Hasher wraps a hash.Hash to hash a sequence of inputs in one call. It is
+// safe for concurrent use: calls share the wrapped hash.Hash under a lock.
+type Hasher struct {
+	lock      sync.Mutex
+	hasher    hash.Hash
+	outputLen int
+	id        string
+}
+
+func NewHasher(hasher hash.Hash) *Hasher {
+	return NewHasherWithOutputLen(hasher, hasher.Size())
+}
+
+// NewHasherWithOutputLen returns a Hasher whose digests are truncated to
+// outputLen bytes, trading security margin for smaller nodes and proofs.
+func NewHasherWithOutputLen(hasher hash.Hash, outputLen int) *Hasher {
+	if outputLen <= 0 || outputLen > hasher.Size() {
+		outputLen = hasher.Size()
+	}
+	id := fmt.Sprintf("%T", hasher)
+	if outputLen != hasher.Size() {
+		id = fmt.Sprintf("%s/%d", id, outputLen)
+	}
+	return &Hasher{hasher: hasher, outputLen: outputLen, id: id}
+}
+
+// Named overrides the identity of the hasher, e.g. with a name verifiers in
+// other languages can match, and returns it.
+func (h *Hasher) Named(id string) *Hasher {
+	h.id = id
+	return h
+}
+
+// ID identifies the hash function. By default it is derived from the Go
+// type of the wrapped hash and the output length.
+func (h *Hasher) ID() string {
+	return h.id
+}
+
+func (h *Hasher) Hash(inputs ...[]byte) []byte {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.hasher.Reset()
+	for i := range inputs {
+		h.hasher.Write(inputs[i])
+	}
+	return h.hasher.Sum(nil)[:h.outputLen]
+}
+
+// Size returns the length of the digests produced by Hash.
+func (h *Hasher) Size() int {
+	return h.outputLen
+}
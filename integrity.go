@@ -0,0 +1,42 @@
+package bsmt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// configMAC returns the HMAC-SHA256 of the encoded config record keyed by
+// the user-supplied integrity key.
+func (tree *BASSparseMerkleTree) configMAC(config []byte) []byte {
+	mac := hmac.New(sha256.New, tree.integrityKey)
+	mac.Write(config)
+	return mac.Sum(nil)
+}
+
+// writeConfigIntegrity stores the MAC of config alongside it. It is a no-op
+// unless WithStoreIntegrityKey was supplied.
+func (tree *BASSparseMerkleTree) writeConfigIntegrity(config []byte) error {
+	if len(tree.integrityKey) == 0 {
+		return nil
+	}
+	return tree.db.Set([]byte(configIntegrityKey), tree.configMAC(config))
+}
+
+// checkConfigIntegrity verifies the stored MAC against config. It only
+// guards the structural parameters; node data is committed to by the root.
+func (tree *BASSparseMerkleTree) checkConfigIntegrity(config []byte) error {
+	if len(tree.integrityKey) == 0 {
+		return nil
+	}
+	stored, err := tree.dbGet([]byte(configIntegrityKey))
+	if err == ErrDatabaseNotFound {
+		return ErrIntegrityCheckFailed
+	}
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(stored, tree.configMAC(config)) {
+		return ErrIntegrityCheckFailed
+	}
+	return nil
+}
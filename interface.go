@@ -1,19 +1,95 @@
 package bsmt
 
+import (
+	"context"
+	"io"
+)
+
 type (
 	Version          uint64
 	SparseMerkleTree interface {
 		Get(key []byte, version *Version) ([]byte, error)
-		Set(key, val []byte)
+		Prefetch(keys [][]byte) error
+		Set(key, val []byte) error
+		SetPreimage(key, preimage []byte) error
+		SetEmpty(key []byte) error
+		Delete(key []byte) error
+		GetState(key []byte, version *Version) ([]byte, KeyState, error)
+		SetPayload(key, payload []byte) error
+		SetKey(key, val []byte) error
+		GetKey(key []byte, version *Version) ([]byte, error)
+		GetPayload(key []byte) ([]byte, error)
 		IsEmpty(key []byte) bool
 		Root() []byte
+		NilHashes() [][]byte
+		VerifyNilHashes(expected [][]byte) bool
+		PendingRoot() ([]byte, Version, error)
+		CommittedRoot() []byte
+		CommittedProof(key []byte) (Proof, error)
+		CommitmentRoot() []byte
+		ShadowRoot() ([]byte, error)
 		GetProof(key []byte, version *Version) (Proof, error)
+		GetRawProof(key []byte, version *Version) ([][]byte, error)
+		GetKeyVersionProofs(key []byte, versions []Version) ([]Proof, error)
+		GetProofVerbose(key []byte) (Proof, []ProofStep, error)
+		StreamProof(key []byte, fn func(level int, sibling []byte, isNil bool) error) error
+		ProofCost(key []byte) (int, int, error)
+		ProofReadSet(key []byte) ([][]byte, error)
+		VersionCount(key []byte) (int, error)
+		VerifyProof(proof Proof) bool
+		CheckProof(proof Proof) error
+		RootWithLeaf(proof Proof, candidateLeaf []byte) []byte
+		VerifyValueProof(key, value []byte, proof Proof, root []byte) bool
+		VerifyKeyValueProof(key, val []byte, proof Proof, root []byte) bool
+		VerifyForeignProof(key, leaf []byte, siblings [][]byte, root []byte, opts ForeignProofOptions) bool
+		VerifyProofs(keys [][]byte, proofs []Proof) (bool, int)
+		VerifyBatchProof(bp *BatchProof) (bool, int)
+		GetWitness(key []byte, version *Version) ([]byte, error)
+		VerifyWitness(witness []byte, root []byte) ([]byte, []byte, bool)
+		VerifySubtreeProof(prefix []byte, prefixBits int, key []byte, proof Proof, subtreeRoot []byte) bool
 		LatestVersion() Version
+		Config() TreeConfig
+		Snapshot(version Version) (*TreeSnapshot, error)
+		Overlay() *TreeOverlay
+		VersionRoots() ([]VersionRoot, error)
+		VerifyRootAtVersion(version Version, root []byte) (bool, error)
+		VerifyRootSignature(version Version, verifier RootVerifier) ([]byte, bool, error)
 		Reset() error
+		Flush() error
+		PendingKeys() [][]byte
+		PendingCount() int
+		Freeze() error
+		Frozen() bool
 		Commit() (Version, error)
+		CommitAs(version Version, recentVersion *Version) (Version, error)
+		CommitWithAnnotations(anns map[string][]byte, recentVersion *Version) (Version, error)
+		CommitExpectingRoot(expected []byte, recentVersion *Version) (Version, error)
+		Annotations(version Version) (map[string][]byte, error)
 		Rollback(version Version) error
+		EarliestRollbackVersion() Version
+		RecoverIncompleteCommit() (Version, error)
+		RecoverFromWAL(r io.Reader) error
+		CommitWithContext(ctx context.Context, progress ProgressFunc) (Version, error)
+		RollbackWithContext(ctx context.Context, version Version, progress ProgressFunc) error
+		ReplaceAll(kvs []KV) (Version, error)
+		BuildFrom(kvs []KV, checkpointEvery int) (Version, error)
+		ResumeBuild(kvs []KV, checkpointEvery int) (Version, error)
+		HealthCheck(ctx context.Context) error
+		SelfTest() error
+		Stats() Stats
+		Size() uint64
+		ResetStats()
 	}
 	TreeNode interface{}
+
+	// ProgressFunc is called periodically by long-running operations with the
+	// number of nodes processed so far.
+	ProgressFunc func(nodesProcessed int)
+
+	KV struct {
+		Key []byte
+		Val []byte
+	}
 )
 
 type (
@@ -37,6 +113,9 @@ type (
 		// NewBatch creates a write-only database that buffers changes to its host db
 		// until a final write is called.
 		NewBatch() Batcher
+
+		// Ping checks that the backend is reachable.
+		Ping(ctx context.Context) error
 	}
 
 	Batcher interface {
@@ -0,0 +1,47 @@
+package bsmt
+
+import (
+	"bytes"
+	"sort"
+)
+
+// journalKey records key as staged for the next commit.
+func (tree *BASSparseMerkleTree) journalKey(key []byte) {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	if tree.journal == nil {
+		tree.journal = make(map[string]struct{})
+	}
+	tree.journal[string(key)] = struct{}{}
+}
+
+func (tree *BASSparseMerkleTree) clearJournal() {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	tree.journal = nil
+	tree.tombstones = nil
+	tree.keyHashes = nil
+	tree.clearPayloads()
+}
+
+// PendingKeys returns the keys staged since the last commit, sorted.
+func (tree *BASSparseMerkleTree) PendingKeys() [][]byte {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	keys := make([][]byte, 0, len(tree.journal))
+	for key := range tree.journal {
+		keys = append(keys, []byte(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// PendingCount returns the number of distinct keys staged since the last
+// commit.
+func (tree *BASSparseMerkleTree) PendingCount() int {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	return len(tree.journal)
+}
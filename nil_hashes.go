@@ -0,0 +1,75 @@
+package bsmt
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// constructNilHashes builds the hashes of empty subtrees, indexed by depth:
+// nilHashes[maxDepth] is the empty leaf and nilHashes[0] the empty root.
+// The empty leaf is the WithEmptyLeafEncoding value, or zero bytes. The
+// ladder is seeded with the empty leaf, or with the WithNilLadderSeed value
+// when set:
+//
+//	nilHashes[maxDepth-1] = H(seed, seed)
+//	nilHashes[d]          = H(nilHashes[d+1], nilHashes[d+1]) for d < maxDepth-1
+func (tree *BASSparseMerkleTree) constructNilHashes() {
+	hashes := make([][]byte, int(tree.maxDepth)+1)
+	hashes[tree.maxDepth] = tree.emptyLeaf
+	if hashes[tree.maxDepth] == nil {
+		hashes[tree.maxDepth] = make([]byte, tree.hasher.Size())
+	}
+	seed := hashes[tree.maxDepth]
+	if tree.nilLadderSeed != nil {
+		seed = tree.nilLadderSeed
+	}
+	for depth := int(tree.maxDepth) - 1; depth >= 0; depth-- {
+		hashes[depth] = tree.hasher.Hash(seed, seed)
+		seed = hashes[depth]
+	}
+	tree.nilHashes = hashes
+}
+
+// NilHashes returns a copy of the empty subtree hashes indexed by depth,
+// from the empty root at 0 to the empty leaf at maxDepth.
+func (tree *BASSparseMerkleTree) NilHashes() [][]byte {
+	hashes := make([][]byte, len(tree.nilHashes))
+	for i := range tree.nilHashes {
+		hashes[i] = append([]byte{}, tree.nilHashes[i]...)
+	}
+	return hashes
+}
+
+// VerifyNilHashes reports whether expected, indexed like NilHashes, matches
+// the tree's empty subtree hashes, e.g. the zero hashes of an on-chain
+// verifier.
+func (tree *BASSparseMerkleTree) VerifyNilHashes(expected [][]byte) bool {
+	if len(expected) != len(tree.nilHashes) {
+		return false
+	}
+	for i := range expected {
+		if !bytes.Equal(expected[i], tree.nilHashes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashChildrenAt hashes the children of a node at depth. Two empty leaves
+// yield nilHashes[depth] without calling the hasher: with WithNilLadderSeed
+// that is not their hash, and mapping them keeps an emptied subtree equal to
+// one never set, as Delete and the empty siblings of proofs assume. With
+// WithNilShortCircuit two empty children are mapped the same way at every
+// depth, where it is the same value, as constructNilHashes computed it from
+// exactly these inputs.
+func (tree *BASSparseMerkleTree) hashChildrenAt(depth int, left, right []byte) []byte {
+	leafParent := depth+1 == int(tree.maxDepth)
+	if (tree.nilShortCircuit || leafParent) && depth+1 < len(tree.nilHashes) {
+		empty := tree.nilHashes[depth+1]
+		if bytes.Equal(left, empty) && bytes.Equal(right, empty) {
+			atomic.AddUint64(&tree.metrics.nilShortCircuits, 1)
+			return tree.nilHashes[depth]
+		}
+	}
+	return tree.hashChildren(left, right)
+}
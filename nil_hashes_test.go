@@ -0,0 +1,103 @@
+package bsmt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// naiveRoot hashes the subtree at depth holding the leaves of keys, calling
+// the hasher for every node, empty ones included, down to the leaves.
+func naiveRoot(hasher *Hasher, leaves map[string][]byte, keys [][]byte, depth, maxDepth uint8, empty [][]byte) []byte {
+	if len(keys) == 0 {
+		return empty[depth]
+	}
+	if depth == maxDepth {
+		return leaves[string(keys[0])]
+	}
+	var left, right [][]byte
+	for _, key := range keys {
+		if pathBit(key, depth) {
+			right = append(right, key)
+		} else {
+			left = append(left, key)
+		}
+	}
+	return hasher.Hash(
+		naiveRoot(hasher, leaves, left, depth+1, maxDepth, empty),
+		naiveRoot(hasher, leaves, right, depth+1, maxDepth, empty),
+	)
+}
+
+func TestNilShortCircuitMatchesNaiveHashing(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		maxDepth uint8
+		keys     [][]byte
+		// sparse is set when the tree has empty siblings to short-circuit.
+		sparse bool
+	}{
+		{"populated", 8, func() [][]byte {
+			keys := make([][]byte, 256)
+			for i := range keys {
+				keys[i] = []byte{byte(i)}
+			}
+			return keys
+		}(), false},
+		{"sparse", 64, func() [][]byte {
+			keys := make([][]byte, 50)
+			for i := range keys {
+				keys[i] = testKey(i)
+			}
+			return keys
+		}(), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hasher := NewHasher(sha256.New())
+			empty := make([][]byte, int(tc.maxDepth)+1)
+			empty[tc.maxDepth] = make([]byte, hasher.Size())
+			for d := int(tc.maxDepth) - 1; d >= 0; d-- {
+				empty[d] = hasher.Hash(empty[d+1], empty[d+1])
+			}
+			// Every fifth key is deleted again, leaving empty leaves that the
+			// naive computation hashes like any other.
+			leaves := make(map[string][]byte)
+			for i, key := range tc.keys {
+				leaves[string(key)] = testValue(i)
+				if i%5 == 0 {
+					leaves[string(key)] = empty[tc.maxDepth]
+				}
+			}
+			want := naiveRoot(hasher, leaves, tc.keys, 0, tc.maxDepth, empty)
+
+			for _, opts := range [][]Option{
+				{WithMaxDepth(tc.maxDepth)},
+				{WithMaxDepth(tc.maxDepth), WithNilShortCircuit()},
+			} {
+				tree := newTestTree(t, opts...)
+				for i, key := range tc.keys {
+					if err := tree.Set(key, testValue(i)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				for i, key := range tc.keys {
+					if i%5 != 0 {
+						continue
+					}
+					if err := tree.Delete(key); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := tree.Commit(); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(tree.Root(), want) {
+					t.Fatalf("short circuit %v: root differs from naive hashing", tree.nilShortCircuit)
+				}
+				if tc.sparse && tree.nilShortCircuit && tree.Stats().NilShortCircuits == 0 {
+					t.Fatal("no hash was short-circuited")
+				}
+			}
+		})
+	}
+}
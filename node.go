@@ -0,0 +1,108 @@
+package bsmt
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var _ Node = (*FullTreeNode)(nil)
+
+// Node is a subtree the tree can operate on. FullTreeNode is the default
+// implementation; WithNodeFactory plugs in another layout.
+type Node interface {
+	TreeNode
+	// Root returns the hash of the subtree.
+	Root() []byte
+	// ResidentSize returns the number of resident nodes in the subtree.
+	ResidentSize() uint64
+	// Prune drops the history older than recentVersion.
+	Prune(recentVersion Version)
+	// Rollback drops the history newer than version.
+	Rollback(version Version)
+	MarshalBinary() ([]byte, error)
+}
+
+func (node *FullTreeNode) Root() []byte {
+	return node.LatestHash
+}
+
+func (node *FullTreeNode) ResidentSize() uint64 {
+	return node.Size
+}
+
+func (node *FullTreeNode) Prune(recentVersion Version) {
+	i := 0
+	for i < len(node.Versions)-1 && node.Versions[i+1].Ver <= recentVersion {
+		i++
+	}
+	node.Versions = node.Versions[i:]
+}
+
+func (node *FullTreeNode) Rollback(version Version) {
+	i := len(node.Versions)
+	for i > 0 && node.Versions[i-1].Ver > version {
+		i--
+	}
+	node.Versions = node.Versions[:i]
+	if i > 0 {
+		node.LatestHash = node.Versions[i-1].Hash
+	}
+}
+
+// MarshalBinary encodes the hash and version history of the node.
+func (node *FullTreeNode) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	putBytes(&buf, node.LatestHash)
+	putVersions(&buf, node.Versions)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a node encoded by MarshalBinary.
+func (node *FullTreeNode) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	hash, err := readBytes(r)
+	if err != nil {
+		return ErrInvalidNodeEncoding
+	}
+	versions, err := readVersions(r)
+	if err != nil {
+		return err
+	}
+	node.LatestHash = hash
+	node.Versions = versions
+	return nil
+}
+
+// putVersions encodes a version history as
+// uvarint(count) {uvarint(version) uvarint(len(hash)) hash}.
+func putVersions(buf *bytes.Buffer, versions []*VersionInfo) {
+	putUvarint(buf, uint64(len(versions)))
+	for _, version := range versions {
+		putUvarint(buf, uint64(version.Ver))
+		putBytes(buf, version.Hash)
+	}
+}
+
+// readVersions decodes a version history encoded by putVersions.
+func readVersions(r *bytes.Reader) ([]*VersionInfo, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len()) {
+		return nil, ErrInvalidNodeEncoding
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	versions := make([]*VersionInfo, count)
+	for i := range versions {
+		ver, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrInvalidNodeEncoding
+		}
+		hash, err := readBytes(r)
+		if err != nil {
+			return nil, ErrInvalidNodeEncoding
+		}
+		versions[i] = &VersionInfo{Ver: Version(ver), Hash: hash}
+	}
+	return versions, nil
+}
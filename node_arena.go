@@ -0,0 +1,47 @@
+package bsmt
+
+import "sync"
+
+// nodeArena hands out FullTreeNodes from preallocated chunks, replacing one
+// allocation per node with one per chunk during bulk Sets. Released nodes
+// are kept on a free list of up to a chunk and handed out again first, so
+// a chunk holding a long-lived node does not keep its released neighbours
+// from being reused. The tree only releases nodes it has unlinked, so a
+// node still referenced by a committed root is never handed out twice.
+type nodeArena struct {
+	lock  sync.Mutex
+	size  int
+	chunk []FullTreeNode
+	free  []*FullTreeNode
+}
+
+func (arena *nodeArena) alloc() *FullTreeNode {
+	arena.lock.Lock()
+	defer arena.lock.Unlock()
+	if n := len(arena.free); n > 0 {
+		node := arena.free[n-1]
+		arena.free = arena.free[:n-1]
+		*node = FullTreeNode{}
+		return node
+	}
+	if len(arena.chunk) == 0 {
+		arena.chunk = make([]FullTreeNode, arena.size)
+	}
+	node := &arena.chunk[0]
+	arena.chunk = arena.chunk[1:]
+	return node
+}
+
+// recycle returns a released node for reuse. Beyond a chunk of free nodes
+// it is left to the garbage collector.
+func (arena *nodeArena) recycle(node *FullTreeNode) {
+	if arena == nil {
+		return
+	}
+	arena.lock.Lock()
+	defer arena.lock.Unlock()
+	if len(arena.free) < arena.size {
+		*node = FullTreeNode{}
+		arena.free = append(arena.free, node)
+	}
+}
@@ -0,0 +1,83 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNodePreallocationWithFactory(t *testing.T) {
+	_, err := NewBASSparseMerkleTree(WithNodePreallocation(64), WithNodeFactory(func() Node { return &FullTreeNode{} }))
+	if err != ErrConflictingOptions {
+		t.Fatalf("got %v, want ErrConflictingOptions", err)
+	}
+}
+
+func TestNodePreallocationReusesReleasedNodes(t *testing.T) {
+	want := newTestTree(t)
+	tree := newTestTree(t, WithNodePreallocation(256))
+	for _, tr := range []*BASSparseMerkleTree{want, tree} {
+		commitVersions(t, tr, 32)
+	}
+	for i := 0; i < 32; i++ {
+		if err := tree.Set(testKey(100+i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree.Reset()
+	if len(tree.nodeArena.free) == 0 {
+		t.Fatal("nodes released by Reset are not reused")
+	}
+	if !bytes.Equal(tree.Root(), want.Root()) {
+		t.Fatal("Reset leaves another root")
+	}
+	free := len(tree.nodeArena.free)
+	if err := tree.Set(testKey(100), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.nodeArena.free) >= free {
+		t.Fatal("Set does not take nodes from the free list")
+	}
+	if err := want.Set(testKey(100), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Rollback(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := want.Rollback(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range []*BASSparseMerkleTree{want, tree} {
+		if _, err := tr.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(tree.Root(), want.Root()) || tree.Size() != want.Size() {
+		t.Fatal("reused nodes change the tree")
+	}
+}
+
+func BenchmarkBulkSet(b *testing.B) {
+	kvs := testKVs(100000)
+	for _, bench := range []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"preallocated", []Option{WithNodePreallocation(4096)}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tree := newTestTree(b, bench.opts...)
+				for _, kv := range kvs {
+					if err := tree.Set(kv.Key, kv.Val); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if _, err := tree.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
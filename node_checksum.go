@@ -0,0 +1,134 @@
+package bsmt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptNode reports a stored node whose WithStorageChecksum checksum
+// does not match its bytes, identifying the node to rebuild.
+type ErrCorruptNode struct {
+	Depth uint8
+	Path  []byte
+}
+
+func (e *ErrCorruptNode) Error() string {
+	return fmt.Sprintf("corrupt node at depth %d, path %x", e.Depth, e.Path)
+}
+
+// encodeStoredNode encodes node for the db, followed by the CRC32C of the
+// encoding when WithStorageChecksum is enabled. WithSparseNodeEncoding
+// leaves out the empty children. With WithLatestOnly only the latest version of the node is kept.
+func (tree *BASSparseMerkleTree) encodeStoredNode(node *StorageFullTreeNode) ([]byte, error) {
+	if tree.latestOnly {
+		latest := *node
+		latest.Versions = latestVersion(node.Versions)
+		for i := range latest.Children {
+			latest.Children[i].Versions = latestVersion(node.Children[i].Versions)
+		}
+		node = &latest
+	}
+	var data []byte
+	var err error
+	if tree.sparseNodes {
+		data, err = node.ToSparse().MarshalBinary()
+	} else {
+		data, err = node.MarshalBinary()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tree.storageChecksum {
+		sum := make([]byte, crc32.Size)
+		binary.BigEndian.PutUint32(sum, crc32.Checksum(data, castagnoli))
+		data = append(data, sum...)
+	}
+	return data, nil
+}
+
+func latestVersion(versions []*VersionInfo) []*VersionInfo {
+	if len(versions) > 1 {
+		return versions[len(versions)-1:]
+	}
+	return versions
+}
+
+// decodeStoredNode decodes the node stored under key by encodeStoredNode.
+func (tree *BASSparseMerkleTree) decodeStoredNode(key, data []byte) (*StorageFullTreeNode, error) {
+	if tree.storageChecksum {
+		n := len(data) - crc32.Size
+		if n < 0 || binary.BigEndian.Uint32(data[n:]) != crc32.Checksum(data[:n], castagnoli) {
+			return nil, corruptNode(key)
+		}
+		data = data[:n]
+	}
+	if tree.sparseNodes {
+		sparse := &StorageSparseTreeNode{}
+		if err := sparse.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+		return sparse.ToFull()
+	}
+	node := &StorageFullTreeNode{}
+	if err := node.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// corruptNode splits a key built by storageNodeKey back into depth and path.
+func corruptNode(key []byte) error {
+	e := &ErrCorruptNode{}
+	if rest := key[len(storageNodeKeyPrefix):]; len(rest) > 0 {
+		e.Depth, e.Path = rest[0], append([]byte{}, rest[1:]...)
+	}
+	return e
+}
+
+// encodeStoredNodes encodes nodes with encodeStoredNode on up to
+// WithEncodeWorkers goroutines. The encodings are returned in the order of
+// nodes, so the batch built from them does not depend on scheduling.
+func (tree *BASSparseMerkleTree) encodeStoredNodes(nodes []*StorageFullTreeNode) ([][]byte, error) {
+	encoded := make([][]byte, len(nodes))
+	workers := tree.encodeWorkers
+	if workers > len(nodes) {
+		workers = len(nodes)
+	}
+	if workers <= 1 {
+		for i, node := range nodes {
+			data, err := tree.encodeStoredNode(node)
+			if err != nil {
+				return nil, err
+			}
+			encoded[i] = data
+		}
+		return encoded, nil
+	}
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(nodes); i += workers {
+				data, err := tree.encodeStoredNode(nodes[i])
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				encoded[i] = data
+			}
+		}(w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return encoded, nil
+}
@@ -0,0 +1,54 @@
+package bsmt
+
+// NewEmpty builds a tree that starts at version 0. Unlike
+// NewBASSparseMerkleTree it never resumes: it fails with ErrTreeExists if
+// the db already holds a committed tree, so it cannot clobber one.
+func NewEmpty(opts ...Option) (SparseMerkleTree, error) {
+	smt, err := NewBASSparseMerkleTree(opts...)
+	if err != nil {
+		return nil, err
+	}
+	tree := smt.(*BASSparseMerkleTree)
+	if tree.db == nil {
+		return smt, nil
+	}
+	exists, err := tree.hasStoredTree()
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrTreeExists
+	}
+	return smt, nil
+}
+
+// Open builds a tree from the state committed to the db, failing with
+// ErrDatabaseRequired without a db and with ErrTreeNotFound if the db holds
+// no committed tree.
+func Open(opts ...Option) (SparseMerkleTree, error) {
+	smt, err := NewBASSparseMerkleTree(opts...)
+	if err != nil {
+		return nil, err
+	}
+	tree := smt.(*BASSparseMerkleTree)
+	if tree.db == nil {
+		return nil, ErrDatabaseRequired
+	}
+	exists, err := tree.hasStoredTree()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrTreeNotFound
+	}
+	return smt, nil
+}
+
+// hasStoredTree reports whether a version has been committed to the db.
+func (tree *BASSparseMerkleTree) hasStoredTree() (bool, error) {
+	_, err := tree.dbGet([]byte(latestVersionKeyPrefix))
+	if err == ErrDatabaseNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
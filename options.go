@@ -3,8 +3,300 @@ package bsmt
 // Option is a function that configures SMT.
 type Option func(*BASSparseMerkleTree)
 
+// WithCustomDB stores the tree in db. Use NewFastMemoryDB for an in-memory
+// store.
 func WithCustomDB(db TreeDB) Option {
 	return func(smt *BASSparseMerkleTree) {
 		smt.db = db
 	}
 }
+
+// WithStoreIntegrityKey enables an HMAC over the stored config record so
+// that tampering on untrusted storage is detected when the tree is opened.
+func WithStoreIntegrityKey(key []byte) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.integrityKey = key
+	}
+}
+
+// WithSparseNodeEncoding stores nodes with only their non-empty children.
+// The choice is recorded in the config record under sparseNodeEncodingKey.
+func WithSparseNodeEncoding() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.sparseNodes = true
+	}
+}
+
+// WithEmptyLeafEncoding sets the value used for empty leaves, from which the
+// nil hashes of every level are derived. Using a domain-separated value such
+// as hash(tag) keeps an empty leaf distinguishable from a real value; Set
+// rejects values equal to it so proofs of emptiness stay unambiguous.
+func WithEmptyLeafEncoding(fn func() []byte) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.emptyLeaf = fn()
+	}
+}
+
+// WithNilLadderSeed derives the nil hashes of internal levels from seed
+// instead of the empty leaf, for schemas where an empty leaf has its own
+// canonical encoding but empty subtrees do not hash it. The empty leaf
+// itself, nilHashes[maxDepth], is unchanged, and a node whose two leaves are
+// empty hashes to nilHashes[maxDepth-1] like one never set. Both are part of
+// NilHashes, so VerifyNilHashes checks them together, and the seed is part
+// of the stored config.
+func WithNilLadderSeed(seed []byte) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.nilLadderSeed = seed
+	}
+}
+
+// WithHasher sets the hasher used for leaves and internal nodes. SHA-256 is
+// used by default.
+func WithHasher(hasher *Hasher) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.hasher = hasher
+	}
+}
+
+// WithHashCache memoizes up to size internal node hashes. It is only worth
+// enabling for expensive hashers; it never changes the resulting hashes.
+func WithHashCache(size int) Option {
+	return func(smt *BASSparseMerkleTree) {
+		if size > 0 {
+			smt.hashCache = newHashCache(size)
+		}
+	}
+}
+
+// WithVersionRetention keeps only the last k versions: every Commit moves the
+// recent version to max(0, newVersion-k) and prunes older versions.
+func WithVersionRetention(k uint64) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.versionRetention = k
+	}
+}
+
+// WithClock replaces the wall clock, e.g. with a fake clock in tests.
+func WithClock(clock Clock) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.clock = clock
+	}
+}
+
+// WithMaxDepth sets the depth of the tree, which is also the exact number of
+// siblings in a canonical proof.
+func WithMaxDepth(maxDepth uint8) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.maxDepth = maxDepth
+	}
+}
+
+// WithKeyBloomFilter keeps a persisted Bloom filter of set keys so that Get
+// of a key that was never set returns without reading the db.
+func WithKeyBloomFilter() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.keyFilter = newBloomFilter()
+	}
+}
+
+// WithProofSelfCheck makes GetProof verify every latest-version proof it
+// generates against the current root. It is meant for tests and staging.
+func WithProofSelfCheck() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.proofSelfCheck = true
+	}
+}
+
+// WithMaxInlineValue stores payloads larger than n bytes under the hash of
+// their content instead of inline, keeping records small and deduplicating
+// identical payloads.
+func WithMaxInlineValue(n int) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.maxInlineValue = n
+	}
+}
+
+// WithGCStrategy selects how resident nodes are released, GCByVersion by
+// default.
+func WithGCStrategy(strategy GCStrategy) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.gcStrategy = strategy
+	}
+}
+
+// WithCommitHook registers hook to be told which internal nodes changed in
+// each commit, e.g. to invalidate cached proofs precisely.
+func WithCommitHook(hook CommitHook) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.commitHook = hook
+	}
+}
+
+// WithPathEncoding stores keys under the paths produced by encoding. The id
+// is recorded in the config record under pathEncodingKey so that a tree is
+// always reopened with the same mapping.
+func WithPathEncoding(id string, encoding PathEncoding) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.pathEncodingID = id
+		smt.pathEncoding = encoding
+	}
+}
+
+// WithWAL appends every Set to wal so that uncommitted work can be replayed
+// with RecoverFromWAL after a crash. The log is truncated on Commit, which
+// returns ErrWALNotTruncated if only the truncation failed.
+func WithWAL(wal WAL) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.wal = wal
+	}
+}
+
+// WithRollbackHook registers hook to be told which internal nodes each
+// rollback rewrote, so caches can be invalidated without a full flush.
+func WithRollbackHook(hook CommitHook) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.rollbackHook = hook
+	}
+}
+
+// WithRecoveryCallback calls fn with what was done to an interrupted commit
+// found when the tree is opened or by RecoverIncompleteCommit, e.g. to log
+// it.
+func WithRecoveryCallback(fn RecoveryCallback) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.recoveryCallback = fn
+	}
+}
+
+// WithRootSigner signs every committed root with signer and stores the
+// signature by version for VerifyRootSignature.
+func WithRootSigner(signer RootSigner) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.rootSigner = signer
+	}
+}
+
+// WithEvictAfterProof unloads the nodes GetProof loaded from the db once the
+// proof is returned, so serving proofs of random keys does not grow the
+// resident tree.
+func WithEvictAfterProof() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.evictAfterProof = true
+	}
+}
+
+// WithResidentCap runs GC down to n resident nodes whenever Get or GetProof
+// leaves more resident, so reads of a tree larger than memory do not grow
+// the resident tree without bound. Like GC it only releases nodes loaded to
+// serve reads: staged and committed changes of this tree stay resident.
+func WithResidentCap(n uint64) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.residentCap = n
+	}
+}
+
+// WithNodeFactory replaces FullTreeNode with another Node implementation.
+func WithNodeFactory(newNode func() Node) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.newNode = newNode
+	}
+}
+
+// WithShadowHasher maintains a second root of the same Sets under hasher,
+// readable with ShadowRoot. It doubles the hashing cost and is debug-only.
+func WithShadowHasher(hasher *Hasher) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.shadowHasher = hasher
+	}
+}
+
+// WithNilShortCircuit returns the precomputed empty subtree hash for a node
+// whose children are both empty instead of hashing them, which saves most
+// hash calls in sparse regions of the tree.
+func WithNilShortCircuit() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.nilShortCircuit = true
+	}
+}
+
+// WithStorageChecksum appends a CRC32C to every stored node and verifies it
+// on read, so bit rot surfaces as an ErrCorruptNode naming the node rather
+// than as a decode error or a wrong hash. The choice is recorded in the
+// config record under storageChecksumKey, so reopening a db with another
+// choice fails with ErrConfigMismatch.
+func WithStorageChecksum() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.storageChecksum = true
+	}
+}
+
+// WithEvictionCallback calls fn for every node the tree unloads from memory.
+// fn runs after the tree lock is released and may call back into the tree.
+func WithEvictionCallback(fn EvictionCallback) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.evictionCallback = fn
+	}
+}
+
+// WithLatestOnly keeps only the latest version of every node, for
+// verify-only deployments that need the current root and proofs but no
+// history. Rollback and reads of older versions fail with
+// ErrHistoryDisabled. The choice is recorded in the config record under
+// latestOnlyKey.
+func WithLatestOnly() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.latestOnly = true
+	}
+}
+
+// WithNodePreallocation allocates resident nodes in chunks of n and reuses
+// released ones, cutting allocations and GC pressure of bulk imports. It
+// cannot be combined with WithNodeFactory: the tree fails to open with
+// ErrConflictingOptions.
+func WithNodePreallocation(n int) Option {
+	return func(smt *BASSparseMerkleTree) {
+		if n <= 0 {
+			return
+		}
+		smt.nodeArena = &nodeArena{size: n}
+	}
+}
+
+// WithKeyBoundLeaves makes Set store hasher.Hash(key, val) as the leaf of
+// key instead of val, binding the slot into the leaf so a value cannot be
+// presented under another key. It changes every root, and verifiers must
+// recompute the leaf from the key, as VerifyKeyValueProof does; Get and
+// proofs return the bound leaf. The choice is recorded in the config record
+// under keyBoundLeavesKey.
+func WithKeyBoundLeaves() Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.keyBoundLeaves = true
+	}
+}
+
+// WithDeltaNodeWrites makes Commit write, for a block that is already
+// stored, only the history its changed nodes gained instead of the whole
+// block with the history of all 31 nodes. The deltas are appended to a
+// record of their own and merged into the block on read; after n of them
+// the block is written in full again, as it is by Rollback and Compact. It
+// cuts the bytes written per commit at the cost of an extra read per block.
+// It cannot be combined with WithLatestOnly: the tree fails to open with
+// ErrConflictingOptions. The choice is recorded in the config record under
+// deltaNodeWritesKey.
+func WithDeltaNodeWrites(n int) Option {
+	return func(smt *BASSparseMerkleTree) {
+		if n <= 0 {
+			return
+		}
+		smt.maxNodeDeltas = n
+	}
+}
+
+// WithEncodeWorkers encodes the stored nodes written by Commit and rewritten
+// by Compact on n goroutines instead of one, spreading the encoding cost of
+// large commits. The written batch is the same for every n.
+func WithEncodeWorkers(n int) Option {
+	return func(smt *BASSparseMerkleTree) {
+		smt.encodeWorkers = n
+	}
+}
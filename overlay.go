@@ -0,0 +1,160 @@
+package bsmt
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// TreeOverlay stages Sets and Deletes in memory on top of a tree, e.g. for
+// executing a block speculatively. Untouched keys read through to the tree;
+// the changes reach it only on Flush.
+type TreeOverlay struct {
+	tree *BASSparseMerkleTree
+
+	lock    sync.Mutex
+	entries map[string]overlayEntry
+}
+
+// overlayEntry is the last write to a key, which wins over earlier ones.
+type overlayEntry struct {
+	val     []byte
+	deleted bool
+}
+
+// Overlay returns an empty overlay on top of tree.
+func (tree *BASSparseMerkleTree) Overlay() *TreeOverlay {
+	return &TreeOverlay{tree: tree, entries: make(map[string]overlayEntry)}
+}
+
+func (overlay *TreeOverlay) Set(key, val []byte) error {
+	leaf := overlay.tree.leafOf(key, val)
+	if overlay.tree.emptyLeaf != nil && bytes.Equal(leaf, overlay.tree.emptyLeaf) {
+		return ErrEmptyLeafValue
+	}
+	overlay.lock.Lock()
+	defer overlay.lock.Unlock()
+	overlay.entries[string(key)] = overlayEntry{val: val}
+	return nil
+}
+
+func (overlay *TreeOverlay) Delete(key []byte) error {
+	overlay.lock.Lock()
+	defer overlay.lock.Unlock()
+	overlay.entries[string(key)] = overlayEntry{deleted: true}
+	return nil
+}
+
+// Get returns the leaf of key as the tree would after Flush, nil for a
+// deleted key.
+func (overlay *TreeOverlay) Get(key []byte) ([]byte, error) {
+	overlay.lock.Lock()
+	entry, ok := overlay.entries[string(key)]
+	overlay.lock.Unlock()
+	if !ok {
+		return overlay.tree.Get(key, nil)
+	}
+	if entry.deleted {
+		return nil, nil
+	}
+	return overlay.leaf(key, entry), nil
+}
+
+func (overlay *TreeOverlay) leaf(key []byte, entry overlayEntry) []byte {
+	tree := overlay.tree
+	if entry.deleted {
+		return tree.nilHashes[tree.maxDepth]
+	}
+	return tree.leafOf(key, entry.val)
+}
+
+// Root returns the root the tree would have after Flush. The overlaid
+// leaves are hashed up along the proof paths of their keys in the tree,
+// level by level, so paths that meet are merged rather than hashed twice.
+func (overlay *TreeOverlay) Root() ([]byte, error) {
+	overlay.lock.Lock()
+	defer overlay.lock.Unlock()
+	tree := overlay.tree
+	if len(overlay.entries) == 0 {
+		return tree.Root(), nil
+	}
+	depth := int(tree.maxDepth)
+	type overlayPath struct {
+		path  []byte
+		proof Proof
+	}
+	paths := make([]overlayPath, 0, len(overlay.entries))
+	level := make(map[string][]byte, len(overlay.entries))
+	for key, entry := range overlay.entries {
+		proof, err := tree.GetProof([]byte(key), nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := tree.checkCanonicalProof(proof); err != nil {
+			return nil, err
+		}
+		path := tree.path([]byte(key))
+		paths = append(paths, overlayPath{path: path, proof: proof})
+		level[string(storageNodeKey(uint8(depth), path))] = overlay.leaf([]byte(key), entry)
+	}
+	for d := depth; d > 0; d-- {
+		parents := make(map[string][]byte, len(level))
+		for _, p := range paths {
+			parentKey := string(storageNodeKey(uint8(d-1), p.path))
+			if _, ok := parents[parentKey]; ok {
+				continue
+			}
+			hash := level[string(storageNodeKey(uint8(d), p.path))]
+			bit := p.path[(d-1)/8] & (0x80 >> uint((d-1)%8))
+			siblingPath := append([]byte{}, p.path...)
+			siblingPath[(d-1)/8] ^= 0x80 >> uint((d-1)%8)
+			sibling, ok := level[string(storageNodeKey(uint8(d), siblingPath))]
+			if !ok {
+				sibling = p.proof.MerkleProof[depth-d]
+				if len(sibling) == 0 {
+					sibling = tree.nilHashes[d]
+				}
+			}
+			if bit != 0 {
+				parents[parentKey] = tree.hashChildrenAt(d-1, sibling, hash)
+			} else {
+				parents[parentKey] = tree.hashChildrenAt(d-1, hash, sibling)
+			}
+		}
+		level = parents
+	}
+	return level[string(storageNodeKey(0, nil))], nil
+}
+
+// Flush applies the overlaid changes to the tree in key order and empties
+// the overlay. The tree stages them for its next Commit.
+func (overlay *TreeOverlay) Flush() error {
+	overlay.lock.Lock()
+	defer overlay.lock.Unlock()
+	keys := make([]string, 0, len(overlay.entries))
+	for key := range overlay.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := overlay.entries[key]
+		var err error
+		if entry.deleted {
+			err = overlay.tree.Delete([]byte(key))
+		} else {
+			err = overlay.tree.Set([]byte(key), entry.val)
+		}
+		if err != nil {
+			return err
+		}
+		delete(overlay.entries, key)
+	}
+	return nil
+}
+
+// Discard drops the overlaid changes.
+func (overlay *TreeOverlay) Discard() {
+	overlay.lock.Lock()
+	defer overlay.lock.Unlock()
+	overlay.entries = make(map[string]overlayEntry)
+}
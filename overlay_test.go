@@ -0,0 +1,78 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	want := newTestTree(t)
+	for _, tree := range []*BASSparseMerkleTree{tree, want} {
+		commitVersions(t, tree, 32)
+	}
+	committed := tree.Root()
+
+	overlay := tree.Overlay()
+	// The last write to key 1 wins; key 2 was committed and is deleted.
+	for _, op := range []struct {
+		key int
+		val []byte
+	}{{1, testValue(100)}, {40, testValue(40)}, {1, testValue(101)}, {2, nil}, {41, testValue(41)}} {
+		var err error
+		if op.val == nil {
+			err = overlay.Delete(testKey(op.key))
+		} else {
+			err = overlay.Set(testKey(op.key), op.val)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, kv := range []KV{{Key: testKey(1), Val: testValue(101)}, {Key: testKey(40), Val: testValue(40)}, {Key: testKey(41), Val: testValue(41)}} {
+		if err := want.Set(kv.Key, kv.Val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := want.Delete(testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := overlay.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, want.Root()) {
+		t.Fatal("overlay root differs from the root of the same changes applied to a tree")
+	}
+	if !bytes.Equal(tree.Root(), committed) || tree.PendingCount() != 0 {
+		t.Fatal("the overlay changed the tree before Flush")
+	}
+	for i, wantVal := range map[int][]byte{1: testValue(101), 2: nil, 3: testValue(9), 41: testValue(41)} {
+		val, err := overlay.Get(testKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, wantVal) {
+			t.Fatalf("overlay reads %x for key %d, want %x", val, i, wantVal)
+		}
+	}
+
+	if err := overlay.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.Root(), root) {
+		t.Fatal("the flushed tree has another root than the overlay")
+	}
+	if root, err := overlay.Root(); err != nil || !bytes.Equal(root, tree.Root()) {
+		t.Fatal("a flushed overlay still holds changes")
+	}
+
+	if err := overlay.Set(testKey(50), testValue(50)); err != nil {
+		t.Fatal(err)
+	}
+	overlay.Discard()
+	if root, err := overlay.Root(); err != nil || !bytes.Equal(root, tree.Root()) {
+		t.Fatal("a discarded overlay still holds changes")
+	}
+}
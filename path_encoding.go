@@ -0,0 +1,30 @@
+package bsmt
+
+const pathEncodingKey string = "pathEncoding"
+
+// PathEncoding maps a key to the path it is stored under. It must be
+// deterministic and preserve the key length.
+type PathEncoding func(key []byte) []byte
+
+// BitReversePath reverses the bit order of the key, spreading keys that only
+// differ in their low bits across the whole tree.
+func BitReversePath(key []byte) []byte {
+	path := make([]byte, len(key))
+	for i, b := range key {
+		var r byte
+		for j := 0; j < 8; j++ {
+			r = r<<1 | b&1
+			b >>= 1
+		}
+		path[len(key)-1-i] = r
+	}
+	return path
+}
+
+// path returns the path of key under the configured encoding.
+func (tree *BASSparseMerkleTree) path(key []byte) []byte {
+	if tree.pathEncoding == nil {
+		return key
+	}
+	return tree.pathEncoding(key)
+}
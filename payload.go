@@ -0,0 +1,113 @@
+package bsmt
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+const (
+	payloadKeyPrefix        string = "payload"
+	payloadContentKeyPrefix string = "payloadContent"
+
+	payloadInline    byte = 0
+	payloadReference byte = 1
+)
+
+// SetPayload sets the leaf of key to the hash of payload and stores the
+// payload itself, so proofs only ever cover the hash. Payloads larger than
+// WithMaxInlineValue are stored once under their hash and referenced.
+// Payloads are written in the commit batch, keyed by the key and its leaf,
+// so a rollback brings back the payloads of the version rolled back to. They
+// need a db.
+func (tree *BASSparseMerkleTree) SetPayload(key, payload []byte) error {
+	if tree.db == nil {
+		return ErrDatabaseRequired
+	}
+	leaf := tree.hasher.Hash(payload)
+	if err := tree.Set(key, leaf); err != nil {
+		return err
+	}
+	tree.payloadLock.Lock()
+	defer tree.payloadLock.Unlock()
+	if tree.pendingPayloads == nil {
+		tree.pendingPayloads = make(map[string][]byte)
+	}
+	tree.pendingPayloads[string(key)] = payload
+	return nil
+}
+
+// GetPayload returns the payload stored by SetPayload for the current leaf
+// of key, staged Sets included, or ErrDatabaseNotFound if the leaf was not
+// set by SetPayload.
+func (tree *BASSparseMerkleTree) GetPayload(key []byte) ([]byte, error) {
+	if tree.db == nil {
+		return nil, ErrDatabaseRequired
+	}
+	leaf, err := tree.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if leaf == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	tree.payloadLock.Lock()
+	payload, ok := tree.pendingPayloads[string(key)]
+	tree.payloadLock.Unlock()
+	if ok && bytes.Equal(tree.leafOf(key, tree.hasher.Hash(payload)), leaf) {
+		return payload, nil
+	}
+	record, err := tree.dbGet(payloadKey(key, leaf))
+	if err != nil {
+		return nil, err
+	}
+	switch record[0] {
+	case payloadInline:
+		return record[1:], nil
+	case payloadReference:
+		return tree.dbGet(payloadContentKey(record[1:]))
+	}
+	return nil, ErrInvalidPayload
+}
+
+// writePayloads adds the pending payloads to batch. They are cleared with
+// the journal once the commit succeeded.
+func (tree *BASSparseMerkleTree) writePayloads(batch Batcher) error {
+	tree.payloadLock.Lock()
+	defer tree.payloadLock.Unlock()
+	for key, payload := range tree.pendingPayloads {
+		hash := tree.hasher.Hash(payload)
+		var record []byte
+		if tree.maxInlineValue > 0 && len(payload) > tree.maxInlineValue {
+			if err := batch.Set(payloadContentKey(hash), payload); err != nil {
+				return err
+			}
+			atomic.AddUint64(&tree.metrics.bytesWritten, uint64(len(payload)))
+			record = append([]byte{payloadReference}, hash...)
+		} else {
+			record = append([]byte{payloadInline}, payload...)
+		}
+		if err := batch.Set(payloadKey([]byte(key), tree.leafOf([]byte(key), hash)), record); err != nil {
+			return err
+		}
+		atomic.AddUint64(&tree.metrics.bytesWritten, uint64(len(record)))
+	}
+	return nil
+}
+
+// clearPayloads drops the pending payloads with the rest of the staged
+// state.
+func (tree *BASSparseMerkleTree) clearPayloads() {
+	tree.payloadLock.Lock()
+	defer tree.payloadLock.Unlock()
+	tree.pendingPayloads = nil
+}
+
+// payloadKey is the key of the payload record of key with leaf. Records of
+// earlier leaves are kept, so rollbacks find them again.
+func payloadKey(key, leaf []byte) []byte {
+	return append(append([]byte(payloadKeyPrefix), key...), leaf...)
+}
+
+func payloadContentKey(hash []byte) []byte {
+	return append([]byte(payloadContentKeyPrefix), hash...)
+}
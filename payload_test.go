@@ -0,0 +1,87 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPayloadsDiscardedWithStagedState(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	key := testKey(1)
+	if err := tree.SetPayload(key, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	tree.Reset()
+	if _, err := tree.GetPayload(key); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v after Reset, want ErrDatabaseNotFound", err)
+	}
+
+	if err := tree.SetPayload(key, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.CommitExpectingRoot(tree.nilHashes[0], nil); err != ErrRootMismatch {
+		t.Fatalf("got %v, want ErrRootMismatch", err)
+	}
+	if _, err := tree.GetPayload(key); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v after a root mismatch, want ErrDatabaseNotFound", err)
+	}
+
+	if err := tree.SetPayload(key, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := tree.GetPayload(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, []byte("payload")) {
+		t.Fatal("committed payload reads back differently")
+	}
+}
+
+func TestPayloadWithoutDB(t *testing.T) {
+	tree := newTestTree(t)
+	if err := tree.SetPayload(testKey(1), []byte("payload")); err != ErrDatabaseRequired {
+		t.Fatalf("got %v, want ErrDatabaseRequired", err)
+	}
+	if _, err := tree.GetPayload(testKey(1)); err != ErrDatabaseRequired {
+		t.Fatalf("got %v, want ErrDatabaseRequired", err)
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPayloadsFollowRollback(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithMaxInlineValue(4)}, {WithKeyBoundLeaves()}} {
+		tree := newTestTree(t, append(opts, WithCustomDB(NewFastMemoryDB(0)))...)
+		key := testKey(1)
+		for _, payload := range []string{"first payload", "second payload"} {
+			if err := tree.SetPayload(key, []byte(payload)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tree.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Rollback(1); err != nil {
+			t.Fatal(err)
+		}
+		payload, err := tree.GetPayload(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(payload, []byte("first payload")) {
+			t.Fatalf("got %q after Rollback, want the payload of version 1", payload)
+		}
+		// A plain Set replaces the payload leaf.
+		if err := tree.Set(key, testValue(1)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.GetPayload(key); err != ErrDatabaseNotFound {
+			t.Fatalf("got %v for a leaf not set by SetPayload, want ErrDatabaseNotFound", err)
+		}
+	}
+}
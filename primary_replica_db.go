@@ -0,0 +1,105 @@
+package bsmt
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	_ TreeDB  = (*PrimaryReplicaDB)(nil)
+	_ Flusher = (*PrimaryReplicaDB)(nil)
+)
+
+// PrimaryReplicaDB writes to a primary and reads from a replica that may lag
+// behind it. With read-your-writes enabled, keys written since the last
+// MarkReplicaSynced are read from the primary instead, so the tree always
+// sees its own writes; other keys may return stale replica data.
+type PrimaryReplicaDB struct {
+	primary        TreeDB
+	replica        TreeDB
+	readYourWrites bool
+
+	lock    sync.RWMutex
+	written map[string]struct{}
+}
+
+func NewPrimaryReplicaDB(primary, replica TreeDB, readYourWrites bool) *PrimaryReplicaDB {
+	return &PrimaryReplicaDB{
+		primary:        primary,
+		replica:        replica,
+		readYourWrites: readYourWrites,
+		written:        make(map[string]struct{}),
+	}
+}
+
+// MarkReplicaSynced tells the db that the replica has caught up with every
+// write so far, so those keys are read from the replica again.
+func (db *PrimaryReplicaDB) MarkReplicaSynced() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.written = make(map[string]struct{})
+}
+
+func (db *PrimaryReplicaDB) recordWrite(key []byte) {
+	if !db.readYourWrites {
+		return
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.written[string(key)] = struct{}{}
+}
+
+func (db *PrimaryReplicaDB) reader(key []byte) TreeDB {
+	if !db.readYourWrites {
+		return db.replica
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if _, ok := db.written[string(key)]; ok {
+		return db.primary
+	}
+	return db.replica
+}
+
+func (db *PrimaryReplicaDB) Get(key []byte) ([]byte, error) { return db.reader(key).Get(key) }
+func (db *PrimaryReplicaDB) Has(key []byte) (bool, error)   { return db.reader(key).Has(key) }
+
+func (db *PrimaryReplicaDB) Set(key []byte, value []byte) error {
+	db.recordWrite(key)
+	return db.primary.Set(key, value)
+}
+
+func (db *PrimaryReplicaDB) Delete(key []byte) error {
+	db.recordWrite(key)
+	return db.primary.Delete(key)
+}
+
+func (db *PrimaryReplicaDB) Ping(ctx context.Context) error {
+	if err := db.primary.Ping(ctx); err != nil {
+		return err
+	}
+	return db.replica.Ping(ctx)
+}
+
+// Flush flushes the primary, the only store written to.
+func (db *PrimaryReplicaDB) Flush() error { return flushDB(db.primary) }
+
+func (db *PrimaryReplicaDB) NewBatch() Batcher {
+	return &primaryBatch{Batcher: db.primary.NewBatch(), db: db}
+}
+
+// primaryBatch records the keys it touches for read-your-writes.
+type primaryBatch struct {
+	Batcher
+	db *PrimaryReplicaDB
+}
+
+func (b *primaryBatch) Set(key []byte, value []byte) error {
+	b.db.recordWrite(key)
+	return b.Batcher.Set(key, value)
+}
+
+func (b *primaryBatch) Delete(key []byte) error {
+	b.db.recordWrite(key)
+	return b.Batcher.Delete(key)
+}
@@ -0,0 +1,57 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrimaryReplicaDBFailingReplica(t *testing.T) {
+	primary, replica := NewFastMemoryDB(0), newFailingDB(0)
+	db := NewPrimaryReplicaDB(primary, replica, true)
+	if err := db.Set([]byte("written"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.FastMemoryDB.Get([]byte("written")); err != ErrDatabaseNotFound {
+		t.Fatal("write reached the replica")
+	}
+
+	// Keys written since the last sync are read from the primary, so a
+	// failing replica only affects the others.
+	replica.failures = 2
+	val, err := db.Get([]byte("written"))
+	if err != nil || !bytes.Equal(val, []byte("value")) {
+		t.Fatalf("got %q, %v for a key written through the db", val, err)
+	}
+	if _, err := db.Get([]byte("other")); err != errBackend {
+		t.Fatalf("got %v, want the replica error", err)
+	}
+
+	db.MarkReplicaSynced()
+	if _, err := db.Get([]byte("written")); err != errBackend {
+		t.Fatalf("got %v, want a synced key read from the replica", err)
+	}
+}
+
+func TestPrimaryReplicaDBFailingPrimary(t *testing.T) {
+	primary, replica := newFailingDB(0), NewFastMemoryDB(0)
+	db := NewPrimaryReplicaDB(primary, replica, false)
+	if err := replica.Set([]byte("key"), []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	primary.failures = 2
+	if err := db.Set([]byte("key"), []byte("value")); err != errBackend {
+		t.Fatalf("got %v, want the primary error", err)
+	}
+	batch := db.NewBatch()
+	if err := batch.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != errBackend {
+		t.Fatalf("got %v, want the primary error", err)
+	}
+	// Without read-your-writes every read is served by the replica.
+	val, err := db.Get([]byte("key"))
+	if err != nil || !bytes.Equal(val, []byte("stale")) {
+		t.Fatalf("got %q, %v, want the replica value", val, err)
+	}
+}
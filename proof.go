@@ -1,6 +1,161 @@
 package bsmt
 
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+// Proof is a self-contained inclusion proof: it states that Leaf is stored
+// under Key in the tree with root Root at Version.
 type Proof struct {
+	Key         []byte
+	Version     Version
+	Root        []byte
+	Leaf        []byte
 	MerkleProof [][]byte
 	ProofHelper []int
 }
+
+// ProofStep annotates one level of a proof for debugging.
+type ProofStep struct {
+	Depth      int
+	NilSibling bool
+	// Hash is the intermediate hash computed at this level.
+	Hash []byte
+}
+
+// jsonProof is the interop representation of Proof with hex-encoded hashes.
+type jsonProof struct {
+	Key      string   `json:"key"`
+	Version  uint64   `json:"version"`
+	Root     string   `json:"root"`
+	Leaf     string   `json:"leaf"`
+	Siblings []string `json:"siblings"`
+	Helper   []int    `json:"helper"`
+}
+
+func encodeHex(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+func decodeHex(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return nil, ErrInvalidHexString
+	}
+	return hex.DecodeString(s[2:])
+}
+
+// MarshalJSON encodes the proof as
+// {"key":"0x..","version":N,"root":"0x..","leaf":"0x..","siblings":["0x..",...],"helper":[...]}.
+func (proof Proof) MarshalJSON() ([]byte, error) {
+	jp := jsonProof{
+		Key:      encodeHex(proof.Key),
+		Version:  uint64(proof.Version),
+		Root:     encodeHex(proof.Root),
+		Leaf:     encodeHex(proof.Leaf),
+		Siblings: make([]string, len(proof.MerkleProof)),
+		Helper:   proof.ProofHelper,
+	}
+	for i := range proof.MerkleProof {
+		jp.Siblings[i] = encodeHex(proof.MerkleProof[i])
+	}
+	if jp.Helper == nil {
+		jp.Helper = []int{}
+	}
+	return json.Marshal(jp)
+}
+
+// UnmarshalJSON decodes a proof produced by MarshalJSON.
+func (proof *Proof) UnmarshalJSON(data []byte) error {
+	var jp jsonProof
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+	siblings := make([][]byte, len(jp.Siblings))
+	for i := range jp.Siblings {
+		sibling, err := decodeHex(jp.Siblings[i])
+		if err != nil {
+			return err
+		}
+		siblings[i] = sibling
+	}
+	var err error
+	if proof.Key, err = decodeHex(jp.Key); err != nil {
+		return err
+	}
+	if proof.Root, err = decodeHex(jp.Root); err != nil {
+		return err
+	}
+	if proof.Leaf, err = decodeHex(jp.Leaf); err != nil {
+		return err
+	}
+	proof.Version = Version(jp.Version)
+	proof.MerkleProof = siblings
+	proof.ProofHelper = jp.Helper
+	return nil
+}
+
+// canonicalProofFormat is the first byte of CanonicalBytes.
+const canonicalProofFormat byte = 1
+
+// CanonicalBytes returns the frozen serialization of the proof used for
+// signing. Unlike the JSON encoding it never changes. All integers are
+// big-endian:
+//
+//	format   uint8  (1)
+//	key      uint16 length, bytes
+//	version  uint64
+//	root     uint16 length, bytes
+//	leaf     uint16 length, bytes
+//	levels   uint16 count, then per level from the leaf up:
+//	         helper uint8, sibling uint16 length, bytes
+//
+// An empty sibling, standing for an empty subtree, has length 0. A proof
+// that the layout cannot represent exactly, i.e. whose helpers are not one
+// 0 or 1 per sibling or whose lengths overflow their fields, fails with
+// ErrNonCanonicalProof, so no two proofs share an encoding.
+func (proof Proof) CanonicalBytes() ([]byte, error) {
+	if len(proof.ProofHelper) != len(proof.MerkleProof) || len(proof.MerkleProof) > math.MaxUint16 {
+		return nil, ErrNonCanonicalProof
+	}
+	var buf bytes.Buffer
+	var tmp [8]byte
+	writeBytes := func(b []byte) error {
+		if len(b) > math.MaxUint16 {
+			return ErrNonCanonicalProof
+		}
+		binary.BigEndian.PutUint16(tmp[:2], uint16(len(b)))
+		buf.Write(tmp[:2])
+		buf.Write(b)
+		return nil
+	}
+	buf.WriteByte(canonicalProofFormat)
+	if err := writeBytes(proof.Key); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint64(tmp[:], uint64(proof.Version))
+	buf.Write(tmp[:])
+	if err := writeBytes(proof.Root); err != nil {
+		return nil, err
+	}
+	if err := writeBytes(proof.Leaf); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(tmp[:2], uint16(len(proof.MerkleProof)))
+	buf.Write(tmp[:2])
+	for i, sibling := range proof.MerkleProof {
+		helper := proof.ProofHelper[i]
+		if helper != 0 && helper != 1 {
+			return nil, ErrNonCanonicalProof
+		}
+		buf.WriteByte(byte(helper))
+		if err := writeBytes(sibling); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,51 @@
+package bsmt
+
+import (
+	"bytes"
+	"hash"
+	"sync"
+)
+
+// ProofServer serves proofs of a pinned TreeSnapshot from many goroutines.
+// Proofs are read from storage only and every verification takes a Hasher
+// of its own from a pool, so callers share no mutable state.
+type ProofServer struct {
+	snapshot *TreeSnapshot
+	hashers  sync.Pool
+}
+
+// NewProofServer serves snapshot. newHash must build the hash function of
+// the tree; verifiers hash with their own Hasher rather than contending for
+// the lock of the tree's.
+func NewProofServer(snapshot *TreeSnapshot, newHash func() hash.Hash) *ProofServer {
+	tree := snapshot.tree
+	server := &ProofServer{snapshot: snapshot}
+	server.hashers.New = func() interface{} {
+		return NewHasherWithOutputLen(newHash(), tree.hasher.Size()).Named(tree.hasher.ID())
+	}
+	return server
+}
+
+func (server *ProofServer) Snapshot() *TreeSnapshot {
+	return server.snapshot
+}
+
+// GetProof returns the proof of key at the snapshot version.
+func (server *ProofServer) GetProof(key []byte) (Proof, error) {
+	return server.snapshot.GetProof(key)
+}
+
+// VerifyProof verifies proof against the snapshot root.
+func (server *ProofServer) VerifyProof(proof Proof) bool {
+	hasher := server.hashers.Get().(*Hasher)
+	defer server.hashers.Put(hasher)
+	tree := server.snapshot.tree
+	verifier := &BASSparseMerkleTree{
+		maxDepth:     tree.maxDepth,
+		hasher:       hasher,
+		nilHashes:    tree.nilHashes,
+		pathEncoding: tree.pathEncoding,
+		metrics:      &metrics{},
+	}
+	return bytes.Equal(proof.Root, server.snapshot.root) && verifier.VerifyProof(proof)
+}
@@ -0,0 +1,526 @@
+package bsmt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// subtreeRoot returns the working hash of the subtree at depth on path.
+func subtreeRoot(tree *BASSparseMerkleTree, path []byte, depth uint8) []byte {
+	tree.Root()
+	node := tree.rootNode()
+	for d := uint8(0); d < depth && node != nil; d++ {
+		if pathBit(path, d) {
+			node = fullNode(node.RightChild)
+		} else {
+			node = fullNode(node.LeftChild)
+		}
+	}
+	return tree.childHash(node, depth)
+}
+
+func TestVerifySubtreeProof(t *testing.T) {
+	tree := newTestTree(t)
+	for i := 0; i < 64; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := testKey(7)
+	proof, err := tree.GetProof(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bits := range []int{0, 4, 9, 32, 64} {
+		root := subtreeRoot(tree, key, uint8(bits))
+		if !tree.VerifySubtreeProof(key, bits, key, proof, root) {
+			t.Fatalf("%d-bit prefix: proof does not verify against its subtree root", bits)
+		}
+		if bits > 0 && tree.VerifySubtreeProof(key, bits, key, proof, tree.nilHashes[bits]) {
+			t.Fatalf("%d-bit prefix: proof verifies against a wrong subtree root", bits)
+		}
+	}
+	if !tree.VerifySubtreeProof(key, 0, key, proof, tree.Root()) {
+		t.Fatal("a 0-bit prefix does not verify against the tree root")
+	}
+
+	other := append([]byte{}, key...)
+	other[0] ^= 0x08
+	if tree.VerifySubtreeProof(other, 8, key, proof, subtreeRoot(tree, key, 8)) {
+		t.Fatal("proof verifies under a prefix its key does not start with")
+	}
+	if tree.VerifySubtreeProof(key, 65, key, proof, proof.Leaf) {
+		t.Fatal("prefix deeper than the tree accepted")
+	}
+	truncated := proof
+	truncated.MerkleProof = proof.MerkleProof[:32]
+	if tree.VerifySubtreeProof(key, 32, key, truncated, subtreeRoot(tree, key, 32)) {
+		t.Fatal("non-canonical proof accepted")
+	}
+	if bytes.Equal(subtreeRoot(tree, key, 4), tree.Root()) {
+		t.Fatal("test tree is degenerate")
+	}
+}
+
+// TestCanonicalBytesVectors pins the canonical encoding, for other
+// implementations to check theirs against.
+func TestCanonicalBytesVectors(t *testing.T) {
+	for _, tc := range []struct {
+		proof Proof
+		want  string
+	}{
+		{
+			proof: Proof{},
+			want:  "01" + "0000" + "0000000000000000" + "0000" + "0000" + "0000",
+		},
+		{
+			proof: Proof{
+				Key:         []byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Version:     3,
+				Root:        []byte{0xaa, 0xaa},
+				Leaf:        []byte{0xbb, 0xbb},
+				MerkleProof: [][]byte{{}, {0xcc, 0xcc}},
+				ProofHelper: []int{1, 0},
+			},
+			want: "01" + "0008" + "0102030405060708" + "0000000000000003" + "0002aaaa" + "0002bbbb" +
+				"0002" + "01" + "0000" + "00" + "0002cccc",
+		},
+	} {
+		got, err := tc.proof.CanonicalBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tc.want {
+			t.Fatalf("got %x, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestCanonicalBytesRejectsNonCanonicalProofs(t *testing.T) {
+	valid := Proof{
+		Key:         []byte{1},
+		MerkleProof: [][]byte{{}, {0xcc}},
+		ProofHelper: []int{1, 0},
+	}
+	for name, mutate := range map[string]func(*Proof){
+		"missing helper":  func(p *Proof) { p.ProofHelper = p.ProofHelper[:1] },
+		"extra helper":    func(p *Proof) { p.ProofHelper = append(p.ProofHelper, 0) },
+		"helper above 1":  func(p *Proof) { p.ProofHelper = []int{2, 0} },
+		"negative helper": func(p *Proof) { p.ProofHelper = []int{-1, 0} },
+		"long key":        func(p *Proof) { p.Key = make([]byte, 1<<16) },
+		"long sibling":    func(p *Proof) { p.MerkleProof = [][]byte{{}, make([]byte, 1<<16)} },
+		"too many levels": func(p *Proof) {
+			p.MerkleProof, p.ProofHelper = make([][]byte, 1<<16), make([]int, 1<<16)
+		},
+	} {
+		proof := valid
+		proof.MerkleProof = append([][]byte{}, valid.MerkleProof...)
+		proof.ProofHelper = append([]int{}, valid.ProofHelper...)
+		mutate(&proof)
+		if _, err := proof.CanonicalBytes(); err != ErrNonCanonicalProof {
+			t.Fatalf("%s: got %v, want ErrNonCanonicalProof", name, err)
+		}
+	}
+	if _, err := valid.CanonicalBytes(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestProofHelpersDepth8 checks, for every key of a depth-8 tree, that
+// GetProof and the verifier derive the same helper bits: entry i is bit i
+// of the one-byte key, counted from the least significant.
+func TestProofHelpersDepth8(t *testing.T) {
+	tree := newTestTree(t, WithMaxDepth(8))
+	for k := 0; k < 256; k += 3 {
+		if err := tree.Set([]byte{byte(k)}, testValue(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 256; k++ {
+		key := []byte{byte(k)}
+		proof, err := tree.GetProof(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		helpers, ok := proofHelpers(key, 8)
+		if !ok {
+			t.Fatalf("key %d: no helpers derived", k)
+		}
+		for i := range helpers {
+			if want := k >> uint(i) & 1; helpers[i] != want || proof.ProofHelper[i] != want {
+				t.Fatalf("key %d level %d: derived %d, proof has %d, want %d", k, i, helpers[i], proof.ProofHelper[i], want)
+			}
+		}
+		if !tree.proofMatchesKey(key, proof) || !tree.VerifyProof(proof) {
+			t.Fatalf("key %d: proof does not verify", k)
+		}
+	}
+}
+
+func TestCheckProofRejectsMalformedProofs(t *testing.T) {
+	tree := newTestTree(t)
+	for i := 0; i < 16; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := tree.GetProof(testKey(3), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CheckProof(valid); err != nil {
+		t.Fatal(err)
+	}
+	// The proof of a sparse tree has empty siblings near the leaf.
+	empty := -1
+	for i, sibling := range valid.MerkleProof {
+		if len(sibling) == 0 {
+			empty = i
+			break
+		}
+	}
+	if empty < 0 {
+		t.Fatal("proof has no empty sibling")
+	}
+	depth := len(valid.MerkleProof) - empty
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(*Proof)
+		want   error
+	}{
+		{"padded", func(p *Proof) {
+			p.MerkleProof = append(p.MerkleProof, []byte{})
+			p.ProofHelper = append(p.ProofHelper, 0)
+		}, ErrNonCanonicalProof},
+		{"truncated", func(p *Proof) {
+			p.MerkleProof, p.ProofHelper = p.MerkleProof[1:], p.ProofHelper[1:]
+		}, ErrNonCanonicalProof},
+		{"missing helper", func(p *Proof) { p.ProofHelper = p.ProofHelper[1:] }, ErrNonCanonicalProof},
+		{"helper above 1", func(p *Proof) { p.ProofHelper[0] = 2 }, ErrNonCanonicalProof},
+		{"short sibling", func(p *Proof) { p.MerkleProof[empty] = []byte{1} }, ErrMalformedProof},
+		{"spelled-out nil sibling", func(p *Proof) {
+			p.MerkleProof[empty] = tree.nilHashes[depth]
+		}, ErrNonCanonicalProof},
+		{"flipped helper", func(p *Proof) { p.ProofHelper[0] ^= 1 }, ErrProofMismatch},
+		{"other leaf", func(p *Proof) { p.Leaf = testValue(100) }, ErrProofMismatch},
+	} {
+		proof := valid
+		proof.MerkleProof = append([][]byte{}, valid.MerkleProof...)
+		proof.ProofHelper = append([]int{}, valid.ProofHelper...)
+		tc.mutate(&proof)
+		if err := tree.CheckProof(proof); err != tc.want {
+			t.Fatalf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// In the witness encoding an empty sibling is a bitmap bit only.
+	var buf bytes.Buffer
+	putBytes(&buf, valid.Key)
+	putBytes(&buf, valid.Leaf)
+	putUvarint(&buf, uint64(valid.Version))
+	putUvarint(&buf, 1)
+	buf.WriteByte(0)
+	putBytes(&buf, nil)
+	putUvarint(&buf, 1)
+	putUvarint(&buf, 0)
+	if _, err := DecodeWitness(buf.Bytes()); err != ErrInvalidWitness {
+		t.Fatalf("got %v for an empty sibling outside the bitmap, want ErrInvalidWitness", err)
+	}
+	spelled := valid
+	spelled.MerkleProof = append([][]byte{}, valid.MerkleProof...)
+	spelled.MerkleProof[empty] = tree.nilHashes[depth]
+	w := &Witness{Key: valid.Key, Val: valid.Leaf, Version: valid.Version, Proof: spelled}
+	if _, _, ok := tree.VerifyWitness(w.Encode(), valid.Root); ok {
+		t.Fatal("witness with a spelled-out nil sibling verifies")
+	}
+}
+
+func TestSetRejectsLeavesProofsCannotCarry(t *testing.T) {
+	tree := newTestTree(t, WithProofSelfCheck())
+	key := testKey(1)
+	neighbour := append([]byte{}, key...)
+	neighbour[len(neighbour)-1] ^= 1
+	if err := tree.Set(key, []byte{1}); err != ErrInvalidLeafLength {
+		t.Fatalf("got %v, want ErrInvalidLeafLength", err)
+	}
+	if err := tree.Set(key, testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tree.GetProof(neighbour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CheckProof(proof); err != nil {
+		t.Fatalf("proof of the neighbour of a set key: %v", err)
+	}
+
+	// A key-bound leaf is a digest whatever the length of the value.
+	bound := newTestTree(t, WithKeyBoundLeaves(), WithProofSelfCheck())
+	if err := bound.Set(key, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bound.GetProof(neighbour, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyProofs(t *testing.T) {
+	tree := newTestTree(t)
+	var keys [][]byte
+	var proofs []Proof
+	for i := 0; i < 8; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		proof, err := tree.GetProof(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, proofs = append(keys, testKey(i)), append(proofs, proof)
+	}
+	if ok, i := tree.VerifyProofs(keys, proofs); !ok || i != -1 {
+		t.Fatalf("got %v, %d for valid proofs", ok, i)
+	}
+	// A valid proof paired with another key fails at its index.
+	swapped := append([][]byte{}, keys...)
+	swapped[3], swapped[4] = keys[4], keys[3]
+	if ok, i := tree.VerifyProofs(swapped, proofs); ok || i != 3 {
+		t.Fatalf("got %v, %d for swapped keys, want a failure at 3", ok, i)
+	}
+	stale := append([]Proof{}, proofs...)
+	if err := tree.Set(testKey(5), testValue(50)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, i := tree.VerifyProofs(keys, stale); ok || i != 0 {
+		t.Fatalf("got %v, %d for proofs of an older root, want a failure at 0", ok, i)
+	}
+	if ok, i := tree.VerifyProofs(keys[:2], proofs); ok || i != 0 {
+		t.Fatalf("got %v, %d for fewer keys than proofs", ok, i)
+	}
+}
+
+func TestProofJSONRoundTrip(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	for i := 0; i < 16; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// A set key and an absent one, whose proof carries empty siblings.
+	for _, key := range [][]byte{testKey(3), testKey(1000)} {
+		proof, err := tree.GetProof(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"key", "version", "root", "leaf", "siblings", "helper"} {
+			if _, ok := fields[name]; !ok {
+				t.Fatalf("JSON proof has no %q field: %s", name, data)
+			}
+		}
+		var decoded Proof
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !tree.VerifyProof(decoded) {
+			t.Fatalf("decoded proof of %x does not verify", key)
+		}
+		want, err := proof.CanonicalBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decoded.CanonicalBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("proof of %x changed in the JSON round trip", key)
+		}
+	}
+	var proof Proof
+	if err := json.Unmarshal([]byte(`{"key":"00","root":"0x","leaf":"0x","siblings":[]}`), &proof); err != ErrInvalidHexString {
+		t.Fatalf("got %v for a hash without 0x, want ErrInvalidHexString", err)
+	}
+}
+
+// TestEmptyFlagProofs compares proofs read through the Empty flags of the
+// working tree with proofs read at the committed version, which compare
+// each hash with the nil hash instead, on subtrees emptied by deletions.
+func TestEmptyFlagProofs(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	for i := 0; i < 64; i++ {
+		if err := tree.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 64; i += 2 {
+		if err := tree.Delete(testKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version, err := tree.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened := newTestTree(t, WithCustomDB(db))
+	for i := 0; i < 80; i++ {
+		want, err := tree.GetProof(testKey(i), &version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tr := range []*BASSparseMerkleTree{tree, reopened} {
+			got, err := tr.GetProof(testKey(i), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.MerkleProof) != len(want.MerkleProof) {
+				t.Fatalf("key %d: %d siblings, want %d", i, len(got.MerkleProof), len(want.MerkleProof))
+			}
+			for d := range want.MerkleProof {
+				if !bytes.Equal(got.MerkleProof[d], want.MerkleProof[d]) {
+					t.Fatalf("key %d: sibling %d differs from the committed proof", i, d)
+				}
+			}
+			if !bytes.Equal(got.Root, want.Root) || !bytes.Equal(got.Leaf, want.Leaf) {
+				t.Fatalf("key %d: proof differs from the committed proof", i)
+			}
+		}
+	}
+}
+
+func TestStreamProofMatchesGetProof(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	commitVersions(t, tree, 32)
+	if err := tree.Set(testKey(40), testValue(40)); err != nil {
+		t.Fatal(err)
+	}
+	// Keys 0 and 40 are set, 50 never was.
+	for _, i := range []int{0, 40, 50} {
+		proof, err := tree.GetProof(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		levels := 0
+		if err := tree.StreamProof(testKey(i), func(level int, sibling []byte, isNil bool) error {
+			if level != levels {
+				t.Fatalf("key %d: got level %d, want %d", i, level, levels)
+			}
+			want := proof.MerkleProof[level]
+			if !bytes.Equal(sibling, want) {
+				t.Fatalf("key %d: streamed sibling %d differs from GetProof", i, level)
+			}
+			depth := len(proof.MerkleProof) - level
+			if wantNil := len(want) == 0 || bytes.Equal(want, tree.nilHashes[depth]); isNil != wantNil {
+				t.Fatalf("key %d: sibling %d has isNil %v, want %v", i, level, isNil, wantNil)
+			}
+			levels++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if levels != len(proof.MerkleProof) {
+			t.Fatalf("key %d: streamed %d siblings, want %d", i, levels, len(proof.MerkleProof))
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := tree.StreamProof(testKey(0), func(level int, sibling []byte, isNil bool) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("got %v after %d calls, want the error of the first call", err, calls)
+	}
+}
+
+func TestVerifyForeignProof(t *testing.T) {
+	tree := newTestTree(t)
+	commitVersions(t, tree, 32)
+	key := testKey(5)
+	proof, err := tree.GetProof(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depth := len(proof.MerkleProof)
+	var explicit, rootFirst, packed [][]byte
+	bitmap := make([]byte, depth/8)
+	for i, sibling := range proof.MerkleProof {
+		rootFirst = append([][]byte{sibling}, rootFirst...)
+		if len(sibling) == 0 {
+			explicit = append(explicit, tree.nilHashes[depth-i])
+			bitmap[i/8] |= 1 << uint(i%8)
+			continue
+		}
+		explicit = append(explicit, sibling)
+		packed = append(packed, sibling)
+	}
+	lsbKey := make([]byte, len(key))
+	for i, b := range key {
+		for bit := uint(0); bit < 8; bit++ {
+			lsbKey[i] |= (b >> bit & 1) << (7 - bit)
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		key      []byte
+		siblings [][]byte
+		opts     ForeignProofOptions
+	}{
+		{"native", key, proof.MerkleProof, ForeignProofOptions{}},
+		{"explicit nil siblings", key, explicit, ForeignProofOptions{}},
+		{"root first", key, rootFirst, ForeignProofOptions{RootFirst: true}},
+		{"nil bitmap", key, packed, ForeignProofOptions{NilBitmap: bitmap}},
+		{"lsb first", lsbKey, proof.MerkleProof, ForeignProofOptions{LSBFirst: true}},
+	} {
+		if !tree.VerifyForeignProof(tc.key, proof.Leaf, tc.siblings, proof.Root, tc.opts) {
+			t.Fatalf("%s: a valid proof does not verify", tc.name)
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		key      []byte
+		leaf     []byte
+		siblings [][]byte
+		opts     ForeignProofOptions
+	}{
+		{"wrong leaf", key, testValue(6), proof.MerkleProof, ForeignProofOptions{}},
+		{"wrong order", key, proof.Leaf, rootFirst, ForeignProofOptions{}},
+		{"missing sibling", key, proof.Leaf, proof.MerkleProof[1:], ForeignProofOptions{}},
+		{"sibling not in bitmap", key, proof.Leaf, append(packed, packed[0]), ForeignProofOptions{NilBitmap: bitmap}},
+		{"short bitmap", key, proof.Leaf, packed, ForeignProofOptions{NilBitmap: bitmap[:1]}},
+		{"msb key read lsb first", key, proof.Leaf, proof.MerkleProof, ForeignProofOptions{LSBFirst: true}},
+	} {
+		if tree.VerifyForeignProof(tc.key, tc.leaf, tc.siblings, proof.Root, tc.opts) {
+			t.Fatalf("%s: an invalid proof verifies", tc.name)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package bsmt
+
+import "bytes"
+
+// CommitRecovery describes how an interrupted commit was repaired.
+type CommitRecovery struct {
+	// Version is the version the interrupted commit was writing.
+	Version Version
+	// RolledForward is set when every block of the commit had reached the
+	// db, so the commit was completed instead of discarded.
+	RolledForward bool
+	// DroppedBlocks is the number of stored blocks rewritten without the
+	// history of the discarded commit, or deleted if none was left.
+	DroppedBlocks int
+}
+
+// RecoveryCallback is told how an interrupted commit was repaired.
+type RecoveryCallback func(recovery CommitRecovery)
+
+// encodeCommitMarker encodes the version a commit is writing and the recent
+// version it prunes the written blocks to.
+func encodeCommitMarker(version, recentVersion Version) []byte {
+	return append(encodeVersion(uint64(version)), encodeVersion(uint64(recentVersion))...)
+}
+
+func decodeCommitMarker(data []byte) (Version, Version, error) {
+	if len(data) != 16 {
+		return 0, 0, ErrInvalidVersionRecord
+	}
+	version, _ := decodeVersion(data[:8])
+	recentVersion, _ := decodeVersion(data[8:])
+	if version == 0 || recentVersion > version {
+		return 0, 0, ErrInvalidVersionRecord
+	}
+	return version, recentVersion, nil
+}
+
+// RecoverIncompleteCommit repairs the db after a commit that was interrupted
+// before it finished, e.g. by a crash or a failed batch write. A commit whose
+// blocks all reached the db is rolled forward, otherwise the history it
+// wrote is dropped, and the tree is reloaded from the db, discarding staged
+// changes other than those saved by Flush. It returns the version of the
+// interrupted commit, or 0 if the previous commit completed. Opening a tree
+// recovers it already, so this is only needed after a failed Commit of a
+// tree that stays open.
+func (tree *BASSparseMerkleTree) RecoverIncompleteCommit() (Version, error) {
+	if tree.db == nil {
+		return 0, nil
+	}
+	tree.lock.Lock()
+	recovery, err := tree.recoverIncompleteCommit()
+	if err == nil && recovery != nil {
+		tree.discardStaged()
+		if err = tree.loadLatest(); err == nil {
+			err = tree.loadFlushedState()
+		}
+	}
+	tree.lock.Unlock()
+	if err != nil || recovery == nil {
+		return 0, err
+	}
+	if tree.recoveryCallback != nil {
+		tree.recoveryCallback(*recovery)
+	}
+	return recovery.Version, nil
+}
+
+// recoverIncompleteCommit repairs the commit whose marker is left in the db
+// and returns what it did, or nil if there is no marker. The commit marker
+// is only removed by the batch that completes a commit.
+func (tree *BASSparseMerkleTree) recoverIncompleteCommit() (*CommitRecovery, error) {
+	data, err := tree.dbGet([]byte(commitInProgressKey))
+	if err == ErrDatabaseNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	version, recentVersion, err := tree.markedCommit(data)
+	if err != nil {
+		return nil, err
+	}
+	complete, err := tree.commitComplete(version)
+	if err != nil {
+		return nil, err
+	}
+	recovery := &CommitRecovery{Version: version, RolledForward: complete}
+	batch := tree.db.NewBatch()
+	latest := version
+	if !complete {
+		latest = version - 1
+		if recovery.DroppedBlocks, err = tree.dropHistoryAfter(batch, latest); err != nil {
+			return nil, err
+		}
+		if err := batch.Delete(rootSignatureKey(version)); err != nil {
+			return nil, err
+		}
+		kept, trimmed, err := tree.trimAnnotations(batch, recentVersion, version)
+		if err != nil {
+			return nil, err
+		}
+		if trimmed {
+			if err := tree.writeAnnotationsIndex(batch, kept); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tree.writeRecoveredVersions(batch, latest, recentVersion); err != nil {
+		return nil, err
+	}
+	if err := batch.Delete([]byte(commitInProgressKey)); err != nil {
+		return nil, err
+	}
+	return recovery, batch.Write()
+}
+
+// markedCommit decodes the commit marker. The recent version is raised to
+// the stored one if that is higher: blocks the commit did not reach were
+// pruned to it.
+func (tree *BASSparseMerkleTree) markedCommit(marker []byte) (Version, Version, error) {
+	version, recentVersion, err := decodeCommitMarker(marker)
+	if err != nil {
+		return 0, 0, err
+	}
+	data, err := tree.dbGet([]byte(recentVersionNumber))
+	if err == ErrDatabaseNotFound {
+		return version, recentVersion, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	stored, err := decodeVersion(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	if stored > recentVersion {
+		recentVersion = stored
+	}
+	return version, recentVersion, nil
+}
+
+// writeRecoveredVersions adds the latest and recent version the db is
+// recovered to to batch. Commits start at version 1, so a tree recovered to
+// version 0 was never committed and has no version records.
+func (tree *BASSparseMerkleTree) writeRecoveredVersions(batch Batcher, latest, recentVersion Version) error {
+	if latest == 0 {
+		if err := batch.Delete([]byte(latestVersionKeyPrefix)); err != nil {
+			return err
+		}
+		return batch.Delete([]byte(recentVersionNumber))
+	}
+	if recentVersion > latest {
+		recentVersion = latest
+	}
+	if err := batch.Set([]byte(latestVersionKeyPrefix), encodeVersion(uint64(latest))); err != nil {
+		return err
+	}
+	return batch.Set([]byte(recentVersionNumber), encodeVersion(uint64(recentVersion)))
+}
+
+// commitComplete reports whether every block the commit of version wrote
+// reached the db. Each commit writes the root block and the blocks of the
+// nodes it changed, so the blocks below the root whose parents record a
+// change at version must record it too, with the same hash.
+func (tree *BASSparseMerkleTree) commitComplete(version Version) (bool, error) {
+	block, err := tree.readBlock(0, nil)
+	if err == ErrDatabaseNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if n := len(block.Versions); n == 0 || block.Versions[n-1].Ver != version {
+		return false, nil
+	}
+	return tree.blockComplete(block, 0, make([]byte, (int(tree.maxDepth)+7)/8), version)
+}
+
+func (tree *BASSparseMerkleTree) blockComplete(block *StorageFullTreeNode, depth uint8, path []byte, version Version) (bool, error) {
+	if depth+4 >= tree.maxDepth {
+		return true, nil
+	}
+	for pos := 0; pos < 16; pos++ {
+		stored := block.Children[14+pos].Versions
+		n := len(stored)
+		if n == 0 || stored[n-1].Ver != version {
+			continue
+		}
+		childPath := blockChildPath(path, depth, pos)
+		child, err := tree.readBlock(depth+4, childPath)
+		if err == ErrDatabaseNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		m := len(child.Versions)
+		if m == 0 || child.Versions[m-1].Ver != version || !bytes.Equal(child.Versions[m-1].Hash, stored[n-1].Hash) {
+			return false, nil
+		}
+		if ok, err := tree.blockComplete(child, depth+4, childPath, version); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// dropHistoryAfter adds to batch the stored blocks with the history newer
+// than latest dropped, or their deletion if none is left, and returns how
+// many it changed. It visits every block reachable from the root at any
+// version; blocks of the commit whose parents it did not write are not
+// reachable, and are overwritten when their path is committed again.
+func (tree *BASSparseMerkleTree) dropHistoryAfter(batch Batcher, latest Version) (int, error) {
+	dropped := 0
+	var visit func(depth uint8, path []byte) error
+	visit = func(depth uint8, path []byte) error {
+		block, err := tree.readBlock(depth, path)
+		if err == ErrDatabaseNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for pos := 0; depth+4 < tree.maxDepth && pos < 16; pos++ {
+			if block.Children[14+pos].empty() {
+				continue
+			}
+			if err := visit(depth+4, blockChildPath(path, depth, pos)); err != nil {
+				return err
+			}
+		}
+		if !block.truncate(latest) {
+			return nil
+		}
+		dropped++
+		key := storageNodeKey(depth, path)
+		if tree.maxNodeDeltas > 0 {
+			if err := batch.Delete(deltaNodeKey(key)); err != nil {
+				return err
+			}
+		}
+		if len(block.Versions) == 0 {
+			return batch.Delete(key)
+		}
+		data, err := tree.encodeStoredNode(block)
+		if err != nil {
+			return err
+		}
+		return batch.Set(key, data)
+	}
+	if err := visit(0, make([]byte, (int(tree.maxDepth)+7)/8)); err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
+
+// blockChildPath returns path with the four bits below depth set to pos,
+// the path of the block rooted at the pos-th node of the last level of the
+// block at depth.
+func blockChildPath(path []byte, depth uint8, pos int) []byte {
+	child := append([]byte{}, path...)
+	for l := uint8(0); l < 4; l++ {
+		bit := byte(0x80) >> ((depth + l) % 8)
+		if pos&(8>>l) != 0 {
+			child[(depth+l)/8] |= bit
+		} else {
+			child[(depth+l)/8] &^= bit
+		}
+	}
+	return child
+}
@@ -0,0 +1,181 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+// tornDB applies only the first ops of the batch written while keep is set
+// and fails it, as a store without atomic batches does when the process
+// dies in the middle of a write.
+type tornDB struct {
+	*FastMemoryDB
+	keep func(ops int) int
+}
+
+func (db *tornDB) NewBatch() Batcher {
+	return &tornBatch{db: db}
+}
+
+type tornBatch struct {
+	db  *tornDB
+	ops []fastMemoryBatchOp
+}
+
+func (b *tornBatch) Set(key []byte, value []byte) error {
+	b.ops = append(b.ops, fastMemoryBatchOp{key: string(key), value: value})
+	return nil
+}
+
+func (b *tornBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, fastMemoryBatchOp{key: string(key), delete: true})
+	return nil
+}
+
+func (b *tornBatch) Write() error {
+	ops := b.ops
+	if b.db.keep != nil {
+		ops = ops[:b.db.keep(len(ops))]
+	}
+	batch := b.db.FastMemoryDB.NewBatch().(*fastMemoryBatch)
+	batch.ops = ops
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if b.db.keep != nil {
+		return errCrash
+	}
+	return nil
+}
+
+func (b *tornBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+func TestRecoverIncompleteCommit(t *testing.T) {
+	kvs := testKVs(128)
+	want := newTestTree(t)
+	for _, kv := range kvs {
+		if err := want.Set(kv.Key, kv.Val); err != nil {
+			t.Fatal(err)
+		}
+		if len(want.PendingKeys()) == 64 {
+			if _, err := want.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := want.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		keep          func(ops int) int
+		rolledForward bool
+	}{
+		{"nothing written", func(int) int { return 0 }, false},
+		{"torn", func(ops int) int { return ops / 2 }, false},
+		{"all but the marker", func(ops int) int { return ops - 1 }, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &tornDB{FastMemoryDB: NewFastMemoryDB(0)}
+			tree := newTestTree(t, WithCustomDB(db))
+			for _, kv := range kvs[:64] {
+				if err := tree.Set(kv.Key, kv.Val); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := tree.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			committed := tree.CommittedRoot()
+			for _, kv := range kvs[64:] {
+				if err := tree.Set(kv.Key, kv.Val); err != nil {
+					t.Fatal(err)
+				}
+			}
+			db.keep = tc.keep
+			if _, err := tree.Commit(); err != errCrash {
+				t.Fatalf("got %v, want the simulated crash", err)
+			}
+			db.keep = nil
+
+			var recoveries []CommitRecovery
+			reopened := newTestTree(t, WithCustomDB(db), WithRecoveryCallback(func(recovery CommitRecovery) {
+				recoveries = append(recoveries, recovery)
+			}))
+			if len(recoveries) != 1 || recoveries[0].Version != 2 || recoveries[0].RolledForward != tc.rolledForward {
+				t.Fatalf("got recoveries %+v, want version 2 rolled forward %v", recoveries, tc.rolledForward)
+			}
+			if _, err := db.Get([]byte(commitInProgressKey)); err != ErrDatabaseNotFound {
+				t.Fatal("commit marker is left behind after recovery")
+			}
+			if tc.rolledForward {
+				if reopened.LatestVersion() != 2 || !bytes.Equal(reopened.CommittedRoot(), want.CommittedRoot()) {
+					t.Fatal("rolled forward commit differs from an uninterrupted one")
+				}
+				return
+			}
+			if reopened.LatestVersion() != 1 || !bytes.Equal(reopened.CommittedRoot(), committed) {
+				t.Fatalf("recovered to version %d, want the first commit", reopened.LatestVersion())
+			}
+			if tc.name == "torn" && recoveries[0].DroppedBlocks == 0 {
+				t.Fatal("torn commit dropped no block")
+			}
+			for _, kv := range kvs[64:] {
+				if err := reopened.Set(kv.Key, kv.Val); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := reopened.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			checked := newTestTree(t, WithCustomDB(db))
+			if !bytes.Equal(checked.CommittedRoot(), want.CommittedRoot()) {
+				t.Fatal("commit after recovery differs from an uninterrupted one")
+			}
+			for _, kv := range kvs {
+				val, err := checked.Get(kv.Key, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(val, kv.Val) {
+					t.Fatal("value lost after recovery")
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverFirstCommit(t *testing.T) {
+	db := &tornDB{FastMemoryDB: NewFastMemoryDB(0)}
+	tree := newTestTree(t, WithCustomDB(db))
+	for _, kv := range testKVs(64) {
+		if err := tree.Set(kv.Key, kv.Val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.keep = func(ops int) int { return ops / 2 }
+	if _, err := tree.Commit(); err != errCrash {
+		t.Fatalf("got %v, want the simulated crash", err)
+	}
+	db.keep = nil
+
+	version, err := tree.RecoverIncompleteCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || tree.LatestVersion() != 0 || tree.PendingCount() != 0 {
+		t.Fatalf("recovered version %d to %d, want 1 to an empty tree", version, tree.LatestVersion())
+	}
+	if !bytes.Equal(tree.Root(), tree.nilHashes[0]) {
+		t.Fatal("recovered tree is not empty")
+	}
+	if _, err := Open(WithCustomDB(db)); err != ErrTreeNotFound {
+		t.Fatalf("got %v, want ErrTreeNotFound", err)
+	}
+	if version, err := newTestTree(t).RecoverIncompleteCommit(); version != 0 || err != nil {
+		t.Fatalf("got %d, %v without a db", version, err)
+	}
+}
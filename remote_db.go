@@ -0,0 +1,266 @@
+package bsmt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	_ TreeDB      = (*RemoteDB)(nil)
+	_ MultiGetter = (*RemoteDB)(nil)
+)
+
+const (
+	remoteNamespaceHeader = "X-Bsmt-Namespace"
+	remoteDefaultTimeout  = 10 * time.Second
+)
+
+// remoteRequest is the body of every call to a RemoteDBHandler.
+type remoteRequest struct {
+	Key   []byte     `json:"key,omitempty"`
+	Value []byte     `json:"value,omitempty"`
+	Keys  [][]byte   `json:"keys,omitempty"`
+	Ops   []remoteOp `json:"ops,omitempty"`
+}
+
+type remoteOp struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+type remoteResponse struct {
+	Value []byte `json:"value,omitempty"`
+	Has   bool   `json:"has,omitempty"`
+	// NotFound tells a missing key apart from a 404 of anything else on
+	// the way, e.g. a proxy or a wrong endpoint.
+	NotFound bool   `json:"notFound,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Values and Missing answer a multiget, one entry per requested key.
+	Values  [][]byte `json:"values,omitempty"`
+	Missing []bool   `json:"missing,omitempty"`
+}
+
+// RemoteDB is a TreeDB served by a RemoteDBHandler over HTTP, so that many
+// provers can share one storage service. Every call is bounded by the client
+// timeout and is not retried; wrap it with NewRetryDB to retry transient
+// failures.
+type RemoteDB struct {
+	endpoint  string
+	namespace string
+	client    *http.Client
+}
+
+// NewRemoteDB returns a client of the RemoteDBHandler at endpoint whose keys
+// live in namespace. A nil client uses a default one with a 10s timeout.
+func NewRemoteDB(endpoint, namespace string, client *http.Client) *RemoteDB {
+	if client == nil {
+		client = &http.Client{Timeout: remoteDefaultTimeout}
+	}
+	return &RemoteDB{endpoint: endpoint, namespace: namespace, client: client}
+}
+
+func (db *RemoteDB) call(ctx context.Context, method string, req *remoteRequest) (*remoteResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, db.endpoint+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(remoteNamespaceHeader, db.namespace)
+	httpResp, err := db.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	resp := &remoteResponse{}
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		if httpResp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("remote db %s: %s", method, httpResp.Status)
+		}
+		return nil, err
+	}
+	if resp.NotFound {
+		return nil, ErrDatabaseNotFound
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote db %s: %s", method, resp.Error)
+	}
+	return resp, nil
+}
+
+func (db *RemoteDB) Get(key []byte) ([]byte, error) {
+	resp, err := db.call(context.Background(), "get", &remoteRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// MultiGet reads keys in a single call. A failed call fails every key.
+func (db *RemoteDB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values, errs := make([][]byte, len(keys)), make([]error, len(keys))
+	resp, err := db.call(context.Background(), "multiget", &remoteRequest{Keys: keys})
+	if err == nil && (len(resp.Values) != len(keys) || len(resp.Missing) != len(keys)) {
+		err = fmt.Errorf("remote db multiget: got %d values for %d keys", len(resp.Values), len(keys))
+	}
+	for i := range keys {
+		switch {
+		case err != nil:
+			errs[i] = err
+		case resp.Missing[i]:
+			errs[i] = ErrDatabaseNotFound
+		default:
+			values[i] = resp.Values[i]
+		}
+	}
+	return values, errs
+}
+
+func (db *RemoteDB) Has(key []byte) (bool, error) {
+	resp, err := db.call(context.Background(), "has", &remoteRequest{Key: key})
+	if err != nil {
+		return false, err
+	}
+	return resp.Has, nil
+}
+
+func (db *RemoteDB) Set(key []byte, value []byte) error {
+	_, err := db.call(context.Background(), "set", &remoteRequest{Key: key, Value: value})
+	return err
+}
+
+func (db *RemoteDB) Delete(key []byte) error {
+	_, err := db.call(context.Background(), "delete", &remoteRequest{Key: key})
+	return err
+}
+
+func (db *RemoteDB) Ping(ctx context.Context) error {
+	_, err := db.call(ctx, "ping", &remoteRequest{})
+	return err
+}
+
+func (db *RemoteDB) NewBatch() Batcher {
+	return &remoteBatch{db: db}
+}
+
+// remoteBatch buffers operations and sends them in a single call.
+type remoteBatch struct {
+	db  *RemoteDB
+	ops []remoteOp
+}
+
+func (b *remoteBatch) Set(key []byte, value []byte) error {
+	b.ops = append(b.ops, remoteOp{Key: key, Value: value})
+	return nil
+}
+
+func (b *remoteBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, remoteOp{Key: key, Delete: true})
+	return nil
+}
+
+func (b *remoteBatch) Write() error {
+	_, err := b.db.call(context.Background(), "write", &remoteRequest{Ops: b.ops})
+	return err
+}
+
+func (b *remoteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// RemoteDBHandler serves a TreeDB to RemoteDB clients. Each namespace is
+// stored under its own key prefix of db, the namespace preceded by its
+// length, so no namespace is a prefix of another.
+type RemoteDBHandler struct {
+	db TreeDB
+}
+
+func NewRemoteDBHandler(db TreeDB) *RemoteDBHandler {
+	return &RemoteDBHandler{db: db}
+}
+
+func (h *RemoteDBHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req remoteRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(&req); err != nil {
+		writeRemoteResponse(w, http.StatusBadRequest, &remoteResponse{Error: err.Error()})
+		return
+	}
+	prefix := remoteNamespacePrefix(r.Header.Get(remoteNamespaceHeader))
+	key := append(append([]byte{}, prefix...), req.Key...)
+
+	resp := &remoteResponse{}
+	var err error
+	switch r.URL.Path {
+	case "/get":
+		resp.Value, err = h.db.Get(key)
+	case "/multiget":
+		resp.Values, resp.Missing = make([][]byte, len(req.Keys)), make([]bool, len(req.Keys))
+		for i, k := range req.Keys {
+			resp.Values[i], err = h.db.Get(append(append([]byte{}, prefix...), k...))
+			if errors.Is(err, ErrDatabaseNotFound) {
+				resp.Missing[i], err = true, nil
+			}
+			if err != nil {
+				break
+			}
+		}
+	case "/has":
+		resp.Has, err = h.db.Has(key)
+	case "/set":
+		err = h.db.Set(key, req.Value)
+	case "/delete":
+		err = h.db.Delete(key)
+	case "/ping":
+		err = h.db.Ping(r.Context())
+	case "/write":
+		batch := h.db.NewBatch()
+		for _, op := range req.Ops {
+			opKey := append(append([]byte{}, prefix...), op.Key...)
+			if op.Delete {
+				err = batch.Delete(opKey)
+			} else {
+				err = batch.Set(opKey, op.Value)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = batch.Write()
+		}
+	default:
+		writeRemoteResponse(w, http.StatusBadRequest, &remoteResponse{Error: "unknown method"})
+		return
+	}
+	switch {
+	case errors.Is(err, ErrDatabaseNotFound):
+		writeRemoteResponse(w, http.StatusNotFound, &remoteResponse{NotFound: true, Error: err.Error()})
+	case err != nil:
+		writeRemoteResponse(w, http.StatusInternalServerError, &remoteResponse{Error: err.Error()})
+	default:
+		writeRemoteResponse(w, http.StatusOK, resp)
+	}
+}
+
+func remoteNamespacePrefix(namespace string) []byte {
+	var buf bytes.Buffer
+	putBytes(&buf, []byte(namespace))
+	return buf.Bytes()
+}
+
+func writeRemoteResponse(w http.ResponseWriter, status int, resp *remoteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,43 @@
+package bsmt
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteDBNamespaces(t *testing.T) {
+	server := httptest.NewServer(NewRemoteDBHandler(NewFastMemoryDB(0)))
+	defer server.Close()
+	a := NewRemoteDB(server.URL, "a", nil)
+	ab := NewRemoteDB(server.URL, "a/b", nil)
+	if err := a.Set([]byte("b/c"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ab.Get([]byte("c")); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v, want a key of another namespace not found", err)
+	}
+	batch := ab.NewBatch()
+	if err := batch.Set([]byte("c"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	val, err := a.Get([]byte("b/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val, []byte("1")) {
+		t.Fatal("a write to one namespace overwrites another")
+	}
+}
+
+func TestRemoteDBPlainNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if _, err := NewRemoteDB(server.URL, "a", nil).Get([]byte("key")); err == nil || err == ErrDatabaseNotFound {
+		t.Fatalf("got %v for a 404 not sent by the handler, want another error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package bsmt
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	_ TreeDB  = (*RetryDB)(nil)
+	_ Flusher = (*RetryDB)(nil)
+)
+
+// RetryPolicy controls how RetryDB retries failed operations.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// IsTransient classifies errors worth retrying. ErrDatabaseNotFound is
+	// never retried. A nil IsTransient retries every other error.
+	IsTransient func(err error) bool
+}
+
+// RetryDB retries reads and batch writes of the wrapped TreeDB on transient
+// errors with exponential backoff.
+type RetryDB struct {
+	inner  TreeDB
+	policy RetryPolicy
+}
+
+func NewRetryDB(inner TreeDB, policy RetryPolicy) *RetryDB {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &RetryDB{inner: inner, policy: policy}
+}
+
+func (db *RetryDB) retry(fn func() error) error {
+	backoff := db.policy.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || err == ErrDatabaseNotFound || attempt >= db.policy.MaxAttempts {
+			return err
+		}
+		if db.policy.IsTransient != nil && !db.policy.IsTransient(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if db.policy.MaxBackoff > 0 && backoff > db.policy.MaxBackoff {
+			backoff = db.policy.MaxBackoff
+		}
+	}
+}
+
+func (db *RetryDB) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := db.retry(func() error {
+		var err error
+		value, err = db.inner.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (db *RetryDB) Has(key []byte) (bool, error) {
+	var has bool
+	err := db.retry(func() error {
+		var err error
+		has, err = db.inner.Has(key)
+		return err
+	})
+	return has, err
+}
+
+func (db *RetryDB) Set(key []byte, value []byte) error { return db.inner.Set(key, value) }
+func (db *RetryDB) Delete(key []byte) error            { return db.inner.Delete(key) }
+func (db *RetryDB) Ping(ctx context.Context) error     { return db.inner.Ping(ctx) }
+
+// Flush flushes the wrapped db, retrying like a batch write.
+func (db *RetryDB) Flush() error {
+	return db.retry(func() error { return flushDB(db.inner) })
+}
+
+func (db *RetryDB) NewBatch() Batcher {
+	return &retryBatch{Batcher: db.inner.NewBatch(), db: db}
+}
+
+type retryBatch struct {
+	Batcher
+	db *RetryDB
+}
+
+func (b *retryBatch) Write() error {
+	return b.db.retry(b.Batcher.Write)
+}
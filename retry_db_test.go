@@ -0,0 +1,126 @@
+package bsmt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var errBackend = errors.New("backend unavailable")
+
+// failingDB fails the next failures reads, writes and batch writes with
+// errBackend, then behaves as the wrapped FastMemoryDB. calls counts every
+// operation it saw.
+type failingDB struct {
+	*FastMemoryDB
+	failures int
+	calls    int
+}
+
+func newFailingDB(failures int) *failingDB {
+	return &failingDB{FastMemoryDB: NewFastMemoryDB(0), failures: failures}
+}
+
+func (db *failingDB) fail() error {
+	db.calls++
+	if db.failures == 0 {
+		return nil
+	}
+	db.failures--
+	return errBackend
+}
+
+func (db *failingDB) Get(key []byte) ([]byte, error) {
+	if err := db.fail(); err != nil {
+		return nil, err
+	}
+	return db.FastMemoryDB.Get(key)
+}
+
+func (db *failingDB) Has(key []byte) (bool, error) {
+	if err := db.fail(); err != nil {
+		return false, err
+	}
+	return db.FastMemoryDB.Has(key)
+}
+
+func (db *failingDB) Set(key []byte, value []byte) error {
+	if err := db.fail(); err != nil {
+		return err
+	}
+	return db.FastMemoryDB.Set(key, value)
+}
+
+func (db *failingDB) Delete(key []byte) error {
+	if err := db.fail(); err != nil {
+		return err
+	}
+	return db.FastMemoryDB.Delete(key)
+}
+
+func (db *failingDB) NewBatch() Batcher {
+	return &failingBatch{Batcher: db.FastMemoryDB.NewBatch(), db: db}
+}
+
+type failingBatch struct {
+	Batcher
+	db *failingDB
+}
+
+func (b *failingBatch) Write() error {
+	if err := b.db.fail(); err != nil {
+		return err
+	}
+	return b.Batcher.Write()
+}
+
+func TestRetryDB(t *testing.T) {
+	backend := newFailingDB(0)
+	if err := backend.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	db := NewRetryDB(backend, RetryPolicy{MaxAttempts: 3})
+
+	backend.failures, backend.calls = 2, 0
+	val, err := db.Get([]byte("key"))
+	if err != nil || !bytes.Equal(val, []byte("value")) {
+		t.Fatalf("got %q, %v after two transient failures", val, err)
+	}
+	if backend.calls != 3 {
+		t.Fatalf("made %d calls, want 3", backend.calls)
+	}
+
+	backend.failures, backend.calls = 5, 0
+	if _, err := db.Has([]byte("key")); err != errBackend {
+		t.Fatalf("got %v, want the backend error once attempts run out", err)
+	}
+	if backend.calls != 3 {
+		t.Fatalf("made %d calls, want 3", backend.calls)
+	}
+
+	backend.failures, backend.calls = 0, 0
+	if _, err := db.Get([]byte("missing")); err != ErrDatabaseNotFound || backend.calls != 1 {
+		t.Fatalf("got %v after %d calls, want ErrDatabaseNotFound without retries", err, backend.calls)
+	}
+
+	backend.failures, backend.calls = 1, 0
+	batch := db.NewBatch()
+	if err := batch.Set([]byte("batched"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.FastMemoryDB.Get([]byte("batched")); err != nil {
+		t.Fatal("retried batch write did not reach the backend")
+	}
+
+	permanent := NewRetryDB(backend, RetryPolicy{
+		MaxAttempts: 3,
+		IsTransient: func(err error) bool { return err != errBackend },
+	})
+	backend.failures, backend.calls = 2, 0
+	if _, err := permanent.Get([]byte("key")); err != errBackend || backend.calls != 1 {
+		t.Fatalf("got %v after %d calls, want a permanent error returned at once", err, backend.calls)
+	}
+}
@@ -0,0 +1,175 @@
+package bsmt
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErrRollbackTooOld is returned by Rollback for a version below the recent
+// version, whose history has been pruned. It wraps ErrVersionTooOld and
+// carries the earliest version Rollback accepts.
+type ErrRollbackTooOld struct {
+	Version       Version
+	RecentVersion Version
+}
+
+func (e *ErrRollbackTooOld) Error() string {
+	return fmt.Sprintf("cannot roll back to version %d: %v, earliest rollback version is %d",
+		e.Version, ErrVersionTooOld, e.RecentVersion)
+}
+
+func (e *ErrRollbackTooOld) Unwrap() error {
+	return ErrVersionTooOld
+}
+
+// EarliestRollbackVersion returns the lowest version Rollback accepts.
+func (tree *BASSparseMerkleTree) EarliestRollbackVersion() Version {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return Version(tree.recentVersion)
+}
+
+// collectRollback returns the nodes with history newer than version,
+// loading the blocks they are stored in. Subtrees whose latest version is
+// not newer than version hold nothing to roll back and are not visited.
+// The walk only reads the tree, so it can be cancelled through ctx without
+// leaving any change behind.
+func (tree *BASSparseMerkleTree) collectRollback(ctx context.Context, version Version, progress ProgressFunc) ([]*FullTreeNode, error) {
+	var nodes []*FullTreeNode
+	path := make([]byte, (int(tree.maxDepth)+7)/8)
+	var walk func(node *FullTreeNode) error
+	walk = func(node *FullTreeNode) error {
+		if node == nil {
+			return nil
+		}
+		if n := len(node.Versions); n == 0 || node.Versions[n-1].Ver <= version {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		if progress != nil {
+			progress(len(nodes))
+		}
+		if node.Depth == tree.maxDepth {
+			return nil
+		}
+		if err := tree.loadChildren(node, path); err != nil {
+			return err
+		}
+		if err := walk(fullNode(node.LeftChild)); err != nil {
+			return err
+		}
+		path[node.Depth/8] |= 0x80 >> (node.Depth % 8)
+		defer func() { path[node.Depth/8] &^= 0x80 >> (node.Depth % 8) }()
+		return walk(fullNode(node.RightChild))
+	}
+	if err := walk(tree.rootNode()); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// rollbackNodes drops the history newer than version from nodes and marks
+// them dirty. Nodes left without history did not exist at version. The
+// returned func restores the nodes, e.g. after the rollback failed to be
+// written.
+func (tree *BASSparseMerkleTree) rollbackNodes(nodes []*FullTreeNode, version Version) func() {
+	saved := make([][]*VersionInfo, len(nodes))
+	for i, node := range nodes {
+		saved[i] = node.Versions
+		node.Rollback(version)
+		tree.countVersions(node, len(node.Versions)-len(saved[i]))
+		if n := len(node.Versions); n > 0 {
+			tree.setHash(node, node.Versions[n-1].Hash)
+		} else {
+			tree.setHash(node, tree.nilHashes[node.Depth])
+		}
+		node.Dirty = true
+	}
+	return func() {
+		for i, node := range nodes {
+			tree.countVersions(node, len(saved[i])-len(node.Versions))
+			node.Versions = saved[i]
+			tree.setHash(node, saved[i][len(saved[i])-1].Hash)
+			node.Dirty = false
+		}
+	}
+}
+
+// writeRollback rewrites the blocks of the rolled back nodes in full,
+// deletes those left without history, sets the latest version and drops the
+// signatures of the rolled back roots and the state saved by Flush, in one
+// batch.
+func (tree *BASSparseMerkleTree) writeRollback(version Version) error {
+	batch := tree.db.NewBatch()
+	var err error
+	tree.walkDirty(func(node *FullTreeNode, path []byte) {
+		if err != nil || node.Depth%4 != 0 || node.Depth == tree.maxDepth {
+			return
+		}
+		key := storageNodeKey(node.Depth, path)
+		if tree.maxNodeDeltas > 0 {
+			if err = batch.Delete(deltaNodeKey(key)); err != nil {
+				return
+			}
+		}
+		if len(node.Versions) == 0 {
+			err = batch.Delete(key)
+			return
+		}
+		var data []byte
+		if data, err = tree.encodeStoredNode(tree.storageBlock(node, Version(tree.recentVersion))); err == nil {
+			err = batch.Set(key, data)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	kept, trimmed, err := tree.trimAnnotations(batch, Version(tree.recentVersion), version+1)
+	if err != nil {
+		return err
+	}
+	if trimmed {
+		if err := tree.writeAnnotationsIndex(batch, kept); err != nil {
+			return err
+		}
+	}
+	// Signatures of rolled back roots must not verify until they are
+	// committed and signed again.
+	for v := version + 1; v <= Version(tree.version) && v > version; v++ {
+		if err := batch.Delete(rootSignatureKey(v)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Set([]byte(latestVersionKeyPrefix), encodeVersion(uint64(version))); err != nil {
+		return err
+	}
+	if err := batch.Delete([]byte(flushedStateKey)); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// finishRollback unlinks the rolled back nodes that did not exist at the
+// version rolled back to and marks the rest clean.
+func (tree *BASSparseMerkleTree) finishRollback() {
+	var finish func(node *FullTreeNode)
+	finish = func(node *FullTreeNode) {
+		if node == nil || !node.Dirty {
+			return
+		}
+		node.Dirty = false
+		for _, child := range []*TreeNode{&node.LeftChild, &node.RightChild} {
+			full := fullNode(*child)
+			if full != nil && full.Dirty && len(full.Versions) == 0 && full.Depth > prefixLockDepth {
+				tree.releaseNodes(full)
+				*child = nil
+				continue
+			}
+			finish(full)
+		}
+	}
+	finish(tree.rootNode())
+}
@@ -0,0 +1,149 @@
+package bsmt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRollbackRestoresRoot(t *testing.T) {
+	for _, db := range []TreeDB{nil, NewFastMemoryDB(0)} {
+		var opts []Option
+		if db != nil {
+			opts = append(opts, WithCustomDB(db))
+		}
+		tree := newTestTree(t, opts...)
+		roots := commitVersions(t, tree, 48)
+		// Keys only added after version 1 must disappear again.
+		for i := 48; i < 64; i++ {
+			if err := tree.Set(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Set(testKey(0), testValue(999)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Rollback(1); err != nil {
+			t.Fatal(err)
+		}
+		if tree.LatestVersion() != 1 {
+			t.Fatalf("latest version is %d after rolling back to 1", tree.LatestVersion())
+		}
+		if !bytes.Equal(tree.Root(), roots[1]) || !bytes.Equal(tree.CommittedRoot(), roots[1]) {
+			t.Fatal("root differs from the root committed at version 1")
+		}
+		if !bytes.Equal(fullRoot(t, tree), roots[1]) {
+			t.Fatal("pruned rollback walk left hashes a full walk does not reproduce")
+		}
+		for i := 0; i < 64; i++ {
+			val, err := tree.Get(testKey(i), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := testValue(i)
+			if i >= 48 {
+				want = nil
+			}
+			if !bytes.Equal(val, want) {
+				t.Fatalf("key %d has the wrong value after the rollback", i)
+			}
+		}
+		// The next commit continues from the version rolled back to.
+		if err := tree.Set(testKey(1), testValue(5)); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := tree.Commit(); err != nil || v != 2 {
+			t.Fatalf("committed version %d, %v after the rollback, want 2", v, err)
+		}
+		if db != nil {
+			reopened := newTestTree(t, WithCustomDB(db))
+			if !bytes.Equal(reopened.Root(), tree.Root()) {
+				t.Fatal("reopened tree differs from the rolled back one")
+			}
+		}
+	}
+}
+
+func TestRollbackToZero(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	empty := tree.Root()
+	commitVersions(t, tree, 8)
+	if err := tree.Rollback(0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.Root(), empty) {
+		t.Fatal("rolling back to 0 does not restore the empty root")
+	}
+	if err := tree.SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollbackCancelled(t *testing.T) {
+	tree := newTestTree(t)
+	roots := commitVersions(t, tree, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tree.RollbackWithContext(ctx, 1, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if tree.LatestVersion() != 3 || !bytes.Equal(tree.Root(), roots[3]) {
+		t.Fatal("cancelled rollback changed the tree")
+	}
+}
+
+func TestRollbackMatchesRecomputedRoot(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	// state holds the values of every version, rebuilt below from scratch.
+	state := make(map[int][]byte)
+	var states []map[int][]byte
+	for v := 1; v <= 6; v++ {
+		for i := v * 8; i < v*8+24; i++ {
+			if err := tree.Set(testKey(i), testValue(i*v)); err != nil {
+				t.Fatal(err)
+			}
+			state[i] = testValue(i * v)
+		}
+		if v > 1 {
+			// Set by the previous version only.
+			if err := tree.Delete(testKey((v - 1) * 8)); err != nil {
+				t.Fatal(err)
+			}
+			delete(state, (v-1)*8)
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		snapshot := make(map[int][]byte, len(state))
+		for i, val := range state {
+			snapshot[i] = val
+		}
+		states = append(states, snapshot)
+	}
+	for _, version := range []Version{4, 2} {
+		if err := tree.Rollback(version); err != nil {
+			t.Fatal(err)
+		}
+		rebuilt := newTestTree(t)
+		for i, val := range states[version-1] {
+			if err := rebuilt.Set(testKey(i), val); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := rebuilt.Root()
+		if !bytes.Equal(tree.Root(), want) {
+			t.Fatalf("root after rolling back to %d differs from a tree rebuilt from its values", version)
+		}
+		if !bytes.Equal(fullRoot(t, tree), want) {
+			t.Fatalf("rolling back to %d left hashes a full recomputation does not reproduce", version)
+		}
+		if !bytes.Equal(fullRoot(t, newTestTree(t, WithCustomDB(db))), want) {
+			t.Fatalf("blocks stored by rolling back to %d do not recompute to its root", version)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package bsmt
+
+import "bytes"
+
+const rootSignatureKeyPrefix string = "rootSignature"
+
+// RootSigner signs the root committed at version.
+type RootSigner func(version Version, root []byte) []byte
+
+// RootVerifier checks a signature produced by a RootSigner.
+type RootVerifier func(version Version, root, signature []byte) bool
+
+func rootSignatureKey(version Version) []byte {
+	return append([]byte(rootSignatureKeyPrefix), encodeVersion(uint64(version))...)
+}
+
+// signRoot stores the signature of root at version together with the root.
+func (tree *BASSparseMerkleTree) signRoot(version Version, root []byte) error {
+	var buf bytes.Buffer
+	putBytes(&buf, root)
+	buf.Write(tree.rootSigner(version, root))
+	return tree.db.Set(rootSignatureKey(version), buf.Bytes())
+}
+
+// VerifyRootSignature loads the root stored for version with its signature
+// and checks the signature with verifier. It proves which writer produced
+// the root, not the contents of the tree. A version that was never signed,
+// or was rolled back, fails with ErrDatabaseNotFound.
+func (tree *BASSparseMerkleTree) VerifyRootSignature(version Version, verifier RootVerifier) ([]byte, bool, error) {
+	if tree.db == nil {
+		return nil, false, ErrDatabaseRequired
+	}
+	record, err := tree.db.Get(rootSignatureKey(version))
+	if err != nil {
+		return nil, false, err
+	}
+	r := bytes.NewReader(record)
+	root, err := readBytes(r)
+	if err != nil {
+		return nil, false, err
+	}
+	signature := make([]byte, r.Len())
+	r.Read(signature)
+	return root, verifier(version, root, signature), nil
+}
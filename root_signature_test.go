@@ -0,0 +1,82 @@
+package bsmt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+)
+
+// hmacSigner signs roots with an HMAC under key.
+func hmacSigner(key []byte) (RootSigner, RootVerifier) {
+	sign := func(version Version, root []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(encodeVersion(uint64(version)))
+		mac.Write(root)
+		return mac.Sum(nil)
+	}
+	verify := func(version Version, root, signature []byte) bool {
+		return hmac.Equal(sign(version, root), signature)
+	}
+	return sign, verify
+}
+
+func TestRootSignatures(t *testing.T) {
+	signer, verifier := hmacSigner([]byte("signing key"))
+	_, otherVerifier := hmacSigner([]byte("other key"))
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), WithRootSigner(signer))
+	roots := commitVersions(t, tree, 16)
+	for v := Version(1); v <= 3; v++ {
+		root, ok, err := tree.VerifyRootSignature(v, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || !bytes.Equal(root, roots[v]) {
+			t.Fatalf("version %d: got root %x, signature valid %v, want the committed root signed", v, root, ok)
+		}
+		if _, ok, _ := tree.VerifyRootSignature(v, otherVerifier); ok {
+			t.Fatalf("version %d: the signature verifies under another key", v)
+		}
+	}
+
+	// A root swapped in storage no longer matches its signature.
+	record, err := db.Get(rootSignatureKey(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, record...)
+	tampered[1] ^= 1
+	if err := db.Set(rootSignatureKey(2), tampered); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := tree.VerifyRootSignature(2, verifier); err != nil || ok {
+		t.Fatalf("got %v, %v for a tampered root, want an invalid signature", ok, err)
+	}
+	if err := db.Set(rootSignatureKey(2), record); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tree.VerifyRootSignature(3, verifier); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v for a rolled back version, want ErrDatabaseNotFound", err)
+	}
+	if err := tree.Set(testKey(1), testValue(100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if root, ok, err := tree.VerifyRootSignature(2, verifier); err != nil || !ok || !bytes.Equal(root, tree.CommittedRoot()) {
+		t.Fatal("the version committed again after the rollback is not signed with its new root")
+	}
+
+	if _, err := NewBASSparseMerkleTree(WithRootSigner(signer)); err != ErrDatabaseRequired {
+		t.Fatalf("got %v without a db, want ErrDatabaseRequired", err)
+	}
+	if _, _, err := newTestTree(t).VerifyRootSignature(1, verifier); err != ErrDatabaseRequired {
+		t.Fatalf("got %v verifying without a db, want ErrDatabaseRequired", err)
+	}
+}
@@ -0,0 +1,75 @@
+package bsmt
+
+import (
+	"bytes"
+	"context"
+)
+
+// selfTestKeys is the number of keys written by SelfTest.
+const selfTestKeys = 4
+
+// SelfTest runs the core flow of the tree on a fork with the same hasher,
+// depth, empty leaf and path encoding backed by an in-memory db: it sets a
+// few keys, commits, reads them back, proves and verifies them, rolls back
+// and checks that the empty root is restored. The configured db is only
+// pinged, so the tree itself is never modified. Running it at startup
+// catches a misconfigured tree before it serves traffic. A value or root
+// that does not match fails with ErrSelfTestFailed.
+func (tree *BASSparseMerkleTree) SelfTest() error {
+	if tree.db != nil {
+		if err := tree.db.Ping(context.Background()); err != nil {
+			return err
+		}
+	}
+	fork := &BASSparseMerkleTree{
+		hasher:         tree.hasher,
+		clock:          tree.clock,
+		maxDepth:       tree.maxDepth,
+		nilHashes:      tree.nilHashes,
+		emptyLeaf:      tree.emptyLeaf,
+		pathEncodingID: tree.pathEncodingID,
+		pathEncoding:   tree.pathEncoding,
+		newNode:        tree.newNode,
+		metrics:        &metrics{},
+		db:             NewFastMemoryDB(0),
+	}
+	fork.initRoot()
+	emptyRoot := fork.Root()
+	keyLen := (int(tree.maxDepth) + 7) / 8
+	kvs := make([]KV, selfTestKeys)
+	for i := range kvs {
+		key := make([]byte, keyLen)
+		key[0] = byte(i) << 6
+		key[keyLen-1] |= byte(i)
+		kvs[i] = KV{Key: key, Val: tree.hasher.Hash([]byte{byte(i)})}
+		if err := fork.Set(kvs[i].Key, kvs[i].Val); err != nil {
+			return err
+		}
+	}
+	if _, err := fork.Commit(); err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		val, err := fork.Get(kv.Key, nil)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(val, kv.Val) {
+			return ErrSelfTestFailed
+		}
+		proof, err := fork.GetProof(kv.Key, nil)
+		if err != nil {
+			return err
+		}
+		if err := fork.CheckProof(proof); err != nil {
+			return err
+		}
+	}
+	if err := fork.Rollback(0); err != nil {
+		return err
+	}
+	if !bytes.Equal(fork.Root(), emptyRoot) {
+		return ErrSelfTestFailed
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package bsmt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+// dbRecords returns a copy of every record in db.
+func dbRecords(t *testing.T, db *FastMemoryDB) map[string]string {
+	t.Helper()
+	records := make(map[string]string)
+	if err := db.Iterate(func(key, value []byte) error {
+		records[string(key)] = string(value)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// pingFailingDB is a FastMemoryDB whose Ping fails.
+type pingFailingDB struct {
+	*FastMemoryDB
+}
+
+func (db *pingFailingDB) Ping(ctx context.Context) error { return errBackend }
+
+func TestSelfTest(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"depth 8", []Option{WithMaxDepth(8)}},
+		{"key-bound leaves", []Option{WithKeyBoundLeaves()}},
+		{"path encoding", []Option{WithPathEncoding("bitreverse", BitReversePath)}},
+		{"nil ladder seed", []Option{WithNilLadderSeed([]byte("seed"))}},
+		{"16-byte hasher", []Option{WithHasher(NewHasherWithOutputLen(sha256.New(), 16))}},
+		{"sparse nodes and checksums", []Option{WithSparseNodeEncoding(), WithStorageChecksum()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewFastMemoryDB(0)
+			tree := newTestTree(t, append([]Option{WithCustomDB(db)}, tc.opts...)...)
+			if err := tree.Set(testKey(1), tree.hasher.Hash([]byte("value"))); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tree.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.Set(testKey(2), tree.hasher.Hash([]byte("staged"))); err != nil {
+				t.Fatal(err)
+			}
+			root, records := tree.Root(), dbRecords(t, db)
+			if err := tree.SelfTest(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tree.Root(), root) || tree.LatestVersion() != 1 || tree.PendingCount() != 1 {
+				t.Fatal("SelfTest modified the tree")
+			}
+			if !reflect.DeepEqual(dbRecords(t, db), records) {
+				t.Fatal("SelfTest wrote to the db")
+			}
+		})
+	}
+
+	tree := newTestTree(t, WithCustomDB(&pingFailingDB{NewFastMemoryDB(0)}))
+	if err := tree.SelfTest(); err != errBackend {
+		t.Fatalf("got %v with a failing db, want its error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package bsmt
+
+import (
+	"bytes"
+	"sort"
+)
+
+// shadowLeaf is a leaf of the shadow tree keyed by its path.
+type shadowLeaf struct {
+	path []byte
+	leaf []byte
+}
+
+// recordShadow mirrors a Set into the shadow tree.
+func (tree *BASSparseMerkleTree) recordShadow(key, val []byte) {
+	tree.shadowLock.Lock()
+	defer tree.shadowLock.Unlock()
+	if tree.shadowLeaves == nil {
+		tree.shadowLeaves = make(map[string][]byte)
+	}
+	tree.shadowLeaves[string(tree.path(key))] = val
+}
+
+// ShadowRoot returns the root of the leaves set since the tree was opened,
+// hashed with the WithShadowHasher hasher instead of the tree's own. It is
+// meant to cross-check two hash implementations during a migration.
+func (tree *BASSparseMerkleTree) ShadowRoot() ([]byte, error) {
+	if tree.shadowHasher == nil {
+		return nil, ErrShadowHasherNotSet
+	}
+	tree.shadowLock.Lock()
+	leaves := make([]shadowLeaf, 0, len(tree.shadowLeaves))
+	for path, leaf := range tree.shadowLeaves {
+		leaves = append(leaves, shadowLeaf{path: []byte(path), leaf: leaf})
+	}
+	tree.shadowLock.Unlock()
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i].path, leaves[j].path) < 0
+	})
+
+	nilHashes := make([][]byte, int(tree.maxDepth)+1)
+	nilHashes[tree.maxDepth] = tree.nilHashes[tree.maxDepth]
+	for depth := int(tree.maxDepth) - 1; depth >= 0; depth-- {
+		nilHashes[depth] = tree.shadowHasher.Hash(nilHashes[depth+1], nilHashes[depth+1])
+	}
+	return tree.shadowSubtreeRoot(leaves, 0, nilHashes), nil
+}
+
+// shadowSubtreeRoot hashes the subtree at depth holding leaves, which are
+// sorted by path and all share the first depth bits.
+func (tree *BASSparseMerkleTree) shadowSubtreeRoot(leaves []shadowLeaf, depth int, nilHashes [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nilHashes[depth]
+	}
+	if depth == int(tree.maxDepth) {
+		return leaves[len(leaves)-1].leaf
+	}
+	split := sort.Search(len(leaves), func(i int) bool {
+		path := leaves[i].path
+		return depth/8 < len(path) && path[depth/8]&(0x80>>uint(depth%8)) != 0
+	})
+	left := tree.shadowSubtreeRoot(leaves[:split], depth+1, nilHashes)
+	right := tree.shadowSubtreeRoot(leaves[split:], depth+1, nilHashes)
+	return tree.shadowHasher.Hash(left, right)
+}
@@ -0,0 +1,43 @@
+package bsmt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+)
+
+func TestShadowRoot(t *testing.T) {
+	if _, err := newTestTree(t).ShadowRoot(); err != ErrShadowHasherNotSet {
+		t.Fatalf("got %v without a shadow hasher, want ErrShadowHasherNotSet", err)
+	}
+
+	other := NewHasherWithOutputLen(sha512.New(), 32)
+	// Under its own hasher the shadow root is the root of the tree; under
+	// another one it is the root of a tree using that hasher.
+	same := newTestTree(t, WithShadowHasher(NewHasher(sha256.New())))
+	shadowed := newTestTree(t, WithShadowHasher(other))
+	want := newTestTree(t, WithHasher(other))
+	for _, tree := range []*BASSparseMerkleTree{same, shadowed, want} {
+		commitVersions(t, tree, 32)
+		if err := tree.Delete(testKey(3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Set(testKey(40), testValue(40)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if root, err := same.ShadowRoot(); err != nil || !bytes.Equal(root, same.Root()) {
+		t.Fatalf("got %x, %v, want the tree root under the tree's own hasher", root, err)
+	}
+	root, err := shadowed.ShadowRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(root, want.Root()) {
+		t.Fatal("shadow root differs from the root of a tree using the shadow hasher")
+	}
+	if bytes.Equal(root, shadowed.Root()) {
+		t.Fatal("shadow root equals the root under the tree's own hasher")
+	}
+}
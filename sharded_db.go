@@ -0,0 +1,146 @@
+package bsmt
+
+import "context"
+
+var (
+	_ TreeDB  = (*ShardedDB)(nil)
+	_ Flusher = (*ShardedDB)(nil)
+)
+
+// ShardedDB routes keys across multiple TreeDB backends. Writes that span
+// several shards are not atomic: each shard's batch is flushed in turn and
+// a failure part-way leaves earlier shards written.
+type ShardedDB struct {
+	shards  []TreeDB
+	shardFn func(key []byte) int
+}
+
+// NewShardedDB returns a TreeDB that stores each key in shards[shardFn(key)].
+// A key shardFn maps outside shards fails with ErrInvalidShard.
+func NewShardedDB(shards []TreeDB, shardFn func(key []byte) int) *ShardedDB {
+	return &ShardedDB{shards: shards, shardFn: shardFn}
+}
+
+func (db *ShardedDB) shardIndex(key []byte) (int, error) {
+	i := db.shardFn(key)
+	if i < 0 || i >= len(db.shards) {
+		return 0, ErrInvalidShard
+	}
+	return i, nil
+}
+
+func (db *ShardedDB) shard(key []byte) (TreeDB, error) {
+	i, err := db.shardIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	return db.shards[i], nil
+}
+
+func (db *ShardedDB) Get(key []byte) ([]byte, error) {
+	shard, err := db.shard(key)
+	if err != nil {
+		return nil, err
+	}
+	return shard.Get(key)
+}
+
+func (db *ShardedDB) Has(key []byte) (bool, error) {
+	shard, err := db.shard(key)
+	if err != nil {
+		return false, err
+	}
+	return shard.Has(key)
+}
+
+func (db *ShardedDB) Set(key []byte, value []byte) error {
+	shard, err := db.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.Set(key, value)
+}
+
+func (db *ShardedDB) Delete(key []byte) error {
+	shard, err := db.shard(key)
+	if err != nil {
+		return err
+	}
+	return shard.Delete(key)
+}
+
+func (db *ShardedDB) Ping(ctx context.Context) error {
+	for _, shard := range db.shards {
+		if err := shard.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush flushes every shard in turn, stopping at the first failure.
+func (db *ShardedDB) Flush() error {
+	for _, shard := range db.shards {
+		if err := flushDB(shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *ShardedDB) NewBatch() Batcher {
+	return &shardedBatch{db: db, batches: make([]Batcher, len(db.shards))}
+}
+
+// shardedBatch lazily opens one batch per shard it touches.
+type shardedBatch struct {
+	db      *ShardedDB
+	batches []Batcher
+}
+
+func (b *shardedBatch) batch(key []byte) (Batcher, error) {
+	i, err := b.db.shardIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	if b.batches[i] == nil {
+		b.batches[i] = b.db.shards[i].NewBatch()
+	}
+	return b.batches[i], nil
+}
+
+func (b *shardedBatch) Set(key []byte, value []byte) error {
+	batch, err := b.batch(key)
+	if err != nil {
+		return err
+	}
+	return batch.Set(key, value)
+}
+
+func (b *shardedBatch) Delete(key []byte) error {
+	batch, err := b.batch(key)
+	if err != nil {
+		return err
+	}
+	return batch.Delete(key)
+}
+
+func (b *shardedBatch) Write() error {
+	for _, batch := range b.batches {
+		if batch == nil {
+			continue
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *shardedBatch) Reset() {
+	for _, batch := range b.batches {
+		if batch != nil {
+			batch.Reset()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShardedDBRouting(t *testing.T) {
+	shards := []TreeDB{NewFastMemoryDB(0), NewFastMemoryDB(0)}
+	db := NewShardedDB(shards, func(key []byte) int { return int(key[0]) % 2 })
+	if err := db.Set([]byte{0, 1}, []byte("even")); err != nil {
+		t.Fatal(err)
+	}
+	batch := db.NewBatch()
+	for _, key := range [][]byte{{1, 1}, {2, 1}, {3, 1}} {
+		if err := batch.Set(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Delete([]byte{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]byte{{1, 1}, {2, 1}, {3, 1}} {
+		shard := shards[key[0]%2]
+		if val, err := shard.Get(key); err != nil || !bytes.Equal(val, key) {
+			t.Fatalf("key %x: got %x, %v from its shard", key, val, err)
+		}
+		if _, err := shards[1-key[0]%2].Get(key); err != ErrDatabaseNotFound {
+			t.Fatalf("key %x: written to another shard", key)
+		}
+		if val, err := db.Get(key); err != nil || !bytes.Equal(val, key) {
+			t.Fatalf("key %x: got %x, %v through the sharded db", key, val, err)
+		}
+	}
+	if _, err := shards[0].Get([]byte{0, 1}); err != ErrDatabaseNotFound {
+		t.Fatal("batch delete did not reach its shard")
+	}
+}
+
+func TestShardedDBInvalidShard(t *testing.T) {
+	db := NewShardedDB([]TreeDB{NewFastMemoryDB(0)}, func(key []byte) int { return int(key[0]) })
+	if err := db.Set([]byte{1}, []byte("v")); err != ErrInvalidShard {
+		t.Fatalf("got %v, want ErrInvalidShard", err)
+	}
+	if _, err := db.Get([]byte{1}); err != ErrInvalidShard {
+		t.Fatalf("got %v, want ErrInvalidShard", err)
+	}
+	batch := db.NewBatch()
+	if err := batch.Set([]byte{1}, []byte("v")); err != ErrInvalidShard {
+		t.Fatalf("got %v, want ErrInvalidShard", err)
+	}
+	if err := batch.Set([]byte{0}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,61 +1,1536 @@
 package bsmt
 
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
 const (
 	latestVersionKeyPrefix string = "latestVersion"
 	recentVersionNumber    string = "recentVersionNumber"
 	maxDepthKeyPrefix      string = "maxDepth"
+	configIntegrityKey     string = "configIntegrity"
+	sparseNodeEncodingKey  string = "sparseNodeEncoding"
+	keyBoundLeavesKey      string = "keyBoundLeaves"
+	storageChecksumKey     string = "storageChecksum"
+	latestOnlyKey          string = "latestOnly"
+	deltaNodeWritesKey     string = "deltaWrites"
+	keyBloomFilterKey      string = "keyBloomFilter"
+	commitInProgressKey    string = "commitInProgress"
+	frozenKey              string = "frozen"
+	setEmptyLeafTag        string = "bsmt:set-empty"
 )
 
 var _ SparseMerkleTree = (*BASSparseMerkleTree)(nil)
 
-func NewBASSparseMerkleTree(opts ...Option) SparseMerkleTree {
-	smt := &BASSparseMerkleTree{}
+// defaultMaxDepth is the depth of trees built without WithMaxDepth.
+const defaultMaxDepth uint8 = 64
+
+// prefixLockDepth is the depth of the nodes owned by the prefix locks: a Set
+// under the lock of a top nibble only writes the nodes at or below this
+// depth on its own nibble. The nodes above are always resident and are only
+// rehashed under the exclusive tree lock.
+const prefixLockDepth uint8 = 4
+
+// NewBASSparseMerkleTree builds a tree from opts. The cost of a tree grows
+// linearly with maxDepth: every proof carries maxDepth siblings and every Set
+// rehashes maxDepth nodes, so the depth cannot exceed the hasher's output
+// width in bits.
+func NewBASSparseMerkleTree(opts ...Option) (SparseMerkleTree, error) {
+	smt := &BASSparseMerkleTree{
+		hasher:   NewHasher(sha256.New()),
+		clock:    realClock{},
+		maxDepth: defaultMaxDepth,
+		metrics:  &metrics{},
+	}
 	for _, opt := range opts {
 		opt(smt)
 	}
-	return smt
+	if smt.latestOnly && smt.maxNodeDeltas > 0 {
+		return nil, ErrConflictingOptions
+	}
+	if smt.nodeArena != nil {
+		if smt.newNode != nil {
+			return nil, ErrConflictingOptions
+		}
+		smt.newNode = func() Node { return smt.nodeArena.alloc() }
+	} else if smt.newNode == nil {
+		smt.newNode = func() Node { return &FullTreeNode{} }
+	}
+	if smt.maxDepth == 0 || smt.maxDepth%4 != 0 {
+		return nil, ErrInvalidDepth
+	}
+	if int(smt.maxDepth) > smt.hasher.Size()*8 {
+		return nil, ErrDepthTooLarge
+	}
+	smt.constructNilHashes()
+	smt.initRoot()
+	if smt.db == nil && (smt.keyFilter != nil || smt.rootSigner != nil) {
+		return nil, ErrDatabaseRequired
+	}
+	if smt.db != nil {
+		if err := smt.loadConfig(); err != nil {
+			return nil, err
+		}
+	}
+	if smt.keyFilter != nil {
+		if err := smt.loadBloomFilter(); err != nil {
+			return nil, err
+		}
+	}
+	if smt.db != nil {
+		if err := smt.loadFrozen(); err != nil {
+			return nil, err
+		}
+		recovery, err := smt.recoverIncompleteCommit()
+		if err != nil {
+			return nil, err
+		}
+		if err := smt.loadLatest(); err != nil {
+			return nil, err
+		}
+		if err := smt.loadFlushedState(); err != nil {
+			return nil, err
+		}
+		if recovery != nil && smt.recoveryCallback != nil {
+			smt.recoveryCallback(*recovery)
+		}
+	}
+	return smt, nil
 }
 
 type BASSparseMerkleTree struct {
 	version       uint64
-	root          *TreeNode // The working root node
-	lastSavedRoot *TreeNode // The most recently saved root node
+	recentVersion uint64
+	// versionRetention is the number of versions kept by Commit, 0 keeps all.
+	versionRetention uint64
+	root             Node // The working root node
+	lastSavedRoot    Node // The most recently saved root node
+	newNode          func() Node
+	nodeArena        *nodeArena
+
+	lock        sync.RWMutex
+	prefixLocks [16]sync.Mutex
+	// rehashPending is set by Set, atomically, until the staged leaves are
+	// hashed up to the root.
+	rehashPending int32
+
+	proofsBefore  []Proof
+	db            TreeDB
+	hasher        *Hasher
+	maxDepth      uint8
+	nilHashes     [][]byte
+	nilLadderSeed []byte
+	hashCache     *hashCache
+	// nilShortCircuit skips hashing two empty children, see hashChildrenAt.
+	nilShortCircuit bool
+	metrics         *metrics
+	clock           Clock
+	integrityKey    []byte
+	sparseNodes     bool
+	storageChecksum bool
+	latestOnly      bool
+	keyBoundLeaves  bool
+	maxNodeDeltas   int
+	encodeWorkers   int
+	emptyLeaf       []byte
+	frozen          bool
+	keyFilter       *bloomFilter
+
+	proofSelfCheck   bool
+	evictAfterProof  bool
+	residentCap      uint64
+	evictionCallback EvictionCallback
+
+	payloadLock     sync.Mutex
+	pendingPayloads map[string][]byte
+	maxInlineValue  int
+
+	gcStrategy  GCStrategy
+	accessClock uint64
+
+	rootSigner   RootSigner
+	commitHook   CommitHook
+	rollbackHook CommitHook
+
+	recoveryCallback RecoveryCallback
 
-	proofsBefore []Proof
-	db           TreeDB
+	// rollbackTargets are the versions rolled back to, in order, so that
+	// snapshots can tell whether their version was dropped.
+	rollbackTargets []Version
+
+	pathEncodingID string
+	pathEncoding   PathEncoding
+
+	walLock sync.Mutex
+	wal     WAL
+
+	journalLock sync.Mutex
+	journal     map[string]struct{}
+	// tombstones are the staged keys deleted by Delete. Without a db the
+	// committed ones are kept in committedTombstones.
+	tombstones          map[string]struct{}
+	committedTombstones map[string][]byte
+	// keyHashes are the key hashes staged by SetKey, by slot. Without a db
+	// the committed ones are kept in committedKeyHashes.
+	keyHashes          map[string][]byte
+	committedKeyHashes map[string][]byte
+
+	shadowHasher *Hasher
+	shadowLock   sync.Mutex
+	shadowLeaves map[string][]byte
 }
 
+// Get reads key as committed at version, or from the working tree, staged
+// Sets included, when version is nil. Nodes of committed versions are
+// immutable in storage, so reads at a version go straight to the db and are
+// not affected by concurrent commits; only reads of the working tree take
+// the tree lock.
 func (tree *BASSparseMerkleTree) Get(key []byte, version *Version) ([]byte, error) {
-	return nil, nil
+	if tree.keyFilter != nil && !tree.keyFilter.mayContain(key) {
+		return nil, nil
+	}
+	path := tree.path(key)
+	if len(path)*8 < int(tree.maxDepth) {
+		return nil, ErrInvalidKey
+	}
+	if version != nil {
+		tree.lock.RLock()
+		err := tree.checkReadVersion(*version)
+		tree.lock.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		return tree.getFromStorage(key, *version)
+	}
+	val, err := tree.getWorking(key)
+	tree.enforceResidentCap()
+	return val, err
+}
+
+// getWorking reads key from the working tree.
+func (tree *BASSparseMerkleTree) getWorking(key []byte) ([]byte, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	prefixLock := tree.prefixLock(key)
+	prefixLock.Lock()
+	defer prefixLock.Unlock()
+	return tree.getLatest(key)
+}
+
+// checkReadVersion checks that version is committed and its history
+// retained. The caller holds the tree lock.
+func (tree *BASSparseMerkleTree) checkReadVersion(version Version) error {
+	switch {
+	case uint64(version) > tree.version:
+		return ErrVersionTooHigh
+	case tree.latestOnly && uint64(version) < tree.version:
+		return ErrHistoryDisabled
+	case uint64(version) < tree.recentVersion:
+		return ErrVersionTooOld
+	}
+	return nil
+}
+
+// getLatest reads key from the working tree. The caller holds the tree lock
+// and the prefix lock of key.
+func (tree *BASSparseMerkleTree) getLatest(key []byte) ([]byte, error) {
+	return tree.leafValue(tree.walk(tree.path(key), nil, nil))
 }
 
-func (tree *BASSparseMerkleTree) Set(key, val []byte) {
+// leafValue maps the empty leaf returned by walk to the nil value of a key
+// that holds none.
+func (tree *BASSparseMerkleTree) leafValue(leaf []byte, err error) ([]byte, error) {
+	if err != nil || bytes.Equal(leaf, tree.nilHashes[tree.maxDepth]) {
+		return nil, err
+	}
+	return leaf, nil
 }
 
+// getFromStorage reads key at a committed version from the db only, or
+// from the history of the resident nodes without a db.
+func (tree *BASSparseMerkleTree) getFromStorage(key []byte, version Version) ([]byte, error) {
+	if tree.db != nil {
+		return tree.leafValue(tree.storageWalk(tree.path(key), version, nil))
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	prefixLock := tree.prefixLock(key)
+	prefixLock.Lock()
+	defer prefixLock.Unlock()
+	return tree.leafValue(tree.walk(tree.path(key), &version, nil))
+}
+
+// walk follows path down the resident tree, loading it as needed, and
+// returns its leaf, the empty
+// leaf if the path ends in an empty subtree. It reads the hashes committed
+// at version, or the working hashes when version is nil. If siblings is not
+// nil it receives the proof siblings of path, leaf level first, with empty
+// subtrees as empty slices. The caller holds the tree lock and the prefix
+// lock of path.
+func (tree *BASSparseMerkleTree) walk(path []byte, version *Version, siblings [][]byte) ([]byte, error) {
+	node := tree.rootNode()
+	for depth := uint8(0); depth < tree.maxDepth; depth++ {
+		tree.touch(node)
+		if err := tree.loadChildren(node, path); err != nil {
+			return nil, err
+		}
+		next, sibling := node.LeftChild, node.RightChild
+		if pathBit(path, depth) {
+			next, sibling = sibling, next
+		}
+		if siblings != nil {
+			siblings[tree.maxDepth-1-depth] = tree.proofSibling(fullNode(sibling), version)
+		}
+		node = fullNode(next)
+		// The working hash of a node is not updated until the next rehash,
+		// so in the working tree only a missing node ends the walk early.
+		if _, ok := tree.hashOf(node, version); !ok && (node == nil || version != nil) {
+			for d := depth + 1; siblings != nil && d < tree.maxDepth; d++ {
+				siblings[tree.maxDepth-1-d] = []byte{}
+			}
+			return tree.nilHashes[tree.maxDepth], nil
+		}
+	}
+	hash, _ := tree.hashOf(node, version)
+	return hash, nil
+}
+
+// hashOf returns the hash of node at version, or its working hash when
+// version is nil, and false if the subtree is empty.
+func (tree *BASSparseMerkleTree) hashOf(node *FullTreeNode, version *Version) ([]byte, bool) {
+	if node == nil {
+		return nil, false
+	}
+	if version == nil {
+		return node.LatestHash, !node.Empty
+	}
+	hash, ok := hashAt(node.Versions, *version)
+	return hash, ok && !bytes.Equal(hash, tree.nilHashes[node.Depth])
+}
+
+// proofSibling returns the proof entry of a sibling: its hash, or an empty
+// slice for an empty subtree.
+func (tree *BASSparseMerkleTree) proofSibling(node *FullTreeNode, version *Version) []byte {
+	if hash, ok := tree.hashOf(node, version); ok {
+		return hash
+	}
+	return []byte{}
+}
+
+// pathBit reports whether path branches right at depth.
+func pathBit(path []byte, depth uint8) bool {
+	return path[depth/8]&(0x80>>(depth%8)) != 0
+}
+
+func (tree *BASSparseMerkleTree) rootNode() *FullTreeNode {
+	return fullNode(tree.root)
+}
+
+// initRoot replaces the working tree with an empty one. The nodes above
+// prefixLockDepth are allocated up front, so concurrent Sets never add them.
+func (tree *BASSparseMerkleTree) initRoot() {
+	var grow func(depth uint8) *FullTreeNode
+	grow = func(depth uint8) *FullTreeNode {
+		node := tree.newTreeNode(depth)
+		if depth < prefixLockDepth && depth < tree.maxDepth {
+			node.LeftChild, node.RightChild = grow(depth+1), grow(depth+1)
+		}
+		return node
+	}
+	tree.root = grow(0)
+}
+
+// newTreeNode allocates an empty resident node at depth.
+func (tree *BASSparseMerkleTree) newTreeNode(depth uint8) *FullTreeNode {
+	node, ok := tree.allocNode().(*FullTreeNode)
+	if !ok || node == nil {
+		node = &FullTreeNode{}
+	}
+	node.Depth = depth
+	tree.setHash(node, tree.nilHashes[depth])
+	return node
+}
+
+// setHash sets the working hash of node.
+func (tree *BASSparseMerkleTree) setHash(node *FullTreeNode, hash []byte) {
+	node.LatestHash = hash
+	node.Empty = bytes.Equal(hash, tree.nilHashes[node.Depth])
+}
+
+// childHash returns the working hash of a child at depth, the nil hash for
+// an empty subtree.
+func (tree *BASSparseMerkleTree) childHash(node *FullTreeNode, depth uint8) []byte {
+	if node == nil {
+		return tree.nilHashes[depth]
+	}
+	return node.LatestHash
+}
+
+// setLeaf stages leaf on path. Only the nodes below prefixLockDepth on path
+// are written; they are marked dirty and stale for rehash. The caller holds
+// the tree lock and the prefix lock of path.
+func (tree *BASSparseMerkleTree) setLeaf(path, leaf []byte) error {
+	node := tree.rootNode()
+	for depth := uint8(0); depth < tree.maxDepth; depth++ {
+		tree.touch(node)
+		if err := tree.loadChildren(node, path); err != nil {
+			return err
+		}
+		child := &node.LeftChild
+		if pathBit(path, depth) {
+			child = &node.RightChild
+		}
+		next := fullNode(*child)
+		if next == nil {
+			next = tree.newTreeNode(depth + 1)
+			*child = next
+		}
+		node = next
+		if node.Depth >= prefixLockDepth {
+			node.Dirty, node.stale = true, true
+			tree.setTemporary(node, false)
+		}
+	}
+	tree.setHash(node, append([]byte{}, leaf...))
+	atomic.StoreInt32(&tree.rehashPending, 1)
+	return nil
+}
+
+// workingRoot returns the root of the working tree, first hashing up the
+// leaves staged since the last call. The caller holds the tree lock
+// exclusively.
+func (tree *BASSparseMerkleTree) workingRoot() []byte {
+	root := tree.rootNode()
+	if atomic.CompareAndSwapInt32(&tree.rehashPending, 1, 0) {
+		tree.rehash(root)
+	}
+	return root.LatestHash
+}
+
+// rehash recomputes the hashes of the stale nodes below node, bottom-up,
+// and reports whether the hash of node was recomputed. The nodes above
+// prefixLockDepth are never marked by Set, so they are always visited and
+// marked dirty here when a child changed.
+func (tree *BASSparseMerkleTree) rehash(node *FullTreeNode) bool {
+	if node.Depth >= prefixLockDepth && !node.stale {
+		return false
+	}
+	node.stale = false
+	if node.Depth == tree.maxDepth {
+		return true
+	}
+	left, right := fullNode(node.LeftChild), fullNode(node.RightChild)
+	leftChanged := left != nil && tree.rehash(left)
+	rightChanged := right != nil && tree.rehash(right)
+	if !leftChanged && !rightChanged {
+		return false
+	}
+	tree.setHash(node, tree.hashChildrenAt(int(node.Depth),
+		tree.childHash(left, node.Depth+1), tree.childHash(right, node.Depth+1)))
+	node.Dirty = true
+	return true
+}
+
+// discardStaged reverts the working tree to the latest version: staged
+// leaves are dropped, nodes added since are unlinked and the journal is
+// cleared. The caller holds the tree lock exclusively.
+func (tree *BASSparseMerkleTree) discardStaged() {
+	tree.discard(tree.rootNode())
+	atomic.StoreInt32(&tree.rehashPending, 0)
+	tree.clearJournal()
+}
+
+// discard reverts the dirty nodes below node to their latest version and
+// reports whether node itself did not exist then and can be unlinked.
+func (tree *BASSparseMerkleTree) discard(node *FullTreeNode) bool {
+	if node.Depth >= prefixLockDepth && !node.Dirty {
+		return false
+	}
+	for _, child := range []*TreeNode{&node.LeftChild, &node.RightChild} {
+		if full := fullNode(*child); full != nil && tree.discard(full) {
+			tree.releaseNodes(full)
+			*child = nil
+		}
+	}
+	node.Dirty, node.stale = false, false
+	if n := len(node.Versions); n > 0 {
+		tree.setHash(node, node.Versions[n-1].Hash)
+		return false
+	}
+	tree.setHash(node, tree.nilHashes[node.Depth])
+	return node.Depth > prefixLockDepth
+}
+
+// walkDirty calls fn for every dirty node with its path, children first.
+func (tree *BASSparseMerkleTree) walkDirty(fn func(node *FullTreeNode, path []byte)) {
+	path := make([]byte, (int(tree.maxDepth)+7)/8)
+	var walk func(node *FullTreeNode)
+	walk = func(node *FullTreeNode) {
+		if node == nil || !node.Dirty {
+			return
+		}
+		if node.Depth < tree.maxDepth {
+			walk(fullNode(node.LeftChild))
+			path[node.Depth/8] |= 0x80 >> (node.Depth % 8)
+			walk(fullNode(node.RightChild))
+			path[node.Depth/8] &^= 0x80 >> (node.Depth % 8)
+		}
+		fn(node, path)
+	}
+	walk(tree.rootNode())
+}
+
+// stageVersion records the working hash of every dirty node as its hash at
+// version. The root is recorded at every version, so it holds the roots of
+// all retained versions.
+func (tree *BASSparseMerkleTree) stageVersion(version Version) {
+	tree.walkDirty(func(node *FullTreeNode, path []byte) {
+		node.Versions = append(node.Versions, &VersionInfo{Ver: version, Hash: node.LatestHash})
+		tree.countVersions(node, 1)
+	})
+	if root := tree.rootNode(); !root.Dirty {
+		root.Versions = append(root.Versions, &VersionInfo{Ver: version, Hash: root.LatestHash})
+		tree.countVersions(root, 1)
+	}
+}
+
+// unstageVersion undoes stageVersion after the commit failed.
+func (tree *BASSparseMerkleTree) unstageVersion(version Version) {
+	unstage := func(node *FullTreeNode, path []byte) {
+		if n := len(node.Versions); n > 0 && node.Versions[n-1].Ver == version {
+			node.Versions = node.Versions[:n-1]
+			tree.countVersions(node, -1)
+		}
+	}
+	tree.walkDirty(unstage)
+	if root := tree.rootNode(); !root.Dirty {
+		unstage(root, nil)
+	}
+}
+
+// finishVersion drops the history older than recentVersion from the nodes
+// committed by stageVersion and marks them clean.
+func (tree *BASSparseMerkleTree) finishVersion(recentVersion Version) {
+	prune := func(node *FullTreeNode, path []byte) {
+		n := len(node.Versions)
+		node.Prune(recentVersion)
+		tree.countVersions(node, len(node.Versions)-n)
+	}
+	tree.walkDirty(prune)
+	prune(tree.rootNode(), nil)
+	var clean func(node *FullTreeNode)
+	clean = func(node *FullTreeNode) {
+		if node == nil || !node.Dirty {
+			return
+		}
+		node.Dirty = false
+		clean(fullNode(node.LeftChild))
+		clean(fullNode(node.RightChild))
+	}
+	clean(tree.rootNode())
+}
+
+// rootAt returns the root committed at version, the empty root before the
+// first commit. The caller holds the tree lock.
+func (tree *BASSparseMerkleTree) rootAt(version Version) []byte {
+	if hash, ok := hashAt(tree.rootNode().Versions, version); ok {
+		return hash
+	}
+	return tree.nilHashes[0]
+}
+
+// Set may be called concurrently for keys under different top nibbles: each
+// writer holds the tree lock shared and the lock of its key's top nibble.
+// Shared ancestors are only rehashed by Commit under the exclusive tree lock,
+// so the committed root equals that of applying the same writes serially.
+// The leaf must be as long as the hasher output, as it is the sibling of its
+// neighbour in proofs; other lengths fail with ErrInvalidLeafLength.
+func (tree *BASSparseMerkleTree) Set(key, val []byte) error {
+	leaf, err := tree.checkedLeaf(key, val)
+	if err != nil {
+		return err
+	}
+	return tree.set(key, leaf, tree.wal != nil, false)
+}
+
+// checkedLeaf returns the leaf Set stores for val under key, failing for
+// leaves Set rejects.
+func (tree *BASSparseMerkleTree) checkedLeaf(key, val []byte) ([]byte, error) {
+	leaf := tree.leafOf(key, val)
+	if len(leaf) != tree.hasher.Size() {
+		return nil, ErrInvalidLeafLength
+	}
+	if tree.emptyLeaf != nil && bytes.Equal(leaf, tree.emptyLeaf) {
+		return nil, ErrEmptyLeafValue
+	}
+	return leaf, nil
+}
+
+// set is Set with the WAL append made optional for replaying the WAL itself.
+// deleted stages a tombstone for key, as Delete does.
+func (tree *BASSparseMerkleTree) set(key, val []byte, logged, deleted bool) error {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.setLocked(key, val, logged, deleted)
+}
+
+// setLocked is set for a caller holding the tree lock, shared or
+// exclusively.
+func (tree *BASSparseMerkleTree) setLocked(key, val []byte, logged, deleted bool) error {
+	if err := tree.checkDepth(); err != nil {
+		return err
+	}
+	path := tree.path(key)
+	if len(path)*8 < int(tree.maxDepth) {
+		return ErrInvalidKey
+	}
+	if tree.frozen {
+		return ErrTreeFrozen
+	}
+	prefixLock := tree.prefixLock(key)
+	prefixLock.Lock()
+	defer prefixLock.Unlock()
+	// Rewriting the current value changes no hash, so nothing is staged.
+	current, err := tree.walk(path, nil, nil)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(current, val) {
+		return nil
+	}
+	if logged {
+		if err := tree.appendWAL(key, val); err != nil {
+			return err
+		}
+	}
+	if err := tree.setLeaf(path, val); err != nil {
+		return err
+	}
+	if tree.keyFilter != nil {
+		tree.keyFilter.add(key)
+	}
+	if tree.shadowHasher != nil {
+		tree.recordShadow(key, val)
+	}
+	tree.journalKey(key)
+	tree.stageTombstone(key, deleted)
+	return nil
+}
+
+// checkDepth guards the walks that step through the tree four levels (one
+// stored node) at a time against a depth they would silently truncate, e.g.
+// one restored from a corrupted config record.
+func (tree *BASSparseMerkleTree) checkDepth() error {
+	if tree.maxDepth%4 != 0 {
+		return ErrInvalidDepth
+	}
+	return nil
+}
+
+func (tree *BASSparseMerkleTree) prefixLock(key []byte) *sync.Mutex {
+	path := tree.path(key)
+	if len(path) == 0 {
+		return &tree.prefixLocks[0]
+	}
+	return &tree.prefixLocks[path[0]>>4]
+}
+
+// Prefetch loads the paths of keys into the resident tree so that following
+// Get and GetProof calls for them are served from memory. Duplicate keys are
+// loaded once.
+func (tree *BASSparseMerkleTree) Prefetch(keys [][]byte) error {
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[string(key)]; ok {
+			continue
+		}
+		seen[string(key)] = struct{}{}
+		if _, err := tree.Get(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetPreimage sets the leaf of key to the tree hasher's digest of preimage,
+// so every producer derives the leaf the same way.
+func (tree *BASSparseMerkleTree) SetPreimage(key, preimage []byte) error {
+	return tree.Set(key, tree.hasher.Hash(preimage))
+}
+
+// SetEmpty records key as explicitly set to the empty value. A key is in one
+// of three states:
+//   - absent: its leaf is the nil hash and it was never set;
+//   - set-empty: its leaf is SetEmptyLeaf(), which proves the key was touched;
+//   - set-value: its leaf is the value passed to Set.
+func (tree *BASSparseMerkleTree) SetEmpty(key []byte) error {
+	return tree.Set(key, tree.SetEmptyLeaf())
+}
+
+// SetEmptyLeaf returns the leaf of keys set with SetEmpty.
+func (tree *BASSparseMerkleTree) SetEmptyLeaf() []byte {
+	return tree.hasher.Hash([]byte(setEmptyLeafTag))
+}
+
+// IsEmpty reports whether key holds no value in the working tree.
 func (tree *BASSparseMerkleTree) IsEmpty(key []byte) bool {
-	return false
+	val, err := tree.Get(key, nil)
+	return err == nil && val == nil
 }
 
+// Root returns the root of the working tree, staged Sets included.
 func (tree *BASSparseMerkleTree) Root() []byte {
-	return nil
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.workingRoot()
+}
+
+// PendingRoot returns the root of the staged state and the version it would
+// be committed as, without side effects.
+func (tree *BASSparseMerkleTree) PendingRoot() ([]byte, Version, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.version == math.MaxUint64 {
+		return nil, 0, ErrVersionOverflow
+	}
+	return tree.workingRoot(), Version(tree.version) + 1, nil
+}
+
+// CommittedRoot returns the root of the last committed version, ignoring
+// any staged sets.
+func (tree *BASSparseMerkleTree) CommittedRoot() []byte {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.rootAt(Version(tree.version))
+}
+
+// CommittedProof returns the proof of key against CommittedRoot, ignoring
+// any staged sets, so committed proofs can be served while the next batch
+// is being staged.
+func (tree *BASSparseMerkleTree) CommittedProof(key []byte) (Proof, error) {
+	return tree.committedProof(key, tree.LatestVersion())
+}
+
+// committedProof is GetProof of key as committed at version, read as
+// getFromStorage reads, so staged leaves never enter it.
+func (tree *BASSparseMerkleTree) committedProof(key []byte, version Version) (Proof, error) {
+	if err := tree.checkDepth(); err != nil {
+		return Proof{}, err
+	}
+	path := tree.path(key)
+	helpers, ok := proofHelpers(path, int(tree.maxDepth))
+	if !ok {
+		return Proof{}, ErrInvalidKey
+	}
+	proof, err := tree.storageProof(key, path, version)
+	if err != nil {
+		return Proof{}, err
+	}
+	proof.ProofHelper = helpers
+	return proof, nil
 }
 
 func (tree *BASSparseMerkleTree) GetProof(key []byte, version *Version) (Proof, error) {
-	return Proof{}, nil
+	if err := tree.checkDepth(); err != nil {
+		return Proof{}, err
+	}
+	path := tree.path(key)
+	helpers, ok := proofHelpers(path, int(tree.maxDepth))
+	if !ok {
+		return Proof{}, ErrInvalidKey
+	}
+	proof, err := tree.proofOf(key, path, version)
+	if err != nil {
+		return Proof{}, err
+	}
+	proof.ProofHelper = helpers
+	if tree.proofSelfCheck && version == nil && !tree.VerifyProof(proof) {
+		return Proof{}, ErrProofSelfCheckFailed
+	}
+	if tree.evictAfterProof {
+		tree.evictTemporary()
+	}
+	tree.enforceResidentCap()
+	atomic.AddUint64(&tree.metrics.proofsServed, 1)
+	return proof, nil
 }
 
+// proofOf reads the leaf, siblings and root of the proof of path as
+// committed at version, or from the working tree when version is nil. A
+// working proof is read under the prefix lock of key alone unless staged
+// leaves still have to be hashed up, which takes the exclusive tree lock.
+func (tree *BASSparseMerkleTree) proofOf(key, path []byte, version *Version) (Proof, error) {
+	proof := Proof{Key: key, MerkleProof: make([][]byte, tree.maxDepth)}
+	tree.lock.RLock()
+	if version != nil {
+		err := tree.checkReadVersion(*version)
+		tree.lock.RUnlock()
+		if err != nil {
+			return Proof{}, err
+		}
+		return tree.storageProof(key, path, *version)
+	}
+	latest := Version(tree.version)
+	prefixLock := tree.prefixLock(key)
+	prefixLock.Lock()
+	if atomic.LoadInt32(&tree.rehashPending) != 0 {
+		prefixLock.Unlock()
+		tree.lock.RUnlock()
+		tree.lock.Lock()
+		defer tree.lock.Unlock()
+		tree.workingRoot()
+		latest = Version(tree.version)
+	} else {
+		defer tree.lock.RUnlock()
+		defer prefixLock.Unlock()
+	}
+	leaf, err := tree.walk(path, nil, proof.MerkleProof)
+	if err != nil {
+		return Proof{}, err
+	}
+	proof.Version, proof.Leaf, proof.Root = latest, leaf, tree.rootNode().LatestHash
+	return proof, nil
+}
+
+// GetKeyVersionProofs returns a proof of key at each of versions, e.g. to
+// show the history of a key in a dispute. Versions pruned below the recent
+// version fail with ErrVersionTooOld.
+func (tree *BASSparseMerkleTree) GetKeyVersionProofs(key []byte, versions []Version) ([]Proof, error) {
+	tree.lock.RLock()
+	recent := Version(tree.recentVersion)
+	tree.lock.RUnlock()
+	proofs := make([]Proof, len(versions))
+	for i := range versions {
+		if versions[i] < recent {
+			return nil, ErrVersionTooOld
+		}
+		proof, err := tree.GetProof(key, &versions[i])
+		if err != nil {
+			return nil, err
+		}
+		proofs[i] = proof
+	}
+	return proofs, nil
+}
+
+// GetRawProof returns only the sibling hashes of the proof of key, the
+// proof format used before Proof carried its key, version and root.
+func (tree *BASSparseMerkleTree) GetRawProof(key []byte, version *Version) ([][]byte, error) {
+	proof, err := tree.GetProof(key, version)
+	if err != nil {
+		return nil, err
+	}
+	return proof.MerkleProof, nil
+}
+
+// StreamProof passes the siblings of the latest proof of key to fn one level
+// at a time, bottom-up: level 0 is the sibling of the leaf, in the same order
+// as Proof.MerkleProof. isNil reports a sibling that is an empty subtree.
+func (tree *BASSparseMerkleTree) StreamProof(key []byte, fn func(level int, sibling []byte, isNil bool) error) error {
+	proof, err := tree.GetProof(key, nil)
+	if err != nil {
+		return err
+	}
+	for i, sibling := range proof.MerkleProof {
+		depth := len(proof.MerkleProof) - i
+		isNil := len(sibling) == 0 || (depth < len(tree.nilHashes) && bytes.Equal(sibling, tree.nilHashes[depth]))
+		if err := fn(i, sibling, isNil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetProofVerbose returns the latest proof of key together with the hash
+// computed at every level, from the leaf up to the root. A ProofHelper of 0
+// means the path node is the left child at that level.
+func (tree *BASSparseMerkleTree) GetProofVerbose(key []byte) (Proof, []ProofStep, error) {
+	proof, err := tree.GetProof(key, nil)
+	if err != nil {
+		return Proof{}, nil, err
+	}
+	steps := make([]ProofStep, len(proof.MerkleProof))
+	tree.computeRoot(proof.Leaf, proof, func(i int, hash []byte) {
+		steps[i] = ProofStep{
+			Depth:      len(proof.MerkleProof) - i - 1,
+			NilSibling: len(proof.MerkleProof[i]) == 0,
+			Hash:       hash,
+		}
+	})
+	return proof, steps, nil
+}
+
+// computeRoot folds the siblings of proof into leaf, from the leaf level up,
+// and returns the resulting root. step, if not nil, is called with the hash
+// computed at every level.
+func (tree *BASSparseMerkleTree) computeRoot(leaf []byte, proof Proof, step func(i int, hash []byte)) []byte {
+	hash := leaf
+	for i, sibling := range proof.MerkleProof {
+		// An empty sibling stands for the empty subtree at its depth.
+		if depth := len(proof.MerkleProof) - i; len(sibling) == 0 && depth < len(tree.nilHashes) {
+			sibling = tree.nilHashes[depth]
+		}
+		depth := len(proof.MerkleProof) - 1 - i
+		if i < len(proof.ProofHelper) && proof.ProofHelper[i] != 0 {
+			hash = tree.hashChildrenAt(depth, sibling, hash)
+		} else {
+			hash = tree.hashChildrenAt(depth, hash, sibling)
+		}
+		if step != nil {
+			step(i, hash)
+		}
+	}
+	return hash
+}
+
+// RootWithLeaf returns the root the tree of proof would have if the leaf of
+// its key were candidateLeaf, e.g. to check a proposed state transition
+// without applying it. candidateLeaf is hashed up as given; under
+// WithKeyBoundLeaves it must already be bound to the key.
+func (tree *BASSparseMerkleTree) RootWithLeaf(proof Proof, candidateLeaf []byte) []byte {
+	return tree.computeRoot(candidateLeaf, proof, nil)
+}
+
+// VerifyValueProof verifies that value is stored under key in the tree with
+// the given root. The leaf is derived by hashing value, as SetPreimage does,
+// so the proof cannot be paired with a mismatching claimed value.
+func (tree *BASSparseMerkleTree) VerifyValueProof(key, value []byte, proof Proof, root []byte) bool {
+	proof.Key = key
+	proof.Leaf = tree.leafOf(key, tree.hasher.Hash(value))
+	proof.Root = root
+	return tree.VerifyProof(proof)
+}
+
+// proofMatchesKey checks that the helper bits of proof follow the path of
+// key: ProofHelper[i] is the branch bit at depth len(MerkleProof)-1-i.
+func (tree *BASSparseMerkleTree) proofMatchesKey(key []byte, proof Proof) bool {
+	helpers, ok := proofHelpers(tree.path(key), len(proof.ProofHelper))
+	if !ok {
+		return false
+	}
+	for i := range helpers {
+		if proof.ProofHelper[i] != helpers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// proofHelpers derives the ProofHelper of a proof depth levels deep for
+// path, in proof order: entry i is the branch bit at depth depth-1-i. It is
+// shared by GetProof and the verifiers so the two cannot disagree. ok is
+// false if path is shorter than depth bits.
+func proofHelpers(path []byte, depth int) (helpers []int, ok bool) {
+	if depth > len(path)*8 {
+		return nil, false
+	}
+	helpers = make([]int, depth)
+	for i := range helpers {
+		d := depth - 1 - i
+		helpers[i] = int(path[d/8]>>uint(7-d%8)) & 1
+	}
+	return helpers, true
+}
+
+// ProofCost estimates the cost of GetProof for key without generating the
+// proof: the number of stored nodes that would be read from the db and the
+// size of the proof in bytes. Each stored node packs four levels of the tree.
+func (tree *BASSparseMerkleTree) ProofCost(key []byte) (int, int, error) {
+	keys, err := tree.ProofReadSet(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	depth := int(tree.maxDepth)
+	proofBytes := depth*tree.hasher.Size() + depth
+	return len(keys), proofBytes, nil
+}
+
+// ProofReadSet returns the db keys of the stored nodes GetProof would read
+// for key, i.e. those on its path that are not resident, without reading
+// them.
+func (tree *BASSparseMerkleTree) ProofReadSet(key []byte) ([][]byte, error) {
+	if err := tree.checkDepth(); err != nil {
+		return nil, err
+	}
+	path := tree.path(key)
+	if len(path)*8 < int(tree.maxDepth) {
+		return nil, ErrInvalidKey
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	prefixLock := tree.prefixLock(key)
+	prefixLock.Lock()
+	defer prefixLock.Unlock()
+	var keys [][]byte
+	node := tree.rootNode()
+	for depth := uint8(0); depth < tree.maxDepth; depth += 4 {
+		if node == nil || tree.unloaded(node) {
+			// Nothing below a stored block is resident.
+			keys = append(keys, storageNodeKey(depth, path))
+			node = nil
+			continue
+		}
+		for d := depth; d < depth+4 && node != nil; d++ {
+			if pathBit(path, d) {
+				node = fullNode(node.RightChild)
+			} else {
+				node = fullNode(node.LeftChild)
+			}
+		}
+		if node == nil {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// VersionCount returns the number of versions retained for the leaf of
+// key, e.g. to find keys worth pruning more aggressively. The resident leaf
+// is used when loaded, the stored one otherwise; a key never set has none.
+func (tree *BASSparseMerkleTree) VersionCount(key []byte) (int, error) {
+	if err := tree.checkDepth(); err != nil {
+		return 0, err
+	}
+	path := tree.path(key)
+	if len(path)*8 < int(tree.maxDepth) {
+		return 0, ErrInvalidKey
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	prefixLock := tree.prefixLock(key)
+	prefixLock.Lock()
+	defer prefixLock.Unlock()
+	node := tree.rootNode()
+	for depth := uint8(0); depth < tree.maxDepth; depth++ {
+		if tree.unloaded(node) {
+			leaf, err := tree.storedLeaf(path, depth)
+			if err != nil || leaf == nil {
+				return 0, err
+			}
+			return len(leaf.Versions), nil
+		}
+		if pathBit(path, depth) {
+			node = fullNode(node.RightChild)
+		} else {
+			node = fullNode(node.LeftChild)
+		}
+		if node == nil {
+			return 0, nil
+		}
+	}
+	return len(node.Versions), nil
+}
+
+// VerifyKeyValueProof verifies that val was passed to Set for key in the
+// tree with the given root, recomputing the leaf from key and val under
+// WithKeyBoundLeaves.
+func (tree *BASSparseMerkleTree) VerifyKeyValueProof(key, val []byte, proof Proof, root []byte) bool {
+	proof.Key = key
+	proof.Leaf = tree.leafOf(key, val)
+	proof.Root = root
+	return tree.VerifyProof(proof)
+}
+
+// leafOf returns the leaf Set stores for val under key.
+func (tree *BASSparseMerkleTree) leafOf(key, val []byte) []byte {
+	if !tree.keyBoundLeaves {
+		return val
+	}
+	return tree.hasher.Hash(key, val)
+}
+
+// VerifyProof checks that proof is canonical, follows the path of its key
+// and folds its leaf into its root. It does not compare the root with the
+// tree's own; see VerifyProofs for that.
+func (tree *BASSparseMerkleTree) VerifyProof(proof Proof) bool {
+	return tree.CheckProof(proof) == nil
+}
+
+// CheckProof is VerifyProof with the reason for rejection: ErrMalformedProof
+// or ErrNonCanonicalProof for input that is not a well-formed proof, and
+// ErrProofMismatch for a well-formed proof that does not fold into its root.
+func (tree *BASSparseMerkleTree) CheckProof(proof Proof) error {
+	if err := tree.checkCanonicalProof(proof); err != nil {
+		return err
+	}
+	if !tree.proofMatchesKey(proof.Key, proof) ||
+		!bytes.Equal(tree.computeRoot(proof.Leaf, proof, nil), proof.Root) {
+		return ErrProofMismatch
+	}
+	return nil
+}
+
+// checkCanonicalProof rejects proofs that are padded, truncated or carry
+// siblings and helper bits of the wrong shape, so that no two encodings of
+// the same proof verify. An empty subtree must be given as an empty
+// sibling, as GetProof does, not spelled out as its nil hash.
+func (tree *BASSparseMerkleTree) checkCanonicalProof(proof Proof) error {
+	for i, sibling := range proof.MerkleProof {
+		if len(sibling) != 0 && len(sibling) != tree.hasher.Size() {
+			return ErrMalformedProof
+		}
+		if depth := len(proof.MerkleProof) - i; len(sibling) != 0 && depth < len(tree.nilHashes) &&
+			bytes.Equal(sibling, tree.nilHashes[depth]) {
+			return ErrNonCanonicalProof
+		}
+	}
+	if tree.maxDepth != 0 && len(proof.MerkleProof) != int(tree.maxDepth) {
+		return ErrNonCanonicalProof
+	}
+	if len(proof.ProofHelper) != len(proof.MerkleProof) {
+		return ErrNonCanonicalProof
+	}
+	for i := range proof.MerkleProof {
+		if proof.ProofHelper[i] != 0 && proof.ProofHelper[i] != 1 {
+			return ErrNonCanonicalProof
+		}
+	}
+	return nil
+}
+
+// VerifyProofs verifies that proofs[i] proves keys[i] against the current
+// root and returns the index of the first failing pair, or -1 if all of
+// them verify. A proof for another key than the one it is paired with
+// fails, so callers need not trust Proof.Key. Slices of different lengths
+// fail at index 0.
+func (tree *BASSparseMerkleTree) VerifyProofs(keys [][]byte, proofs []Proof) (bool, int) {
+	if len(keys) != len(proofs) {
+		return false, 0
+	}
+	root := tree.Root()
+	for i := range proofs {
+		if !bytes.Equal(proofs[i].Key, keys[i]) || !bytes.Equal(proofs[i].Root, root) ||
+			!tree.VerifyProof(proofs[i]) {
+			return false, i
+		}
+	}
+	return true, -1
+}
+
+// VerifySubtreeProof verifies the part of proof below the prefixBits-long
+// prefix against subtreeRoot, ignoring the levels above the shard boundary.
+// The path of key must start with the prefix.
+func (tree *BASSparseMerkleTree) VerifySubtreeProof(prefix []byte, prefixBits int, key []byte, proof Proof, subtreeRoot []byte) bool {
+	if prefixBits < 0 || prefixBits > int(tree.maxDepth) || prefixBits > len(prefix)*8 {
+		return false
+	}
+	if tree.checkCanonicalProof(proof) != nil || !tree.proofMatchesKey(key, proof) {
+		return false
+	}
+	path := tree.path(key)
+	for d := 0; d < prefixBits; d++ {
+		if pathBit(path, uint8(d)) != pathBit(prefix, uint8(d)) {
+			return false
+		}
+	}
+	// The hash after folding the siblings below the boundary is the root of
+	// the subtree at depth prefixBits.
+	below := int(tree.maxDepth) - prefixBits
+	hash := proof.Leaf
+	tree.computeRoot(proof.Leaf, proof, func(i int, h []byte) {
+		if i == below-1 {
+			hash = h
+		}
+	})
+	return bytes.Equal(hash, subtreeRoot)
+}
+
+// LatestVersion returns the last committed version. It takes the tree lock,
+// so callers holding it read tree.version directly.
 func (tree *BASSparseMerkleTree) LatestVersion() Version {
-	return 0
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return Version(tree.version)
+}
+
+// TreeConfig are the parameters a verifier needs to check proofs of a tree.
+type TreeConfig struct {
+	MaxDepth uint8
+	// NilHash is the empty leaf; NilHashes returns the hashes of every
+	// empty level.
+	NilHash  []byte
+	HasherID string
+	// NilLadderSeed is the WithNilLadderSeed value, nil if the ladder is
+	// seeded with the empty leaf.
+	NilLadderSeed []byte
+}
+
+// Config returns the parameters of the tree, e.g. to set up a standalone
+// verifier.
+func (tree *BASSparseMerkleTree) Config() TreeConfig {
+	return TreeConfig{
+		MaxDepth:      tree.maxDepth,
+		NilHash:       append([]byte{}, tree.nilHashes[tree.maxDepth]...),
+		HasherID:      tree.hasher.ID(),
+		NilLadderSeed: append([]byte(nil), tree.nilLadderSeed...),
+	}
+}
+
+// VerifyRootAtVersion reports whether root was the tree root at version.
+// Versions pruned below the recent version return ErrVersionTooOld and
+// versions not committed yet ErrVersionTooHigh.
+func (tree *BASSparseMerkleTree) VerifyRootAtVersion(version Version, root []byte) (bool, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if uint64(version) < tree.recentVersion {
+		return false, ErrVersionTooOld
+	}
+	if uint64(version) > tree.version {
+		return false, ErrVersionTooHigh
+	}
+	stored, err := tree.rootFromStorage(version)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(stored, root), nil
 }
 
 func (tree *BASSparseMerkleTree) Reset() error {
-	return nil
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.frozen {
+		return ErrTreeFrozen
+	}
+	tree.discardStaged()
+	return tree.dropFlushedState()
 }
 
 func (tree *BASSparseMerkleTree) Commit() (Version, error) {
-	return 0, nil
+	return tree.CommitWithContext(context.Background(), nil)
 }
 
 func (tree *BASSparseMerkleTree) Rollback(version Version) error {
+	return tree.RollbackWithContext(context.Background(), version, nil)
+}
+
+// CommitWithContext is Commit that can be aborted through ctx. A cancelled
+// commit leaves the tree at its previous version.
+func (tree *BASSparseMerkleTree) CommitWithContext(ctx context.Context, progress ProgressFunc) (Version, error) {
+	return tree.commit(ctx, commitParams{progress: progress})
+}
+
+// CommitAs is Commit that only succeeds if it creates version, for replaying
+// a log deterministically. A non-nil recentVersion overrides the one derived
+// from WithVersionRetention.
+func (tree *BASSparseMerkleTree) CommitAs(version Version, recentVersion *Version) (Version, error) {
+	return tree.commit(context.Background(), commitParams{
+		expectedVersion: &version,
+		recentVersion:   recentVersion,
+	})
+}
+
+// CommitWithAnnotations is Commit that also stores anns as the annotations
+// of the new version, readable with Annotations. Annotations are not part of
+// the root; they are written in the commit batch, rolled back with their
+// version and pruned below the recent version. They need a db.
+func (tree *BASSparseMerkleTree) CommitWithAnnotations(anns map[string][]byte, recentVersion *Version) (Version, error) {
+	if tree.db == nil {
+		return Version(tree.LatestVersion()), ErrDatabaseRequired
+	}
+	return tree.commit(context.Background(), commitParams{
+		recentVersion: recentVersion,
+		annotations:   anns,
+	})
+}
+
+// CommitExpectingRoot is Commit that only succeeds if the new root equals
+// expected, e.g. the root of the original run when replaying a log. On a
+// mismatch the staged state is discarded, as by Reset, and ErrRootMismatch
+// is returned with the tree at its previous version.
+func (tree *BASSparseMerkleTree) CommitExpectingRoot(expected []byte, recentVersion *Version) (Version, error) {
+	return tree.commit(context.Background(), commitParams{
+		expectedRoot:  expected,
+		recentVersion: recentVersion,
+	})
+}
+
+// commitParams are the optional arguments of commit.
+type commitParams struct {
+	progress        ProgressFunc
+	expectedVersion *Version
+	recentVersion   *Version
+	annotations     map[string][]byte
+	expectedRoot    []byte
+}
+
+func (tree *BASSparseMerkleTree) commit(ctx context.Context, params commitParams) (Version, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.frozen {
+		return Version(tree.version), ErrTreeFrozen
+	}
+	if err := ctx.Err(); err != nil {
+		return Version(tree.version), err
+	}
+	if tree.version == math.MaxUint64 {
+		return Version(tree.version), ErrVersionOverflow
+	}
+	newVersion := tree.version + 1
+	if params.expectedVersion != nil && uint64(*params.expectedVersion) != newVersion {
+		return Version(tree.version), ErrUnexpectedVersion
+	}
+	newRecentVersion := tree.recentVersion
+	if params.recentVersion != nil {
+		if uint64(*params.recentVersion) > newVersion {
+			return Version(tree.version), ErrInvalidRecentVersion
+		}
+		newRecentVersion = uint64(*params.recentVersion)
+	} else if tree.versionRetention > 0 && newVersion > tree.versionRetention {
+		newRecentVersion = newVersion - tree.versionRetention
+	}
+	if tree.latestOnly {
+		newRecentVersion = newVersion
+	}
+	root := tree.workingRoot()
+	if params.expectedRoot != nil && !bytes.Equal(root, params.expectedRoot) {
+		tree.discardStaged()
+		if err := tree.dropFlushedState(); err != nil {
+			return Version(tree.version), err
+		}
+		return Version(tree.version), ErrRootMismatch
+	}
+	if tree.db != nil {
+		marker := encodeCommitMarker(Version(newVersion), Version(newRecentVersion))
+		if err := tree.db.Set([]byte(commitInProgressKey), marker); err != nil {
+			return Version(tree.version), err
+		}
+	}
+	if tree.keyFilter != nil {
+		if err := tree.saveBloomFilter(); err != nil {
+			return Version(tree.version), err
+		}
+	}
+	if tree.rootSigner != nil {
+		if err := tree.signRoot(Version(newVersion), root); err != nil {
+			return Version(tree.version), err
+		}
+	}
+	tree.stageVersion(Version(newVersion))
+	if tree.db != nil {
+		if err := tree.writeCommit(Version(newVersion), Version(newRecentVersion), params.annotations); err != nil {
+			tree.unstageVersion(Version(newVersion))
+			return Version(tree.version), err
+		}
+	}
+	if tree.db == nil {
+		tree.keepKeyHashes()
+		tree.keepTombstones(Version(newVersion), root)
+	}
+	var changes []ChangedRoot
+	if tree.commitHook != nil {
+		changes = tree.changedRoots()
+	}
+	tree.finishVersion(Version(newRecentVersion))
+	tree.recentVersion = newRecentVersion
+	tree.version = newVersion
+	tree.clearJournal()
+	if tree.commitHook != nil {
+		tree.commitHook(Version(newVersion), changes)
+	}
+	// The version is committed whether or not the log can be emptied.
+	if tree.wal != nil {
+		if err := tree.truncateWAL(); err != nil {
+			return Version(newVersion), &ErrWALNotTruncated{Version: Version(newVersion), Err: err}
+		}
+	}
+	return Version(newVersion), nil
+}
+
+// writeCommit writes the nodes staged as version, the new latest and recent
+// version, the annotations of version, the staged tombstones, key hashes and
+// payloads and the removal of the flushed state and of the commit marker in
+// one batch, so a commit is durable exactly when the marker is gone.
+func (tree *BASSparseMerkleTree) writeCommit(version, recentVersion Version, anns map[string][]byte) error {
+	batch := tree.db.NewBatch()
+	if err := tree.writeNodes(batch, version, recentVersion); err != nil {
+		return err
+	}
+	if err := tree.writeAnnotations(batch, version, recentVersion, anns); err != nil {
+		return err
+	}
+	if err := tree.writeTombstones(batch, version, tree.rootNode().LatestHash); err != nil {
+		return err
+	}
+	if err := tree.writeKeyHashes(batch); err != nil {
+		return err
+	}
+	if err := tree.writePayloads(batch); err != nil {
+		return err
+	}
+	if err := batch.Delete([]byte(flushedStateKey)); err != nil {
+		return err
+	}
+	if err := batch.Delete([]byte(commitInProgressKey)); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// RollbackWithContext is Rollback that can be aborted through ctx. A
+// cancelled rollback leaves the tree in its pre-rollback state. Staged
+// changes are discarded, also when the rollback fails to be written.
+func (tree *BASSparseMerkleTree) RollbackWithContext(ctx context.Context, version Version, progress ProgressFunc) error {
+	if tree.latestOnly {
+		return ErrHistoryDisabled
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.frozen {
+		return ErrTreeFrozen
+	}
+	if uint64(version) < tree.recentVersion {
+		return &ErrRollbackTooOld{Version: version, RecentVersion: Version(tree.recentVersion)}
+	}
+	if uint64(version) > tree.version {
+		return ErrVersionTooHigh
+	}
+	nodes, err := tree.collectRollback(ctx, version, progress)
+	if err != nil {
+		return err
+	}
+	tree.discardStaged()
+	restore := tree.rollbackNodes(nodes, version)
+	if tree.db != nil {
+		if err := tree.writeRollback(version); err != nil {
+			restore()
+			return err
+		}
+	}
+	if tree.rollbackHook != nil {
+		tree.rollbackHook(version, tree.changedRoots())
+	}
+	tree.finishRollback()
+	tree.version = uint64(version)
+	tree.rollbackTargets = append(tree.rollbackTargets, version)
 	return nil
 }
+
+// ReplaceAll discards staged changes and commits kvs as the complete content
+// of the next version; keys absent from kvs are empty in that version.
+func (tree *BASSparseMerkleTree) ReplaceAll(kvs []KV) (Version, error) {
+	if err := tree.Reset(); err != nil {
+		return 0, err
+	}
+	if err := tree.clearLeaves(); err != nil {
+		return 0, err
+	}
+	for _, kv := range kvs {
+		if err := tree.Set(kv.Key, kv.Val); err != nil {
+			return 0, err
+		}
+	}
+	return tree.Commit()
+}
+
+// clearLeaves stages every leaf of the working tree as empty, loading the
+// stored blocks that are not resident.
+func (tree *BASSparseMerkleTree) clearLeaves() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	path := make([]byte, (int(tree.maxDepth)+7)/8)
+	var clear func(node *FullTreeNode) (bool, error)
+	clear = func(node *FullTreeNode) (bool, error) {
+		if node == nil || node.Empty {
+			return false, nil
+		}
+		if node.Depth == tree.maxDepth {
+			tree.setHash(node, tree.nilHashes[tree.maxDepth])
+		} else {
+			if err := tree.loadChildren(node, path); err != nil {
+				return false, err
+			}
+			left, err := clear(fullNode(node.LeftChild))
+			if err != nil {
+				return false, err
+			}
+			path[node.Depth/8] |= 0x80 >> (node.Depth % 8)
+			right, err := clear(fullNode(node.RightChild))
+			path[node.Depth/8] &^= 0x80 >> (node.Depth % 8)
+			if err != nil || (!left && !right) {
+				return false, err
+			}
+		}
+		node.Dirty, node.stale = true, true
+		tree.setTemporary(node, false)
+		return true, nil
+	}
+	// A failed load may leave some leaves cleared.
+	if cleared, err := clear(tree.rootNode()); cleared || err != nil {
+		atomic.StoreInt32(&tree.rehashPending, 1)
+		return err
+	}
+	return nil
+}
+
+// storageProof reads the proof of path at a committed version as
+// getFromStorage reads its leaf. The helper bits are left to the caller.
+func (tree *BASSparseMerkleTree) storageProof(key, path []byte, version Version) (Proof, error) {
+	proof := Proof{Key: key, Version: version, MerkleProof: make([][]byte, tree.maxDepth)}
+	var err error
+	if tree.db != nil {
+		if proof.Root, err = tree.rootFromStorage(version); err != nil {
+			return Proof{}, err
+		}
+		proof.Leaf, err = tree.storageWalk(path, version, proof.MerkleProof)
+	} else {
+		tree.lock.RLock()
+		defer tree.lock.RUnlock()
+		prefixLock := tree.prefixLock(key)
+		prefixLock.Lock()
+		defer prefixLock.Unlock()
+		proof.Root = tree.rootAt(version)
+		proof.Leaf, err = tree.walk(path, &version, proof.MerkleProof)
+	}
+	if err != nil {
+		return Proof{}, err
+	}
+	return proof, nil
+}
+
+// HealthCheck reports whether the underlying TreeDB is reachable. A tree
+// without a db has no backend to lose and is always healthy.
+func (tree *BASSparseMerkleTree) HealthCheck(ctx context.Context) error {
+	if tree.db == nil {
+		return nil
+	}
+	return tree.db.Ping(ctx)
+}
+
+// EqualTo reports whether tree and other hold the same state. Trees with the
+// same config and version are equal exactly when their roots are, so no
+// subtree has to be compared.
+func (tree *BASSparseMerkleTree) EqualTo(other *BASSparseMerkleTree) (bool, error) {
+	if tree.maxDepth != other.maxDepth ||
+		tree.hasher.ID() != other.hasher.ID() ||
+		!bytes.Equal(tree.emptyLeaf, other.emptyLeaf) {
+		return false, nil
+	}
+	if tree.LatestVersion() != other.LatestVersion() {
+		return false, nil
+	}
+	return bytes.Equal(tree.Root(), other.Root()), nil
+}
+
+// CommitmentRoot binds the root to the structural parameters of the tree so
+// that a root of a differently configured tree cannot be substituted for
+// it. Verifiers reconstruct it as
+//
+//	hash(root || maxDepth as one byte || hasher ID || empty leaf)
+//
+// with the tree's hasher and the hasher ID as its UTF-8 bytes.
+func (tree *BASSparseMerkleTree) CommitmentRoot() []byte {
+	return tree.hasher.Hash(
+		tree.Root(),
+		[]byte{tree.maxDepth},
+		[]byte(tree.hasher.ID()),
+		tree.nilHashes[tree.maxDepth],
+	)
+}
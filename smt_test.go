@@ -0,0 +1,581 @@
+package bsmt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func newTestTree(t testing.TB, opts ...Option) *BASSparseMerkleTree {
+	t.Helper()
+	smt, err := NewBASSparseMerkleTree(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return smt.(*BASSparseMerkleTree)
+}
+
+// testKey spreads consecutive indexes over the whole key space.
+func testKey(i int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(i+1)*0x9e3779b97f4a7c15)
+	return key
+}
+
+func testValue(i int) []byte {
+	sum := sha256.Sum256([]byte{byte(i), byte(i >> 8), byte(i >> 16)})
+	return sum[:]
+}
+
+func TestConcurrentSet(t *testing.T) {
+	const keys, workers = 512, 16
+	opts := []Option{WithKeyBoundLeaves(), WithHashCache(64)}
+	concurrent := newTestTree(t, opts...)
+	serial := newTestTree(t, opts...)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < keys; i += workers {
+				if err := concurrent.Set(testKey(i), testValue(i)); err != nil {
+					t.Error(err)
+					return
+				}
+				proof, err := concurrent.GetProof(testKey(i), nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !concurrent.VerifyKeyValueProof(testKey(i), testValue(i), proof, proof.Root) {
+					t.Errorf("proof of key %d does not verify", i)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i := 0; i < keys; i++ {
+		if err := serial.Set(testKey(i), testValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(concurrent.Root(), serial.Root()) {
+		t.Fatal("concurrent Sets produced a different root than serial Sets")
+	}
+	if _, err := concurrent.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serial.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(concurrent.CommittedRoot(), serial.CommittedRoot()) {
+		t.Fatal("concurrent and serial commits differ")
+	}
+}
+
+func TestCommittedProofIgnoresStagedSets(t *testing.T) {
+	for _, db := range []TreeDB{nil, NewFastMemoryDB(0)} {
+		var opts []Option
+		if db != nil {
+			opts = append(opts, WithCustomDB(db))
+		}
+		tree := newTestTree(t, opts...)
+		for i := 0; i < 16; i++ {
+			if err := tree.Set(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Set(testKey(3), testValue(100)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Set(testKey(20), testValue(20)); err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range []int{3, 4, 20} {
+			proof, err := tree.CommittedProof(testKey(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(proof.Root, tree.CommittedRoot()) {
+				t.Fatalf("proof of key %d is not against CommittedRoot", i)
+			}
+			if err := tree.CheckProof(proof); err != nil {
+				t.Fatalf("proof of key %d: %v", i, err)
+			}
+			want := testValue(i)
+			if i == 20 {
+				want = tree.nilHashes[tree.maxDepth]
+			}
+			if !bytes.Equal(proof.Leaf, want) {
+				t.Fatalf("proof of key %d carries a staged leaf", i)
+			}
+		}
+	}
+}
+
+// fullRoot recomputes the working root of tree from its leaves by a full
+// traversal, loading every stored block, without trusting any internal
+// hash.
+func fullRoot(t *testing.T, tree *BASSparseMerkleTree) []byte {
+	t.Helper()
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	tree.workingRoot()
+	path := make([]byte, (int(tree.maxDepth)+7)/8)
+	var hash func(node *FullTreeNode, depth uint8) []byte
+	hash = func(node *FullTreeNode, depth uint8) []byte {
+		if node == nil {
+			return tree.nilHashes[depth]
+		}
+		if depth == tree.maxDepth {
+			return node.LatestHash
+		}
+		if err := tree.loadChildren(node, path); err != nil {
+			t.Fatal(err)
+		}
+		left := hash(fullNode(node.LeftChild), depth+1)
+		path[depth/8] |= 0x80 >> (depth % 8)
+		right := hash(fullNode(node.RightChild), depth+1)
+		path[depth/8] &^= 0x80 >> (depth % 8)
+		return tree.hasher.Hash(left, right)
+	}
+	return hash(tree.rootNode(), 0)
+}
+
+func TestNilLadderSeed(t *testing.T) {
+	seed := []byte("ladder seed")
+	tree := newTestTree(t, WithNilLadderSeed(seed))
+	if !bytes.Equal(tree.Config().NilLadderSeed, seed) {
+		t.Fatal("Config does not report the seed")
+	}
+	empty := tree.Root()
+	if err := tree.Set(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Set(testKey(2), testValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete(testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.Root(), empty) {
+		t.Fatal("deleting every key does not restore the empty root")
+	}
+
+	if err := tree.Set(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	var proofs []Proof
+	var keys [][]byte
+	for _, i := range []int{1, 3} {
+		proof, err := tree.GetProof(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tree.VerifyProof(proof) {
+			t.Fatalf("proof of key %d does not verify", i)
+		}
+		proofs = append(proofs, proof)
+		keys = append(keys, testKey(i))
+	}
+	bp, err := NewBatchProof(proofs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, i := VerifyMultiProofStandalone(tree.maxDepth, tree.hasher, tree.NilHashes(), tree.Root(), keys, bp); !ok {
+		t.Fatalf("standalone verification fails at key %d", i)
+	}
+}
+
+func TestCommitExpectingRootDiscardsStaged(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	roots := commitVersions(t, tree, 8)
+	for i := 0; i < 16; i++ {
+		if err := tree.Set(testKey(i), testValue(100+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := tree.CommitExpectingRoot(roots[1], nil); err != ErrRootMismatch || v != 3 {
+		t.Fatalf("got version %d, %v, want 3, ErrRootMismatch", v, err)
+	}
+	if !bytes.Equal(tree.Root(), roots[3]) || tree.PendingCount() != 0 {
+		t.Fatal("staged changes survive a root mismatch")
+	}
+	for i := 0; i < 16; i++ {
+		val, err := tree.Get(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := testValue(i * 3); i >= 8 && val != nil || i < 8 && !bytes.Equal(val, want) {
+			t.Fatalf("key %d reads a discarded value", i)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.CommittedRoot(), roots[3]) {
+		t.Fatal("the next commit includes discarded changes")
+	}
+}
+
+// BenchmarkCommitEncode measures a commit writing about 50k stored nodes,
+// encoded on one goroutine and on a pool.
+func BenchmarkCommitEncode(b *testing.B) {
+	kvs := testKVs(3860)
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tree := newTestTree(b, WithCustomDB(NewFastMemoryDB(0)), WithEncodeWorkers(workers))
+				for _, kv := range kvs {
+					if err := tree.Set(kv.Key, kv.Val); err != nil {
+						b.Fatal(err)
+					}
+				}
+				tree.Root()
+				b.StartTimer()
+				if _, err := tree.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitEncodeWorkers(t *testing.T) {
+	stored := func(workers int) map[string]string {
+		db := NewFastMemoryDB(0)
+		commitVersions(t, newTestTree(t, WithCustomDB(db), WithEncodeWorkers(workers)), 64)
+		kvs := make(map[string]string)
+		if err := db.Iterate(func(key, value []byte) error {
+			kvs[string(key)] = string(value)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return kvs
+	}
+	want, got := stored(1), stored(4)
+	if len(got) != len(want) {
+		t.Fatalf("%d records stored with 4 workers, %d with one", len(got), len(want))
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("record %x differs with 4 workers", key)
+		}
+	}
+}
+
+func TestHealthCheckWithoutDB(t *testing.T) {
+	for _, tree := range []*BASSparseMerkleTree{
+		newTestTree(t),
+		newTestTree(t, WithCustomDB(NewFastMemoryDB(0))),
+	} {
+		if err := tree.HealthCheck(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEqualToComparesHashers(t *testing.T) {
+	tree := newTestTree(t)
+	same := newTestTree(t)
+	named := newTestTree(t, WithHasher(NewHasher(sha256.New()).Named("sha256-v2")))
+	for _, other := range []*BASSparseMerkleTree{tree, same, named} {
+		if err := other.Set(testKey(1), testValue(1)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if equal, err := tree.EqualTo(same); err != nil || !equal {
+		t.Fatalf("got %v, %v for trees of the same hasher", equal, err)
+	}
+	if equal, err := tree.EqualTo(named); err != nil || equal {
+		t.Fatalf("got %v, %v for trees of differently named hashers", equal, err)
+	}
+}
+
+func TestCommitVersionOverflow(t *testing.T) {
+	tree := newTestTree(t)
+	tree.version = math.MaxUint64 - 1
+	if err := tree.Set(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	version, err := tree.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != math.MaxUint64 {
+		t.Fatalf("committed version %d, want the last one", version)
+	}
+	root := tree.Root()
+	if err := tree.Set(testKey(2), testValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := tree.Commit(); err != ErrVersionOverflow || version != math.MaxUint64 {
+		t.Fatalf("got %d, %v, want ErrVersionOverflow at the last version", version, err)
+	}
+	if tree.LatestVersion() != math.MaxUint64 || !bytes.Equal(tree.CommittedRoot(), root) {
+		t.Fatal("overflowing commit changed the committed state")
+	}
+	if tree.PendingCount() != 1 {
+		t.Fatal("overflowing commit dropped the staged Set")
+	}
+}
+
+func TestHashCacheKeepsRootsAndProofs(t *testing.T) {
+	// mirrored flips the first bit of testKey(i): under the same value both
+	// keys root identical subtrees, whose hashes the cache serves.
+	mirrored := func(i int) []byte {
+		key := testKey(i)
+		key[0] ^= 0x80
+		return key
+	}
+	plain := newTestTree(t)
+	// Holds the hashes of one commit but not of all of them, so both hits
+	// and evictions happen.
+	cached := newTestTree(t, WithHashCache(1024))
+	for v := 0; v < 8; v++ {
+		for _, tree := range []*BASSparseMerkleTree{plain, cached} {
+			for i := v * 8; i < (v+1)*8; i++ {
+				for _, key := range [][]byte{testKey(i), mirrored(i)} {
+					if err := tree.Set(key, testValue(i%3)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if _, err := tree.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(plain.Root(), cached.Root()) {
+			t.Fatalf("version %d: roots differ with the hash cache", v+1)
+		}
+	}
+	for i := 0; i < 8*8+8; i++ {
+		for _, key := range [][]byte{testKey(i), mirrored(i)} {
+			want, err := plain.GetProof(key, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cached.GetProof(key, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Leaf, want.Leaf) || len(got.MerkleProof) != len(want.MerkleProof) {
+				t.Fatalf("proofs of key %x differ with the hash cache", key)
+			}
+			for d := range want.MerkleProof {
+				if !bytes.Equal(got.MerkleProof[d], want.MerkleProof[d]) {
+					t.Fatalf("sibling %d of key %x differs with the hash cache", d, key)
+				}
+			}
+		}
+	}
+	if stats := cached.Stats(); stats.CacheHits == 0 || stats.CacheMisses <= 1024 {
+		t.Fatalf("got %d hits and %d misses, want both hits and evictions", stats.CacheHits, stats.CacheMisses)
+	}
+}
+
+func TestSetOfCurrentValueIsNoOp(t *testing.T) {
+	wal := &memWAL{}
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)), WithWAL(wal))
+	commitVersions(t, tree, 16)
+	if err := tree.Set(testKey(20), testValue(20)); err != nil {
+		t.Fatal(err)
+	}
+	root, version, pending, logged := tree.Root(), tree.LatestVersion(), tree.PendingCount(), wal.Len()
+	// Key 3 holds testValue(9) as committed, key 20 its staged value.
+	for _, kv := range []KV{{Key: testKey(3), Val: testValue(9)}, {Key: testKey(20), Val: testValue(20)}} {
+		if err := tree.Set(kv.Key, kv.Val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(tree.Root(), root) || tree.LatestVersion() != version {
+		t.Fatal("a Set of the current value changed the root or version")
+	}
+	if tree.PendingCount() != pending || wal.Len() != logged {
+		t.Fatal("a Set of the current value was staged")
+	}
+}
+
+// BenchmarkProofLatency measures GetProof of 64 keys of a stored tree on a
+// freshly opened tree, with and without the keys prefetched beforehand.
+func BenchmarkProofLatency(b *testing.B) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(b, WithCustomDB(db))
+	for _, kv := range testKVs(4096) {
+		if err := tree.Set(kv.Key, kv.Val); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	keys := make([][]byte, 64)
+	for i := range keys {
+		keys[i] = testKey(i * 61)
+	}
+	for _, prefetch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("prefetch=%v", prefetch), func(b *testing.B) {
+			var reads uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tree := newTestTree(b, WithCustomDB(db))
+				if prefetch {
+					if err := tree.Prefetch(keys); err != nil {
+						b.Fatal(err)
+					}
+				}
+				tree.ResetStats()
+				b.StartTimer()
+				for _, key := range keys {
+					if _, err := tree.GetProof(key, nil); err != nil {
+						b.Fatal(err)
+					}
+				}
+				reads += tree.Stats().DBReads
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(keys)), "ns/proof")
+			b.ReportMetric(float64(reads)/float64(b.N*len(keys)), "reads/proof")
+		})
+	}
+}
+
+func TestCommitmentRoot(t *testing.T) {
+	tree := newTestTree(t)
+	named := newTestTree(t, WithHasher(NewHasher(sha256.New()).Named("sha256-v2")))
+	for _, tree := range []*BASSparseMerkleTree{tree, named} {
+		commitVersions(t, tree, 16)
+	}
+	h := sha256.New()
+	h.Write(tree.Root())
+	h.Write([]byte{64})
+	h.Write([]byte(tree.hasher.ID()))
+	h.Write(make([]byte, 32))
+	if !bytes.Equal(tree.CommitmentRoot(), h.Sum(nil)) {
+		t.Fatal("CommitmentRoot differs from its documented reconstruction")
+	}
+	// The same leaves under the same hash function give the same root, but
+	// the hasher ID is bound into the commitment.
+	if !bytes.Equal(tree.Root(), named.Root()) {
+		t.Fatal("renaming the hasher changed the root")
+	}
+	if bytes.Equal(tree.CommitmentRoot(), named.CommitmentRoot()) {
+		t.Fatal("trees of differently named hashers share a commitment root")
+	}
+	commitment := tree.CommitmentRoot()
+	if err := tree.Set(testKey(1), testValue(100)); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(tree.CommitmentRoot(), commitment) {
+		t.Fatal("CommitmentRoot does not follow the root")
+	}
+}
+
+func TestPendingAndCommittedRoot(t *testing.T) {
+	for _, db := range []TreeDB{nil, NewFastMemoryDB(0)} {
+		var opts []Option
+		if db != nil {
+			opts = append(opts, WithCustomDB(db))
+		}
+		tree := newTestTree(t, opts...)
+		roots := commitVersions(t, tree, 16)
+		if root, version, err := tree.PendingRoot(); err != nil || !bytes.Equal(root, roots[3]) || version != 4 {
+			t.Fatalf("got %x, %d, %v with nothing staged, want the committed root as version 4", root, version, err)
+		}
+		for i := 10; i < 20; i++ {
+			if err := tree.Set(testKey(i), testValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pending, version, err := tree.PendingRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 4 || !bytes.Equal(pending, tree.Root()) || bytes.Equal(pending, roots[3]) {
+			t.Fatal("PendingRoot is not the staged root as version 4")
+		}
+		if !bytes.Equal(tree.CommittedRoot(), roots[3]) || tree.PendingCount() != 10 || tree.LatestVersion() != 3 {
+			t.Fatal("staging or PendingRoot changed the committed state")
+		}
+		if v, err := tree.Commit(); err != nil || v != version {
+			t.Fatalf("committed version %d, %v, want the predicted %d", v, err, version)
+		}
+		if !bytes.Equal(tree.CommittedRoot(), pending) {
+			t.Fatal("the committed root differs from the predicted one")
+		}
+	}
+
+	tree := newTestTree(t)
+	tree.version = math.MaxUint64
+	if _, _, err := tree.PendingRoot(); err != ErrVersionOverflow {
+		t.Fatalf("got %v at the last version, want ErrVersionOverflow", err)
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	kvs := make([]KV, 0, 24)
+	for i := 20; i < 44; i++ {
+		kvs = append(kvs, KV{Key: testKey(i), Val: testValue(i + 1000)})
+	}
+	want := newTestTree(t)
+	for _, kv := range kvs {
+		if err := want.Set(kv.Key, kv.Val); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := NewFastMemoryDB(0)
+	commitVersions(t, newTestTree(t, WithCustomDB(db)), 32)
+	inMemory := newTestTree(t)
+	commitVersions(t, inMemory, 32)
+	// The reopened tree holds few of the replaced leaves in memory.
+	for name, tree := range map[string]*BASSparseMerkleTree{"in memory": inMemory, "reopened": newTestTree(t, WithCustomDB(db))} {
+		if err := tree.Set(testKey(50), testValue(50)); err != nil {
+			t.Fatal(err)
+		}
+		version, err := tree.ReplaceAll(kvs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 4 {
+			t.Fatalf("%s: ReplaceAll committed version %d, want 4", name, version)
+		}
+		if !bytes.Equal(tree.CommittedRoot(), want.Root()) {
+			t.Fatalf("%s: root differs from a tree holding only the replacing keys", name)
+		}
+		for _, i := range []int{0, 19, 25, 50} {
+			val, err := tree.Get(testKey(i), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wantVal []byte
+			if i >= 20 && i < 44 {
+				wantVal = testValue(i + 1000)
+			}
+			if !bytes.Equal(val, wantVal) {
+				t.Fatalf("%s: key %d reads %x after ReplaceAll", name, i, val)
+			}
+		}
+		previous := Version(3)
+		if val, err := tree.Get(testKey(0), &previous); err != nil || !bytes.Equal(val, testValue(0)) {
+			t.Fatalf("%s: got %x, %v at the replaced version", name, val, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package bsmt
+
+// TreeSnapshot is a read-only view of a committed version. It reads from
+// storage only, so later commits and rollbacks of the live tree above its
+// version do not affect it. Once its version is pruned every read fails with
+// ErrVersionTooOld, and once the live tree is rolled back below it, which
+// drops the state it views, with ErrSnapshotRolledBack.
+type TreeSnapshot struct {
+	tree    *BASSparseMerkleTree
+	version Version
+	root    []byte
+	// rollbacks is the number of rollbacks of the tree before the snapshot.
+	rollbacks int
+}
+
+// Snapshot returns a read-only view of version.
+func (tree *BASSparseMerkleTree) Snapshot(version Version) (*TreeSnapshot, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if uint64(version) < tree.recentVersion {
+		return nil, ErrVersionTooOld
+	}
+	if uint64(version) > tree.version {
+		return nil, ErrVersionTooHigh
+	}
+	root, err := tree.rootFromStorage(version)
+	if err != nil {
+		return nil, err
+	}
+	return &TreeSnapshot{tree: tree, version: version, root: root, rollbacks: len(tree.rollbackTargets)}, nil
+}
+
+// rootFromStorage reads the root committed at version from the db, failing
+// with ErrRootNotFound if none is stored. Without a db the root is read from
+// the resident root node, under the tree lock held by the caller.
+func (tree *BASSparseMerkleTree) rootFromStorage(version Version) ([]byte, error) {
+	if tree.db == nil {
+		return tree.rootAt(version), nil
+	}
+	block, err := tree.readBlock(0, nil)
+	if err == ErrDatabaseNotFound && version == 0 {
+		return tree.nilHashes[0], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := hashAt(block.Versions, version)
+	if !ok {
+		if version == 0 {
+			return tree.nilHashes[0], nil
+		}
+		return nil, ErrRootNotFound
+	}
+	return hash, nil
+}
+
+// VersionRoot is a committed version and the root it committed.
+type VersionRoot struct {
+	Version Version
+	Root    []byte
+}
+
+// VersionRoots returns the retained versions at which the root changed,
+// oldest first, with their roots, e.g. to publish a checkpoint chain. After
+// a Rollback the list ends at the version rolled back to, as the history
+// above it is dropped from the root node.
+func (tree *BASSparseMerkleTree) VersionRoots() ([]VersionRoot, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	root, ok := tree.root.(*FullTreeNode)
+	if !ok || root == nil {
+		return nil, nil
+	}
+	var roots []VersionRoot
+	for _, info := range root.Versions {
+		if uint64(info.Ver) < tree.recentVersion || uint64(info.Ver) > tree.version {
+			continue
+		}
+		hash, err := tree.rootFromStorage(info.Ver)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, VersionRoot{Version: info.Ver, Root: hash})
+	}
+	return roots, nil
+}
+
+// checkValid fails once the snapshot version has been pruned or rolled
+// back. Reads check it before and after reading storage, so a read that
+// overlaps a rollback is not returned either.
+func (snapshot *TreeSnapshot) checkValid() error {
+	tree := snapshot.tree
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if uint64(snapshot.version) < tree.recentVersion {
+		return ErrVersionTooOld
+	}
+	for _, target := range tree.rollbackTargets[snapshot.rollbacks:] {
+		if target < snapshot.version {
+			return ErrSnapshotRolledBack
+		}
+	}
+	return nil
+}
+
+func (snapshot *TreeSnapshot) Version() Version {
+	return snapshot.version
+}
+
+func (snapshot *TreeSnapshot) Root() []byte {
+	return snapshot.root
+}
+
+func (snapshot *TreeSnapshot) Get(key []byte) ([]byte, error) {
+	if err := snapshot.checkValid(); err != nil {
+		return nil, err
+	}
+	if len(snapshot.tree.path(key))*8 < int(snapshot.tree.maxDepth) {
+		return nil, ErrInvalidKey
+	}
+	val, err := snapshot.tree.getFromStorage(key, snapshot.version)
+	if err != nil {
+		return nil, err
+	}
+	if err := snapshot.checkValid(); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func (snapshot *TreeSnapshot) GetProof(key []byte) (Proof, error) {
+	if err := snapshot.checkValid(); err != nil {
+		return Proof{}, err
+	}
+	proof, err := snapshot.tree.committedProof(key, snapshot.version)
+	if err != nil {
+		return Proof{}, err
+	}
+	if err := snapshot.checkValid(); err != nil {
+		return Proof{}, err
+	}
+	return proof, nil
+}
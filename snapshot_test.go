@@ -0,0 +1,370 @@
+package bsmt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// commitVersions commits three versions of keys to tree and returns the
+// committed roots indexed by version.
+func commitVersions(t *testing.T, tree *BASSparseMerkleTree, keys int) [][]byte {
+	t.Helper()
+	roots := [][]byte{tree.CommittedRoot()}
+	for v := 1; v <= 3; v++ {
+		for i := 0; i < keys; i++ {
+			if err := tree.Set(testKey(i), testValue(i*v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, tree.CommittedRoot())
+	}
+	return roots
+}
+
+func TestSnapshotReadsCommittedRoots(t *testing.T) {
+	for _, db := range []TreeDB{nil, NewFastMemoryDB(0)} {
+		var opts []Option
+		if db != nil {
+			opts = append(opts, WithCustomDB(db))
+		}
+		tree := newTestTree(t, opts...)
+		roots := commitVersions(t, tree, 32)
+		// Staged changes must not leak into snapshots of the latest version.
+		if err := tree.Set(testKey(0), testValue(1000)); err != nil {
+			t.Fatal(err)
+		}
+		for v := Version(1); v <= 3; v++ {
+			snapshot, err := tree.Snapshot(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(snapshot.Root(), roots[v]) {
+				t.Fatalf("snapshot root of version %d differs from the committed root", v)
+			}
+			server := NewProofServer(snapshot, sha256.New)
+			for i := 0; i < 32; i++ {
+				val, err := snapshot.Get(testKey(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(val, testValue(i*int(v))) {
+					t.Fatalf("version %d: wrong value of key %d", v, i)
+				}
+				proof, err := server.GetProof(testKey(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !server.VerifyProof(proof) {
+					t.Fatalf("version %d: proof of key %d does not verify", v, i)
+				}
+			}
+		}
+		versionRoots, err := tree.VersionRoots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versionRoots) != 3 {
+			t.Fatalf("got %d version roots, want 3", len(versionRoots))
+		}
+		for _, vr := range versionRoots {
+			if !bytes.Equal(vr.Root, roots[vr.Version]) {
+				t.Fatalf("root of version %d differs from the committed root", vr.Version)
+			}
+		}
+	}
+}
+
+func TestSnapshotRolledBack(t *testing.T) {
+	for _, db := range []TreeDB{nil, NewFastMemoryDB(0)} {
+		var opts []Option
+		if db != nil {
+			opts = append(opts, WithCustomDB(db))
+		}
+		tree := newTestTree(t, opts...)
+		roots := commitVersions(t, tree, 8)
+		dropped, err := tree.Snapshot(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept, err := tree.Snapshot(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Rollback(2); err != nil {
+			t.Fatal(err)
+		}
+		// Commit another version 3 over the dropped one.
+		if err := tree.Set(testKey(0), testValue(1000)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dropped.Get(testKey(0)); err != ErrSnapshotRolledBack {
+			t.Fatalf("got %v, want ErrSnapshotRolledBack", err)
+		}
+		if _, err := dropped.GetProof(testKey(0)); err != ErrSnapshotRolledBack {
+			t.Fatalf("got %v, want ErrSnapshotRolledBack", err)
+		}
+		proof, err := kept.GetProof(testKey(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(proof.Root, roots[2]) || !bytes.Equal(proof.Leaf, testValue(0)) {
+			t.Fatal("snapshot of the version rolled back to changed")
+		}
+	}
+}
+
+func TestReopenRestoresCommittedState(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	roots := commitVersions(t, tree, 64)
+
+	reopened := newTestTree(t, WithCustomDB(db))
+	if reopened.LatestVersion() != 3 {
+		t.Fatalf("reopened at version %d, want 3", reopened.LatestVersion())
+	}
+	if !bytes.Equal(reopened.Root(), roots[3]) {
+		t.Fatal("reopened tree has a different root")
+	}
+	for i := 0; i < 64; i++ {
+		val, err := reopened.Get(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, testValue(i*3)) {
+			t.Fatalf("wrong value of key %d after reopening", i)
+		}
+	}
+	version := Version(2)
+	proof, err := reopened.GetProof(testKey(5), &version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(proof.Root, roots[2]) || !reopened.VerifyProof(proof) {
+		t.Fatal("historical proof does not verify against the root of its version")
+	}
+	// Staging on top of the reopened tree matches staging on the original.
+	for _, tr := range []*BASSparseMerkleTree{tree, reopened} {
+		if err := tr.Set(testKey(1), testValue(77)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(tree.Root(), reopened.Root()) {
+		t.Fatal("reopened tree diverges after a Set")
+	}
+}
+
+func TestRootFromStorageMissing(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db))
+	commitVersions(t, tree, 4)
+	if err := db.Delete(storageNodeKey(0, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Snapshot(2); err != ErrDatabaseNotFound {
+		t.Fatalf("got %v, want ErrDatabaseNotFound", err)
+	}
+}
+
+func TestKeyVersionProofsUseCommittedRoots(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	roots := commitVersions(t, tree, 16)
+	if err := tree.Set(testKey(2), testValue(500)); err != nil {
+		t.Fatal(err)
+	}
+	proofs, err := tree.GetKeyVersionProofs(testKey(2), []Version{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, proof := range proofs {
+		v := Version(i + 1)
+		if !bytes.Equal(proof.Root, roots[v]) {
+			t.Fatalf("proof at version %d is not against the root committed then", v)
+		}
+		if !bytes.Equal(proof.Leaf, testValue(2*int(v))) {
+			t.Fatalf("proof at version %d carries the wrong leaf", v)
+		}
+		if err := tree.CheckProof(proof); err != nil {
+			t.Fatalf("proof at version %d: %v", v, err)
+		}
+	}
+	future := Version(4)
+	if _, err := tree.GetProof(testKey(2), &future); err != ErrVersionTooHigh {
+		t.Fatalf("got %v, want ErrVersionTooHigh", err)
+	}
+}
+
+func TestVerifyRootAtVersion(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)), WithVersionRetention(1))
+	roots := commitVersions(t, tree, 8)
+	for v := Version(1); v <= 3; v++ {
+		ok, err := tree.VerifyRootAtVersion(v, roots[3])
+		if v < 2 {
+			if err != ErrVersionTooOld {
+				t.Fatalf("version %d: got %v, want ErrVersionTooOld", v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (v == 3) {
+			t.Fatalf("version %d: root of version 3 verified as %v", v, ok)
+		}
+		if ok, err := tree.VerifyRootAtVersion(v, roots[v]); err != nil || !ok {
+			t.Fatalf("version %d: its own root does not verify: %v", v, err)
+		}
+	}
+	if _, err := tree.VerifyRootAtVersion(4, roots[3]); err != ErrVersionTooHigh {
+		t.Fatalf("got %v, want ErrVersionTooHigh", err)
+	}
+}
+
+// TestReadsAtVersionDuringCommit reads version 3 and proves the latest
+// committed version from many goroutines while later versions are set and
+// committed; run with -race.
+func TestReadsAtVersionDuringCommit(t *testing.T) {
+	const keys, readers, commits = 64, 4, 8
+	for _, db := range []TreeDB{nil, NewFastMemoryDB(0)} {
+		var opts []Option
+		if db != nil {
+			opts = append(opts, WithCustomDB(db))
+		}
+		tree := newTestTree(t, opts...)
+		roots := commitVersions(t, tree, keys)
+		snapshot, err := tree.Snapshot(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		errs := make(chan error, readers)
+		var wg sync.WaitGroup
+		for r := 0; r < readers; r++ {
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				version := Version(3)
+				for n := 0; ; n++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					i := (r + n*readers) % keys
+					val, err := tree.Get(testKey(i), &version)
+					if err == nil && !bytes.Equal(val, testValue(i*3)) {
+						err = fmt.Errorf("key %d read %x at version 3 during a commit", i, val)
+					}
+					if err == nil {
+						val, err = snapshot.Get(testKey(i))
+						if err == nil && !bytes.Equal(val, testValue(i*3)) {
+							err = fmt.Errorf("key %d read %x from the snapshot during a commit", i, val)
+						}
+					}
+					if err == nil {
+						var proof Proof
+						proof, err = tree.CommittedProof(testKey(i))
+						if err == nil && !tree.VerifyProof(proof) {
+							err = fmt.Errorf("committed proof of key %d does not verify during a commit", i)
+						}
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(r)
+		}
+		for c := 0; c < commits; c++ {
+			for i := 0; i < keys; i++ {
+				if err := tree.Set(testKey(i), testValue(i*(4+c))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := tree.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(done)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+		if ok, err := tree.VerifyRootAtVersion(3, roots[3]); err != nil || !ok {
+			t.Fatalf("got %v, %v for the root of version 3 after the commits", ok, err)
+		}
+	}
+}
+
+func TestProofServer(t *testing.T) {
+	const keys, servers = 32, 8
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	roots := commitVersions(t, tree, keys)
+	snapshot, err := tree.Snapshot(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewProofServer(snapshot, sha256.New)
+
+	errs := make(chan error, servers)
+	var wg sync.WaitGroup
+	for s := 0; s < servers; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for i := s; i < keys; i += servers {
+				proof, err := server.GetProof(testKey(i))
+				if err == nil && (!bytes.Equal(proof.Root, roots[2]) || !bytes.Equal(proof.Leaf, testValue(i*2))) {
+					err = fmt.Errorf("proof of key %d is not of version 2", i)
+				}
+				if err == nil && !server.VerifyProof(proof) {
+					err = fmt.Errorf("proof of key %d does not verify", i)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(s)
+	}
+	// The live tree moves on while the server serves version 2.
+	for i := 0; i < keys; i++ {
+		if err := tree.Set(testKey(i), testValue(i*4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	live, err := tree.GetProof(testKey(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.VerifyProof(live) {
+		t.Fatal("a proof against the live root verifies against the snapshot")
+	}
+	proof, err := server.GetProof(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Leaf = testValue(100)
+	if server.VerifyProof(proof) {
+		t.Fatal("a proof with a changed leaf verifies")
+	}
+}
@@ -0,0 +1,133 @@
+package bsmt
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Stats are cumulative counters of the tree since creation or the last
+// ResetStats.
+type Stats struct {
+	DBReads       uint64
+	CacheHits     uint64
+	CacheMisses   uint64
+	NodesReleased uint64
+	ProofsServed  uint64
+	BytesWritten  uint64
+	// NilShortCircuits counts hashes of two empty children that were skipped.
+	NilShortCircuits uint64
+	// ResidentBytes estimates the memory held by resident nodes and their
+	// history, ReleasableBytes the part of it held by nodes only loaded to
+	// serve reads, which GC can release. Like Size they are maintained as
+	// nodes change and are not reset by ResetStats.
+	ResidentBytes   uint64
+	ReleasableBytes uint64
+}
+
+// metrics holds the live counters. It is allocated on its own so the
+// uint64 fields stay 64-bit aligned for atomic access.
+type metrics struct {
+	dbReads          uint64
+	cacheHits        uint64
+	cacheMisses      uint64
+	nodesReleased    uint64
+	proofsServed     uint64
+	bytesWritten     uint64
+	nilShortCircuits uint64
+	// residentNodes is a gauge read by Size, not reset by ResetStats. The
+	// other gauges count the versions held by resident nodes and the nodes
+	// marked Temporary with their versions.
+	residentNodes     int64
+	residentVersions  int64
+	temporaryNodes    int64
+	temporaryVersions int64
+}
+
+// Stats returns a snapshot of the counters.
+func (tree *BASSparseMerkleTree) Stats() Stats {
+	m := tree.metrics
+	return Stats{
+		DBReads:          atomic.LoadUint64(&m.dbReads),
+		CacheHits:        atomic.LoadUint64(&m.cacheHits),
+		CacheMisses:      atomic.LoadUint64(&m.cacheMisses),
+		NodesReleased:    atomic.LoadUint64(&m.nodesReleased),
+		ProofsServed:     atomic.LoadUint64(&m.proofsServed),
+		BytesWritten:     atomic.LoadUint64(&m.bytesWritten),
+		NilShortCircuits: atomic.LoadUint64(&m.nilShortCircuits),
+		ResidentBytes: tree.footprint(atomic.LoadInt64(&m.residentNodes),
+			atomic.LoadInt64(&m.residentVersions)),
+		ReleasableBytes: tree.footprint(atomic.LoadInt64(&m.temporaryNodes),
+			atomic.LoadInt64(&m.temporaryVersions)),
+	}
+}
+
+// footprint estimates the memory held by nodes resident nodes holding
+// versions versions in all.
+func (tree *BASSparseMerkleTree) footprint(nodes, versions int64) uint64 {
+	hashSize := int64(tree.hasher.Size())
+	nodeBytes := int64(unsafe.Sizeof(FullTreeNode{})) + hashSize
+	versionBytes := int64(unsafe.Sizeof(&VersionInfo{})+unsafe.Sizeof(VersionInfo{})) + hashSize
+	return uint64(nodes*nodeBytes + versions*versionBytes)
+}
+
+// ResetStats sets all counters back to zero.
+func (tree *BASSparseMerkleTree) ResetStats() {
+	m := tree.metrics
+	atomic.StoreUint64(&m.dbReads, 0)
+	atomic.StoreUint64(&m.cacheHits, 0)
+	atomic.StoreUint64(&m.cacheMisses, 0)
+	atomic.StoreUint64(&m.nodesReleased, 0)
+	atomic.StoreUint64(&m.proofsServed, 0)
+	atomic.StoreUint64(&m.bytesWritten, 0)
+	atomic.StoreUint64(&m.nilShortCircuits, 0)
+}
+
+// Size returns the number of resident nodes. It is maintained as nodes are
+// allocated and released, so it is cheap enough to scrape continuously.
+func (tree *BASSparseMerkleTree) Size() uint64 {
+	return uint64(atomic.LoadInt64(&tree.metrics.residentNodes))
+}
+
+// allocNode returns a new resident node.
+func (tree *BASSparseMerkleTree) allocNode() Node {
+	atomic.AddInt64(&tree.metrics.residentNodes, 1)
+	return tree.newNode()
+}
+
+// releaseNodes records that the nodes of the subtree at node are no longer
+// resident, each as it is dropped.
+func (tree *BASSparseMerkleTree) releaseNodes(node TreeNode) {
+	full := fullNode(node)
+	if full == nil {
+		return
+	}
+	tree.releaseNodes(full.LeftChild)
+	tree.releaseNodes(full.RightChild)
+	tree.setTemporary(full, false)
+	tree.countVersions(full, -len(full.Versions))
+	atomic.AddInt64(&tree.metrics.residentNodes, -1)
+	tree.nodeArena.recycle(full)
+}
+
+// countVersions records that n versions were added to the history of the
+// resident node, or removed for a negative n.
+func (tree *BASSparseMerkleTree) countVersions(node *FullTreeNode, n int) {
+	atomic.AddInt64(&tree.metrics.residentVersions, int64(n))
+	if node.Temporary {
+		atomic.AddInt64(&tree.metrics.temporaryVersions, int64(n))
+	}
+}
+
+// setTemporary sets the Temporary flag of the resident node.
+func (tree *BASSparseMerkleTree) setTemporary(node *FullTreeNode, temporary bool) {
+	if node.Temporary == temporary {
+		return
+	}
+	node.Temporary = temporary
+	n, versions := int64(1), int64(len(node.Versions))
+	if !temporary {
+		n, versions = -n, -versions
+	}
+	atomic.AddInt64(&tree.metrics.temporaryNodes, n)
+	atomic.AddInt64(&tree.metrics.temporaryVersions, versions)
+}
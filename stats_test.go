@@ -0,0 +1,95 @@
+package bsmt
+
+import "testing"
+
+// countResident counts the resident nodes of the subtree at node by a full
+// traversal.
+func countResident(node TreeNode) uint64 {
+	full := fullNode(node)
+	if full == nil {
+		return 0
+	}
+	return 1 + countResident(full.LeftChild) + countResident(full.RightChild)
+}
+
+// checkResident compares the resident gauges of tree with a full traversal.
+func checkResident(t *testing.T, tree *BASSparseMerkleTree, step string) {
+	t.Helper()
+	var nodes, versions, temporary, temporaryVersions int64
+	var walk func(node *FullTreeNode)
+	walk = func(node *FullTreeNode) {
+		if node == nil {
+			return
+		}
+		nodes++
+		versions += int64(len(node.Versions))
+		if node.Temporary {
+			temporary++
+			temporaryVersions += int64(len(node.Versions))
+		}
+		walk(fullNode(node.LeftChild))
+		walk(fullNode(node.RightChild))
+	}
+	walk(tree.rootNode())
+	stats := tree.Stats()
+	if tree.Size() != uint64(nodes) {
+		t.Fatalf("%s: Size is %d, a traversal counts %d nodes", step, tree.Size(), nodes)
+	}
+	if want := tree.footprint(nodes, versions); stats.ResidentBytes != want {
+		t.Fatalf("%s: ResidentBytes is %d, a traversal gives %d", step, stats.ResidentBytes, want)
+	}
+	if want := tree.footprint(temporary, temporaryVersions); stats.ReleasableBytes != want {
+		t.Fatalf("%s: ReleasableBytes is %d, a traversal gives %d", step, stats.ReleasableBytes, want)
+	}
+}
+
+func TestResidentGauges(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), WithVersionRetention(2))
+	checkResident(t, tree, "empty tree")
+	for v := 1; v <= 4; v++ {
+		for i := 0; i < 64; i++ {
+			if err := tree.Set(testKey(i), testValue(i*v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkResident(t, tree, "staged sets")
+		if _, err := tree.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkResident(t, tree, "commit")
+	}
+	if err := tree.Delete(testKey(3)); err != nil {
+		t.Fatal(err)
+	}
+	tree.Reset()
+	checkResident(t, tree, "reset")
+	if err := tree.Rollback(3); err != nil {
+		t.Fatal(err)
+	}
+	checkResident(t, tree, "rollback")
+
+	reopened := newTestTree(t, WithCustomDB(db), WithVersionRetention(2))
+	checkResident(t, reopened, "reopen")
+	for i := 0; i < 64; i += 2 {
+		if _, err := reopened.GetProof(testKey(i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reopened.Stats().ReleasableBytes == 0 {
+		t.Fatal("nodes loaded by reads are not releasable")
+	}
+	checkResident(t, reopened, "reads")
+	if err := reopened.Set(testKey(2), testValue(99)); err != nil {
+		t.Fatal(err)
+	}
+	checkResident(t, reopened, "set on loaded path")
+	if _, err := reopened.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkResident(t, reopened, "commit after reads")
+	reopened.GC(0)
+	checkResident(t, reopened, "gc")
+	reopened.evictTemporary()
+	checkResident(t, reopened, "eviction")
+}
@@ -0,0 +1,277 @@
+package bsmt
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// The tree is stored in blocks of four levels: the node at every depth
+// divisible by 4 is stored under storageNodeKey together with the 30 nodes
+// of the four levels below it, whose last level are the roots of the next
+// blocks. Blocks hold the full retained history of their nodes, so a read
+// at any retained version walks maxDepth/4 blocks.
+
+// dbGet reads key from the db. An empty value is reported as
+// ErrDatabaseNotFound too, as some stores return it for a missing key
+// without an error.
+func (tree *BASSparseMerkleTree) dbGet(key []byte) ([]byte, error) {
+	data, err := tree.db.Get(key)
+	if err == nil && len(data) == 0 {
+		return nil, ErrDatabaseNotFound
+	}
+	return data, err
+}
+
+// readBlock reads the block rooted at depth on path, with the deltas written
+// since it was last written in full merged in. The deltas are read first: a
+// commit folding them in between leaves them in the block read after, where
+// applyDeltas skips them.
+func (tree *BASSparseMerkleTree) readBlock(depth uint8, path []byte) (*StorageFullTreeNode, error) {
+	key := storageNodeKey(depth, path)
+	var deltas []nodeDelta
+	if tree.maxNodeDeltas > 0 {
+		atomic.AddUint64(&tree.metrics.dbReads, 1)
+		var err error
+		if deltas, err = tree.readDeltas(key); err != nil {
+			return nil, err
+		}
+	}
+	atomic.AddUint64(&tree.metrics.dbReads, 1)
+	data, err := tree.dbGet(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := tree.decodeStoredNode(key, data)
+	if err != nil {
+		return nil, err
+	}
+	applyDeltas(block, deltas)
+	return block, nil
+}
+
+// unloaded reports whether node is the root of a stored block whose
+// children are not resident yet.
+func (tree *BASSparseMerkleTree) unloaded(node *FullTreeNode) bool {
+	return tree.db != nil && node.Depth%4 == 0 && node.Depth < tree.maxDepth &&
+		node.LeftChild == nil && node.RightChild == nil && len(node.Versions) > 0
+}
+
+// loadChildren makes the block of node on path resident if it is not yet.
+// The caller holds the locks that own node.
+func (tree *BASSparseMerkleTree) loadChildren(node *FullTreeNode, path []byte) error {
+	if !tree.unloaded(node) {
+		return nil
+	}
+	block, err := tree.readBlock(node.Depth, path)
+	if err != nil {
+		return err
+	}
+	tree.attachBlock(node, block)
+	return nil
+}
+
+// attachBlock links the children stored in block below node. Empty children
+// are left out, except above prefixLockDepth where every node is resident.
+// Below it the children are marked Temporary until a Set on their path.
+func (tree *BASSparseMerkleTree) attachBlock(node *FullTreeNode, block *StorageFullTreeNode) {
+	level := []*FullTreeNode{node}
+	for l := 1; l <= 4; l++ {
+		next := make([]*FullTreeNode, 1<<uint(l))
+		for pos := range next {
+			parent := level[pos>>1]
+			stored := &block.Children[(1<<uint(l))-2+pos]
+			if parent == nil || (stored.empty() && node.Depth >= prefixLockDepth) {
+				continue
+			}
+			child := tree.newTreeNode(node.Depth + uint8(l))
+			tree.setTemporary(child, node.Depth >= prefixLockDepth)
+			if n := len(stored.Versions); n > 0 {
+				child.Versions = stored.Versions
+				tree.countVersions(child, n)
+				tree.setHash(child, stored.Versions[n-1].Hash)
+			}
+			if pos&1 == 0 {
+				parent.LeftChild = child
+			} else {
+				parent.RightChild = child
+			}
+			next[pos] = child
+		}
+		level = next
+	}
+}
+
+// storageBlock builds the block of the resident node, with the history
+// older than recentVersion dropped.
+func (tree *BASSparseMerkleTree) storageBlock(node *FullTreeNode, recentVersion Version) *StorageFullTreeNode {
+	block := &StorageFullTreeNode{
+		LatestHash: node.LatestHash,
+		Versions:   prunedVersions(node.Versions, recentVersion),
+	}
+	nodes := blockNodes(node)
+	for i, child := range nodes[1:] {
+		if child != nil && len(child.Versions) > 0 {
+			block.Children[i] = StorageShortTreeNode{
+				LatestHash: child.LatestHash,
+				Versions:   prunedVersions(child.Versions, recentVersion),
+			}
+		}
+	}
+	return block
+}
+
+// writeNodes adds the blocks of the nodes committed at version, i.e. those
+// that are still dirty, the root block and the new latest and recent version
+// to batch. With WithDeltaNodeWrites blocks are written as deltas where they
+// can be.
+func (tree *BASSparseMerkleTree) writeNodes(batch Batcher, version, recentVersion Version) error {
+	var nodes []*FullTreeNode
+	var keys [][]byte
+	tree.walkDirty(func(node *FullTreeNode, path []byte) {
+		if node.Depth%4 != 0 || node.Depth == tree.maxDepth {
+			return
+		}
+		keys = append(keys, storageNodeKey(node.Depth, path))
+		nodes = append(nodes, node)
+	})
+	// The root block records every version, even one that changed nothing.
+	if root := tree.rootNode(); !root.Dirty {
+		keys = append(keys, storageNodeKey(0, nil))
+		nodes = append(nodes, root)
+	}
+	if tree.maxNodeDeltas > 0 {
+		var err error
+		if keys, nodes, err = tree.writeDeltas(batch, keys, nodes, version); err != nil {
+			return err
+		}
+	}
+	blocks := make([]*StorageFullTreeNode, len(nodes))
+	for i, node := range nodes {
+		blocks[i] = tree.storageBlock(node, recentVersion)
+	}
+	encoded, err := tree.encodeStoredNodes(blocks)
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := batch.Set(keys[i], encoded[i]); err != nil {
+			return err
+		}
+		atomic.AddUint64(&tree.metrics.bytesWritten, uint64(len(encoded[i])))
+	}
+	if err := batch.Set([]byte(latestVersionKeyPrefix), encodeVersion(uint64(version))); err != nil {
+		return err
+	}
+	return batch.Set([]byte(recentVersionNumber), encodeVersion(uint64(recentVersion)))
+}
+
+// loadLatest restores the latest and recent version and the root block
+// committed to the db. A db without a committed tree leaves the tree empty.
+func (tree *BASSparseMerkleTree) loadLatest() error {
+	data, err := tree.dbGet([]byte(latestVersionKeyPrefix))
+	if err == ErrDatabaseNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	latest, err := decodeVersion(data)
+	if err != nil {
+		return err
+	}
+	var recent Version
+	if data, err := tree.dbGet([]byte(recentVersionNumber)); err == nil {
+		if recent, err = decodeVersion(data); err != nil {
+			return err
+		}
+	} else if err != ErrDatabaseNotFound {
+		return err
+	}
+	block, err := tree.readBlock(0, nil)
+	if err == ErrDatabaseNotFound && latest == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tree.releaseNodes(tree.root)
+	root := tree.newTreeNode(0)
+	if n := len(block.Versions); n > 0 {
+		root.Versions = block.Versions
+		tree.countVersions(root, n)
+		tree.setHash(root, block.Versions[n-1].Hash)
+	}
+	tree.attachBlock(root, block)
+	tree.root = root
+	tree.version, tree.recentVersion = uint64(latest), uint64(recent)
+	return nil
+}
+
+// storageWalk is walk over the blocks stored in the db at version. It reads
+// no resident node, so it needs no tree lock.
+func (tree *BASSparseMerkleTree) storageWalk(path []byte, version Version, siblings [][]byte) ([]byte, error) {
+	empty := func(depth uint8) []byte {
+		for d := depth; siblings != nil && d < tree.maxDepth; d++ {
+			siblings[tree.maxDepth-1-d] = []byte{}
+		}
+		return tree.nilHashes[tree.maxDepth]
+	}
+	for depth := uint8(0); depth < tree.maxDepth; depth += 4 {
+		block, err := tree.readBlock(depth, path)
+		if err == ErrDatabaseNotFound && depth == 0 {
+			return empty(0), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for l := 1; l <= 4; l++ {
+			d := depth + uint8(l) - 1
+			index := blockChild(path, depth, l)
+			if siblings != nil {
+				sibling := index ^ 1
+				if hash, ok := tree.storedHash(&block.Children[sibling], d+1, version); ok {
+					siblings[tree.maxDepth-1-d] = hash
+				} else {
+					siblings[tree.maxDepth-1-d] = []byte{}
+				}
+			}
+			hash, ok := tree.storedHash(&block.Children[index], d+1, version)
+			if !ok {
+				return empty(d + 1), nil
+			}
+			if d+1 == tree.maxDepth {
+				return hash, nil
+			}
+		}
+	}
+	return empty(tree.maxDepth), nil
+}
+
+// storedHash returns the hash of a stored node at depth as of version, and
+// false if the subtree was empty then.
+func (tree *BASSparseMerkleTree) storedHash(node *StorageShortTreeNode, depth uint8, version Version) ([]byte, bool) {
+	hash, ok := hashAt(node.Versions, version)
+	return hash, ok && !bytes.Equal(hash, tree.nilHashes[depth])
+}
+
+// storedLeaf reads the stored leaf of path through the blocks from depth
+// down, nil if the leaf was never set.
+func (tree *BASSparseMerkleTree) storedLeaf(path []byte, depth uint8) (*StorageShortTreeNode, error) {
+	for ; depth < tree.maxDepth; depth += 4 {
+		block, err := tree.readBlock(depth, path)
+		if err == ErrDatabaseNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		child := &block.Children[blockChild(path, depth, 4)]
+		if child.empty() {
+			return nil, nil
+		}
+		if depth+4 == tree.maxDepth {
+			return child, nil
+		}
+	}
+	return nil, nil
+}
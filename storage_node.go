@@ -1,20 +1,238 @@
 package bsmt
 
+import "bytes"
+
+// storageNodeKeyPrefix prefixes the db keys of stored tree nodes.
+const storageNodeKeyPrefix string = "node"
+
 var (
 	_ TreeNode = (*StorageValueNode)(nil)
 	_ TreeNode = (*StorageShortTreeNode)(nil)
 	_ TreeNode = (*StorageFullTreeNode)(nil)
+	_ TreeNode = (*StorageSparseTreeNode)(nil)
 )
 
 type StorageFullTreeNode struct {
 	LatestHash []byte
-	Versions   []uint64
+	Versions   []*VersionInfo
 	Children   [30]StorageShortTreeNode
 }
 
 type StorageShortTreeNode struct {
 	LatestHash []byte
-	Versions   []uint64
+	Versions   []*VersionInfo
 }
 
 type StorageValueNode []byte
+
+// StorageSparseTreeNode is the sparse encoding of StorageFullTreeNode: only
+// non-empty children are stored, each tagged with its index in Children.
+type StorageSparseTreeNode struct {
+	LatestHash []byte
+	Versions   []*VersionInfo
+	Indexes    []uint8
+	Children   []StorageShortTreeNode
+}
+
+func (node *StorageShortTreeNode) empty() bool {
+	return len(node.LatestHash) == 0 && len(node.Versions) == 0
+}
+
+// ToSparse drops empty children from the node.
+func (node *StorageFullTreeNode) ToSparse() *StorageSparseTreeNode {
+	sparse := &StorageSparseTreeNode{
+		LatestHash: node.LatestHash,
+		Versions:   node.Versions,
+	}
+	for i := range node.Children {
+		if node.Children[i].empty() {
+			continue
+		}
+		sparse.Indexes = append(sparse.Indexes, uint8(i))
+		sparse.Children = append(sparse.Children, node.Children[i])
+	}
+	return sparse
+}
+
+// ToFull reconstructs the fixed children array from the sparse encoding.
+// The indexes must be in range and strictly increasing, so every full node
+// has one sparse encoding.
+func (node *StorageSparseTreeNode) ToFull() (*StorageFullTreeNode, error) {
+	if len(node.Indexes) != len(node.Children) {
+		return nil, ErrInvalidNodeEncoding
+	}
+	full := &StorageFullTreeNode{
+		LatestHash: node.LatestHash,
+		Versions:   node.Versions,
+	}
+	for i, index := range node.Indexes {
+		if int(index) >= len(full.Children) || i > 0 && index <= node.Indexes[i-1] {
+			return nil, ErrInvalidNodeEncoding
+		}
+		full.Children[index] = node.Children[i]
+	}
+	return full, nil
+}
+
+// MarshalBinary encodes the block root, the indexes of the stored children
+// and each of them as its hash and version history.
+func (node *StorageSparseTreeNode) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	putBytes(&buf, node.LatestHash)
+	putVersions(&buf, node.Versions)
+	putBytes(&buf, node.Indexes)
+	for i := range node.Children {
+		putBytes(&buf, node.Children[i].LatestHash)
+		putVersions(&buf, node.Children[i].Versions)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a node encoded by MarshalBinary.
+func (node *StorageSparseTreeNode) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := readShortNode(r, &node.LatestHash, &node.Versions); err != nil {
+		return err
+	}
+	indexes, err := readBytes(r)
+	if err != nil {
+		return ErrInvalidNodeEncoding
+	}
+	node.Indexes = indexes
+	node.Children = make([]StorageShortTreeNode, len(indexes))
+	for i := range node.Children {
+		if err := readShortNode(r, &node.Children[i].LatestHash, &node.Children[i].Versions); err != nil {
+			return err
+		}
+	}
+	if r.Len() != 0 {
+		return ErrInvalidNodeEncoding
+	}
+	return nil
+}
+
+// blockChild returns the index in Children of the node level levels below
+// the block root at depth on path: the nodes of each level follow those of
+// the level above, ordered by path.
+func blockChild(path []byte, depth uint8, level int) int {
+	pos := 0
+	for l := 0; l < level; l++ {
+		pos <<= 1
+		if pathBit(path, depth+uint8(l)) {
+			pos |= 1
+		}
+	}
+	return (1 << uint(level)) - 2 + pos
+}
+
+// MarshalBinary encodes the block root followed by its 30 children, each as
+// its hash and version history; an empty child has neither.
+func (node *StorageFullTreeNode) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	putBytes(&buf, node.LatestHash)
+	putVersions(&buf, node.Versions)
+	for i := range node.Children {
+		putBytes(&buf, node.Children[i].LatestHash)
+		putVersions(&buf, node.Children[i].Versions)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a block encoded by MarshalBinary.
+func (node *StorageFullTreeNode) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := readShortNode(r, &node.LatestHash, &node.Versions); err != nil {
+		return err
+	}
+	for i := range node.Children {
+		if err := readShortNode(r, &node.Children[i].LatestHash, &node.Children[i].Versions); err != nil {
+			return err
+		}
+	}
+	if r.Len() != 0 {
+		return ErrInvalidNodeEncoding
+	}
+	return nil
+}
+
+func readShortNode(r *bytes.Reader, hash *[]byte, versions *[]*VersionInfo) error {
+	var err error
+	if *hash, err = readBytes(r); err != nil {
+		return ErrInvalidNodeEncoding
+	}
+	*versions, err = readVersions(r)
+	return err
+}
+
+// prune drops the history older than recentVersion from the block and its
+// children and reports whether anything was dropped.
+func (node *StorageFullTreeNode) prune(recentVersion Version) bool {
+	pruned := false
+	for _, versions := range node.histories() {
+		if kept := prunedVersions(*versions, recentVersion); len(kept) != len(*versions) {
+			*versions = kept
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// truncate drops the history newer than version from the block and its
+// children and reports whether anything was dropped. A node left without
+// history is empty.
+func (node *StorageFullTreeNode) truncate(version Version) bool {
+	truncated := false
+	nodes := []*StorageShortTreeNode{{LatestHash: node.LatestHash, Versions: node.Versions}}
+	for i := range node.Children {
+		nodes = append(nodes, &node.Children[i])
+	}
+	for _, short := range nodes {
+		n := len(short.Versions)
+		for n > 0 && short.Versions[n-1].Ver > version {
+			n--
+		}
+		if n == len(short.Versions) {
+			continue
+		}
+		truncated = true
+		short.Versions = short.Versions[:n]
+		short.LatestHash = nil
+		if n > 0 {
+			short.LatestHash = short.Versions[n-1].Hash
+		}
+	}
+	node.LatestHash, node.Versions = nodes[0].LatestHash, nodes[0].Versions
+	return truncated
+}
+
+// histories returns the version histories of the block root and of its
+// children.
+func (node *StorageFullTreeNode) histories() []*[]*VersionInfo {
+	histories := []*[]*VersionInfo{&node.Versions}
+	for i := range node.Children {
+		histories = append(histories, &node.Children[i].Versions)
+	}
+	return histories
+}
+
+// prunedVersions returns the history of versions from the last entry at or
+// below recentVersion on, as Prune keeps it, without modifying versions.
+func prunedVersions(versions []*VersionInfo, recentVersion Version) []*VersionInfo {
+	i := 0
+	for i < len(versions)-1 && versions[i+1].Ver <= recentVersion {
+		i++
+	}
+	return versions[i:]
+}
+
+// storageNodeKey returns the db key of the stored node rooted at depth on the
+// given path: the prefix, the depth and the first depth bits of the path.
+func storageNodeKey(depth uint8, path []byte) []byte {
+	key := append([]byte(storageNodeKeyPrefix), depth)
+	n := (int(depth) + 7) / 8
+	key = append(key, path[:n]...)
+	if rem := depth % 8; rem != 0 {
+		key[len(key)-1] &= 0xff << (8 - rem)
+	}
+	return key
+}
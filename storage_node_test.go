@@ -0,0 +1,59 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSparseNodeEncoding(t *testing.T) {
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), WithSparseNodeEncoding())
+	roots := commitVersions(t, tree, 16)
+	if want := commitVersions(t, newTestTree(t), 16); !bytes.Equal(roots[3], want[3]) {
+		t.Fatal("sparse encoding changes the root")
+	}
+	if _, err := NewBASSparseMerkleTree(WithCustomDB(db)); err != ErrConfigMismatch {
+		t.Fatalf("got %v, want ErrConfigMismatch without the sparse encoding", err)
+	}
+	reopened, err := NewBASSparseMerkleTree(WithCustomDB(db), WithSparseNodeEncoding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		val, err := reopened.Get(testKey(i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(val, testValue(i*3)) {
+			t.Fatalf("key %d: reopened tree reads another value", i)
+		}
+	}
+}
+
+func TestSparseNodeToFull(t *testing.T) {
+	node := &StorageFullTreeNode{LatestHash: []byte{1}}
+	node.Children[3].LatestHash = []byte{2}
+	node.Children[29].LatestHash = []byte{3}
+	data, err := node.ToSparse().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &StorageSparseTreeNode{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	full, err := decoded.ToFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(full.Children[29].LatestHash, []byte{3}) || !full.Children[0].empty() {
+		t.Fatal("round trip changes the node")
+	}
+
+	for _, indexes := range [][]uint8{{30}, {255}, {3, 3}, {29, 3}} {
+		sparse := &StorageSparseTreeNode{Indexes: indexes, Children: make([]StorageShortTreeNode, len(indexes))}
+		if _, err := sparse.ToFull(); err != ErrInvalidNodeEncoding {
+			t.Fatalf("indexes %v: got %v, want ErrInvalidNodeEncoding", indexes, err)
+		}
+	}
+}
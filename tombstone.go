@@ -0,0 +1,158 @@
+package bsmt
+
+import "bytes"
+
+const tombstoneKeyPrefix string = "tombstone"
+
+func tombstoneKey(key []byte) []byte {
+	return append([]byte(tombstoneKeyPrefix), key...)
+}
+
+// KeyState tells apart the ways a key can resolve to the nil leaf.
+type KeyState int
+
+const (
+	// KeyAbsent is a key that was never set.
+	KeyAbsent KeyState = iota
+	// KeyDeleted is a key whose value was removed by Delete.
+	KeyDeleted
+	// KeySet is a key holding a value.
+	KeySet
+)
+
+// Delete resets the leaf of key to the nil leaf, so the root is the same as
+// if the key had never been set, and records a tombstone with the version
+// of the deletion for GetState. The tombstone is not part of the root: the
+// proof of a deleted key is that of an absent one. Use SetEmpty where the
+// deletion itself must be provable. The tombstone is committed with the
+// deletion, dropped by a later Set of the key and ignored once the version
+// it was committed at is rolled back.
+func (tree *BASSparseMerkleTree) Delete(key []byte) error {
+	return tree.set(key, tree.nilHashes[tree.maxDepth], tree.wal != nil, true)
+}
+
+// GetState is Get that also reports whether an empty key was never set or
+// deleted. A key deleted more than once reports its latest deletion, so
+// reads at versions before it see the key as absent.
+func (tree *BASSparseMerkleTree) GetState(key []byte, version *Version) ([]byte, KeyState, error) {
+	val, err := tree.Get(key, version)
+	if err != nil {
+		return nil, KeyAbsent, err
+	}
+	if len(val) != 0 && !bytes.Equal(val, tree.nilHashes[tree.maxDepth]) {
+		return val, KeySet, nil
+	}
+	if version == nil && tree.hasPendingTombstone(key) {
+		return nil, KeyDeleted, nil
+	}
+	data, err := tree.committedTombstone(key)
+	if err == ErrDatabaseNotFound {
+		return nil, KeyAbsent, nil
+	}
+	if err != nil {
+		return nil, KeyAbsent, err
+	}
+	// The record is the version of the deletion and the root it committed.
+	if len(data) < 8 {
+		return nil, KeyAbsent, ErrInvalidVersionRecord
+	}
+	deletedAt, err := decodeVersion(data[:8])
+	if err != nil {
+		return nil, KeyAbsent, err
+	}
+	if version != nil && *version < deletedAt || !tree.tombstoneCommitted(deletedAt, data[8:]) {
+		return nil, KeyAbsent, nil
+	}
+	return nil, KeyDeleted, nil
+}
+
+// stageTombstone records whether the staged value of key deletes it.
+func (tree *BASSparseMerkleTree) stageTombstone(key []byte, deleted bool) {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	if !deleted {
+		delete(tree.tombstones, string(key))
+		return
+	}
+	if tree.tombstones == nil {
+		tree.tombstones = make(map[string]struct{})
+	}
+	tree.tombstones[string(key)] = struct{}{}
+}
+
+func (tree *BASSparseMerkleTree) hasPendingTombstone(key []byte) bool {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	_, ok := tree.tombstones[string(key)]
+	return ok
+}
+
+// tombstoneCommitted reports whether the tombstone recorded with root at
+// deletedAt is still part of the committed history: a rollback below
+// deletedAt leaves it behind, and a new commit of deletedAt has another
+// root. History below the recent version cannot be rolled back.
+func (tree *BASSparseMerkleTree) tombstoneCommitted(deletedAt Version, root []byte) bool {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if uint64(deletedAt) > tree.version {
+		return false
+	}
+	return uint64(deletedAt) < tree.recentVersion || bytes.Equal(tree.rootAt(deletedAt), root)
+}
+
+// committedTombstone reads the tombstone record of key, from the db or,
+// without one, from committedTombstones.
+func (tree *BASSparseMerkleTree) committedTombstone(key []byte) ([]byte, error) {
+	if tree.db != nil {
+		return tree.dbGet(tombstoneKey(key))
+	}
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	record, ok := tree.committedTombstones[string(key)]
+	if !ok {
+		return nil, ErrDatabaseNotFound
+	}
+	return record, nil
+}
+
+// writeTombstones adds to batch the staged tombstones, as deleted at
+// version with root, and the removal of those of the other staged keys.
+func (tree *BASSparseMerkleTree) writeTombstones(batch Batcher, version Version, root []byte) error {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	for key := range tree.journal {
+		if _, ok := tree.tombstones[key]; !ok {
+			if err := batch.Delete(tombstoneKey([]byte(key))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := batch.Set(tombstoneKey([]byte(key)), tombstoneRecord(version, root)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepTombstones commits the staged tombstones of a tree without a db, as
+// writeTombstones does to the db.
+func (tree *BASSparseMerkleTree) keepTombstones(version Version, root []byte) {
+	tree.journalLock.Lock()
+	defer tree.journalLock.Unlock()
+	for key := range tree.journal {
+		if _, ok := tree.tombstones[key]; !ok {
+			delete(tree.committedTombstones, key)
+			continue
+		}
+		if tree.committedTombstones == nil {
+			tree.committedTombstones = make(map[string][]byte)
+		}
+		tree.committedTombstones[key] = tombstoneRecord(version, root)
+	}
+}
+
+// tombstoneRecord encodes the version of a deletion and the root it
+// committed.
+func tombstoneRecord(version Version, root []byte) []byte {
+	return append(encodeVersion(uint64(version)), root...)
+}
@@ -0,0 +1,106 @@
+package bsmt
+
+import "testing"
+
+func checkKeyState(t *testing.T, tree *BASSparseMerkleTree, key []byte, version *Version, want KeyState) {
+	t.Helper()
+	_, state, err := tree.GetState(key, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != want {
+		t.Fatalf("got state %d, want %d", state, want)
+	}
+}
+
+func TestSetOfNilLeafIsNotADeletion(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	key := testKey(1)
+	if err := tree.Set(key, tree.nilHashes[tree.maxDepth]); err != nil {
+		t.Fatal(err)
+	}
+	checkKeyState(t, tree, key, nil, KeyAbsent)
+	if _, err := tree.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkKeyState(t, tree, key, nil, KeyAbsent)
+}
+
+func TestTombstoneFollowsHistory(t *testing.T) {
+	t.Run("db", func(t *testing.T) {
+		db := NewFastMemoryDB(0)
+		testTombstoneFollowsHistory(t, newTestTree(t, WithCustomDB(db)), func() bool {
+			_, err := db.Get(tombstoneKey(testKey(1)))
+			return err == nil
+		})
+	})
+	t.Run("no db", func(t *testing.T) {
+		tree := newTestTree(t)
+		testTombstoneFollowsHistory(t, tree, func() bool {
+			_, ok := tree.committedTombstones[string(testKey(1))]
+			return ok
+		})
+	})
+}
+
+// testTombstoneFollowsHistory deletes, rolls back and sets testKey(1) in
+// tree; stored reports whether its tombstone record is kept.
+func testTombstoneFollowsHistory(t *testing.T, tree *BASSparseMerkleTree, stored func() bool) {
+	key, other := testKey(1), testKey(2)
+	commit := func() Version {
+		t.Helper()
+		version, err := tree.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return version
+	}
+	if err := tree.Set(other, testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	before := commit()
+	if err := tree.Set(key, testValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	set := commit()
+	if err := tree.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	checkKeyState(t, tree, key, nil, KeyDeleted)
+	deleted := commit()
+	checkKeyState(t, tree, key, nil, KeyDeleted)
+	checkKeyState(t, tree, key, &set, KeySet)
+	checkKeyState(t, tree, key, &before, KeyAbsent)
+
+	// A rollback below the deletion leaves the tombstone behind, and a new
+	// commit of the same version does not revive it.
+	if err := tree.Rollback(before); err != nil {
+		t.Fatal(err)
+	}
+	checkKeyState(t, tree, key, nil, KeyAbsent)
+	for i := 0; tree.LatestVersion() < deleted; i++ {
+		if err := tree.Set(other, testValue(10+i)); err != nil {
+			t.Fatal(err)
+		}
+		commit()
+	}
+	checkKeyState(t, tree, key, nil, KeyAbsent)
+
+	// A later Set removes the persisted tombstone.
+	if err := tree.Set(key, testValue(3)); err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	if err := tree.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	checkKeyState(t, tree, key, nil, KeyDeleted)
+	if err := tree.Set(key, testValue(4)); err != nil {
+		t.Fatal(err)
+	}
+	commit()
+	if stored() {
+		t.Fatal("tombstone is kept after a Set")
+	}
+}
@@ -9,9 +9,15 @@ var (
 type NilHashTreeNode struct {
 }
 
+// VersionInfo is the hash of a node as committed at Ver.
+type VersionInfo struct {
+	Ver  Version
+	Hash []byte
+}
+
 type FullTreeNode struct {
 	LatestHash []byte
-	Versions   []uint64
+	Versions   []*VersionInfo
 
 	// In-Memory
 	Depth      uint8
@@ -19,9 +25,36 @@ type FullTreeNode struct {
 	RightChild TreeNode
 	Dirty      bool
 	Size       uint64
+	// Empty is set when LatestHash equals the nil hash of Depth, so the
+	// subtree can be skipped without loading its children.
+	Empty bool
+	// LastAccess orders nodes for GCByAccessRecency.
+	LastAccess uint64
+	// Temporary is set on nodes loaded only to serve a read.
+	Temporary bool
+	// stale is set on the path of a staged leaf until rehash has hashed it
+	// up.
+	stale bool
 }
 
 type ShortTreeNode struct {
 	LatestHash []byte
-	Versions   []uint64
+	Versions   []*VersionInfo
+}
+
+// fullNode returns node as a resident node, or nil for an empty subtree.
+func fullNode(node TreeNode) *FullTreeNode {
+	full, _ := node.(*FullTreeNode)
+	return full
+}
+
+// hashAt returns the hash recorded in versions as of version, and false if
+// the node did not exist yet at version.
+func hashAt(versions []*VersionInfo, version Version) ([]byte, bool) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Ver <= version {
+			return versions[i].Hash, true
+		}
+	}
+	return nil, false
 }
@@ -0,0 +1,16 @@
+package bsmt
+
+import "encoding/binary"
+
+func encodeVersion(version uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, version)
+	return buf
+}
+
+func decodeVersion(data []byte) (Version, error) {
+	if len(data) != 8 {
+		return 0, ErrInvalidVersionRecord
+	}
+	return Version(binary.BigEndian.Uint64(data)), nil
+}
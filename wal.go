@@ -0,0 +1,109 @@
+package bsmt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// WAL is the write-ahead log Set appends to. *os.File satisfies it.
+type WAL interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// ErrWALNotTruncated is returned by Commit when the version was committed
+// but the WAL could not be emptied afterwards. The commit stands: the tree
+// is at Version, and the entries left in the log are skipped by
+// RecoverFromWAL as they belong to a committed version. The next Commit
+// truncates the log again. It wraps the error of the WAL.
+type ErrWALNotTruncated struct {
+	Version Version
+	Err     error
+}
+
+func (e *ErrWALNotTruncated) Error() string {
+	return fmt.Sprintf("version %d committed, but the write-ahead log was not truncated: %v", e.Version, e.Err)
+}
+
+func (e *ErrWALNotTruncated) Unwrap() error {
+	return e.Err
+}
+
+// appendWAL records a Set of the pending version as
+// uvarint(version) uvarint(len(key)) key uvarint(len(val)) val.
+func (tree *BASSparseMerkleTree) appendWAL(key, val []byte) error {
+	var buf bytes.Buffer
+	putUvarint(&buf, tree.version+1)
+	putBytes(&buf, key)
+	putBytes(&buf, val)
+	tree.walLock.Lock()
+	defer tree.walLock.Unlock()
+	_, err := tree.wal.Write(buf.Bytes())
+	return err
+}
+
+// truncateWAL empties the log once its entries are committed.
+func (tree *BASSparseMerkleTree) truncateWAL() error {
+	tree.walLock.Lock()
+	defer tree.walLock.Unlock()
+	if err := tree.wal.Truncate(0); err != nil {
+		return err
+	}
+	_, err := tree.wal.Seek(0, io.SeekStart)
+	return err
+}
+
+// RecoverFromWAL replays the Sets logged for the pending version into the
+// tree, in order. Entries of already committed versions are skipped, and
+// replaying the same log twice yields the same state since the last Set of
+// a key wins. A truncated final entry, left by a crash mid-write, is ignored.
+func (tree *BASSparseMerkleTree) RecoverFromWAL(r io.Reader) error {
+	br := bufio.NewReader(r)
+	readBytes := func() ([]byte, error) {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size)
+		_, err = io.ReadFull(br, b)
+		return b, err
+	}
+	for {
+		version, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key, err := readBytes()
+		if err != nil {
+			return tolerateTornWrite(err)
+		}
+		val, err := readBytes()
+		if err != nil {
+			return tolerateTornWrite(err)
+		}
+		if version != tree.version+1 {
+			continue
+		}
+		// The entries are already in the log, so they are not logged again.
+		// The log does not tell a Delete from a Set of the empty leaf, which
+		// is replayed as a deletion.
+		deleted := bytes.Equal(val, tree.nilHashes[tree.maxDepth])
+		if err := tree.set(key, val, false, deleted); err != nil {
+			return err
+		}
+	}
+}
+
+func tolerateTornWrite(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package bsmt
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestFileErrorsAreReturned points the file operations of the tree, the WAL
+// and the streaming export, at a file that cannot be written and expects
+// errors rather than panics or a half-applied Set.
+func TestFileErrorsAreReturned(t *testing.T) {
+	f, err := ioutil.TempFile("", "bsmt-readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	readOnly, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)), WithWAL(readOnly))
+	key := testKey(1)
+	if err := tree.Set(key, testValue(1)); err == nil {
+		t.Fatal("Set succeeded without logging to the WAL")
+	}
+	if tree.PendingCount() != 0 {
+		t.Fatal("Set was staged although its WAL append failed")
+	}
+	if val, err := tree.Get(key, nil); err != nil || !tree.IsEmpty(key) {
+		t.Fatalf("got %x, %v for a Set whose WAL append failed", val, err)
+	}
+	if err := tree.ExportStreaming(readOnly); err == nil {
+		t.Fatal("export to a read-only file succeeded")
+	}
+}
+
+// memWAL is an in-memory WAL whose Truncate fails while failTruncate is set.
+type memWAL struct {
+	bytes.Buffer
+	failTruncate bool
+}
+
+func (w *memWAL) Seek(offset int64, whence int) (int64, error) {
+	return int64(w.Len()), nil
+}
+
+func (w *memWAL) Truncate(size int64) error {
+	if w.failTruncate {
+		return errBackend
+	}
+	w.Buffer.Truncate(int(size))
+	return nil
+}
+
+func TestCommitWithFailingWALTruncate(t *testing.T) {
+	wal := &memWAL{}
+	db := NewFastMemoryDB(0)
+	tree := newTestTree(t, WithCustomDB(db), WithWAL(wal))
+	if err := tree.Set(testKey(1), testValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	wal.failTruncate = true
+	version, err := tree.Commit()
+	var notTruncated *ErrWALNotTruncated
+	if !errors.As(err, &notTruncated) || !errors.Is(err, errBackend) || notTruncated.Version != 1 {
+		t.Fatalf("got %v, want ErrWALNotTruncated for version 1", err)
+	}
+	if version != 1 || tree.LatestVersion() != 1 || tree.PendingCount() != 0 {
+		t.Fatalf("got version %d, latest %d with %d pending, want version 1 committed",
+			version, tree.LatestVersion(), tree.PendingCount())
+	}
+	if !bytes.Equal(tree.CommittedRoot(), newTestTree(t, WithCustomDB(db)).CommittedRoot()) {
+		t.Fatal("committed root differs from the one in the db")
+	}
+
+	// The stale entries are skipped on replay, and the next commit creates
+	// the next version and empties the log.
+	if err := tree.RecoverFromWAL(bytes.NewReader(wal.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if tree.PendingCount() != 0 {
+		t.Fatal("entries of a committed version were replayed")
+	}
+	wal.failTruncate = false
+	if err := tree.Set(testKey(2), testValue(2)); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := tree.Commit(); err != nil || version != 2 {
+		t.Fatalf("got version %d, %v, want version 2", version, err)
+	}
+	if wal.Len() != 0 {
+		t.Fatal("log is not empty after a commit")
+	}
+}
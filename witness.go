@@ -0,0 +1,154 @@
+package bsmt
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Witness is a self-contained inclusion statement for a single key. Only
+// the siblings and helper bits of Proof are encoded; its key, version and
+// leaf are those of the witness, and its root is supplied by the verifier.
+type Witness struct {
+	Key     []byte
+	Val     []byte
+	Version Version
+	Proof   Proof
+}
+
+// Encode packs the witness as
+//
+//	uvarint(len(key)) key uvarint(len(val)) val uvarint(version)
+//	uvarint(len(siblings)) nil-bitmap {uvarint(len(sibling)) sibling}
+//	uvarint(len(helper)) {uvarint(helper)}
+//
+// where empty siblings are recorded only in the bitmap.
+func (w *Witness) Encode() []byte {
+	var buf bytes.Buffer
+	putBytes(&buf, w.Key)
+	putBytes(&buf, w.Val)
+	putUvarint(&buf, uint64(w.Version))
+
+	siblings := w.Proof.MerkleProof
+	putUvarint(&buf, uint64(len(siblings)))
+	bitmap := make([]byte, (len(siblings)+7)/8)
+	for i := range siblings {
+		if len(siblings[i]) == 0 {
+			bitmap[i/8] |= 1 << (uint(i) % 8)
+		}
+	}
+	buf.Write(bitmap)
+	for i := range siblings {
+		if len(siblings[i]) != 0 {
+			putBytes(&buf, siblings[i])
+		}
+	}
+
+	putUvarint(&buf, uint64(len(w.Proof.ProofHelper)))
+	for _, helper := range w.Proof.ProofHelper {
+		putUvarint(&buf, uint64(helper))
+	}
+	return buf.Bytes()
+}
+
+// DecodeWitness unpacks a witness produced by Encode.
+func DecodeWitness(data []byte) (*Witness, error) {
+	r := bytes.NewReader(data)
+	w := &Witness{}
+	var err error
+	if w.Key, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if w.Val, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrInvalidWitness
+	}
+	w.Version = Version(version)
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len())*8 {
+		return nil, ErrInvalidWitness
+	}
+	bitmap := make([]byte, (count+7)/8)
+	if _, err := r.Read(bitmap); err != nil && len(bitmap) > 0 {
+		return nil, ErrInvalidWitness
+	}
+	w.Proof.MerkleProof = make([][]byte, count)
+	for i := range w.Proof.MerkleProof {
+		if bitmap[i/8]&(1<<(uint(i)%8)) != 0 {
+			w.Proof.MerkleProof[i] = []byte{}
+			continue
+		}
+		if w.Proof.MerkleProof[i], err = readBytes(r); err != nil {
+			return nil, err
+		}
+		// An empty sibling must be marked in the bitmap, not spelled out.
+		if len(w.Proof.MerkleProof[i]) == 0 {
+			return nil, ErrInvalidWitness
+		}
+	}
+
+	count, err = binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len()) {
+		return nil, ErrInvalidWitness
+	}
+	w.Proof.ProofHelper = make([]int, count)
+	for i := range w.Proof.ProofHelper {
+		helper, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrInvalidWitness
+		}
+		w.Proof.ProofHelper[i] = int(helper)
+	}
+	if r.Len() != 0 {
+		return nil, ErrInvalidWitness
+	}
+	w.Proof.Key, w.Proof.Version, w.Proof.Leaf = w.Key, w.Version, w.Val
+	return w, nil
+}
+
+func putUvarint(buf *bytes.Buffer, x uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], x)])
+}
+
+func putBytes(buf *bytes.Buffer, b []byte) {
+	putUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > uint64(r.Len()) {
+		return nil, ErrInvalidWitness
+	}
+	b := make([]byte, size)
+	r.Read(b)
+	return b, nil
+}
+
+// GetWitness returns the encoded witness of key at version.
+func (tree *BASSparseMerkleTree) GetWitness(key []byte, version *Version) ([]byte, error) {
+	proof, err := tree.GetProof(key, version)
+	if err != nil {
+		return nil, err
+	}
+	w := &Witness{Key: key, Val: proof.Leaf, Version: proof.Version, Proof: proof}
+	return w.Encode(), nil
+}
+
+// VerifyWitness decodes witness and verifies its proof against root,
+// returning the attested key and value.
+func (tree *BASSparseMerkleTree) VerifyWitness(witness []byte, root []byte) ([]byte, []byte, bool) {
+	w, err := DecodeWitness(witness)
+	if err != nil {
+		return nil, nil, false
+	}
+	w.Proof.Root = root
+	if !tree.VerifyProof(w.Proof) {
+		return nil, nil, false
+	}
+	return w.Key, w.Val, true
+}
@@ -0,0 +1,55 @@
+package bsmt
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWitness(t *testing.T) {
+	tree := newTestTree(t, WithCustomDB(NewFastMemoryDB(0)))
+	roots := commitVersions(t, tree, 32)
+	for v := Version(1); v <= 3; v++ {
+		witness, err := tree.GetWitness(testKey(5), &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, val, ok := tree.VerifyWitness(witness, roots[v])
+		if !ok || !bytes.Equal(key, testKey(5)) || !bytes.Equal(val, testValue(5*int(v))) {
+			t.Fatalf("version %d: got %x, %x, %v, want the key and its value", v, key, val, ok)
+		}
+		if _, _, ok := tree.VerifyWitness(witness, roots[v%3+1]); ok {
+			t.Fatalf("version %d: the witness verifies against another root", v)
+		}
+		w, err := DecodeWitness(witness)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Version != v {
+			t.Fatalf("witness of version %d decodes as version %d", v, w.Version)
+		}
+	}
+
+	witness, err := tree.GetWitness(testKey(5), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Empty siblings take one bit each, so a sparse tree's witness is far
+	// smaller than its siblings spelled out.
+	if full := int(tree.maxDepth) * tree.hasher.Size(); len(witness) >= full/4 {
+		t.Fatalf("witness takes %d bytes, want much less than the %d of all siblings", len(witness), full)
+	}
+	for n := 0; n < len(witness); n++ {
+		if _, err := DecodeWitness(witness[:n]); err == nil {
+			t.Fatalf("a witness truncated to %d bytes decodes", n)
+		}
+	}
+	if _, err := DecodeWitness(append(append([]byte{}, witness...), 0)); err != ErrInvalidWitness {
+		t.Fatalf("got %v with a trailing byte, want ErrInvalidWitness", err)
+	}
+	// The last sibling ends before the count and 64 helper bytes.
+	tampered := append([]byte{}, witness...)
+	tampered[len(tampered)-66] ^= 1
+	if _, _, ok := tree.VerifyWitness(tampered, roots[3]); ok {
+		t.Fatal("a witness with a changed sibling verifies")
+	}
+}